@@ -0,0 +1,104 @@
+package v2beta3
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ComplianceReportResourceName = "compliancereports"
+	ComplianceReportKind         = "ComplianceReport"
+	ComplianceReportListKind     = "ComplianceReportList"
+)
+
+var (
+	ComplianceReportGroupVersionResource = GroupVersion.WithResource(ComplianceReportResourceName)
+)
+
+// ComplianceReportEntities counts the entities of an audit
+type ComplianceReportEntities struct {
+	// Audited is the number of validated entities
+	Audited int `json:"audited"`
+	// Compliant is the number of entities without violations
+	Compliant int `json:"compliant"`
+	// Violating is the number of entities with at least one violation
+	Violating int `json:"violating"`
+	// Failed is the number of entities that failed to be validated
+	Failed int `json:"failed"`
+}
+
+// ComplianceReportViolations counts the violations of an audit
+type ComplianceReportViolations struct {
+	Total int `json:"total"`
+	// ByPolicy is the number of violations of each policy id
+	//+optional
+	ByPolicy map[string]int `json:"byPolicy,omitempty"`
+	// BySeverity is the number of violations of each policy severity
+	//+optional
+	BySeverity map[string]int `json:"bySeverity,omitempty"`
+	// ByNamespace is the number of violations of each namespace, cluster scoped entities are not included
+	//+optional
+	ByNamespace map[string]int `json:"byNamespace,omitempty"`
+}
+
+// ComplianceReportResource is a violating resource and the number of its violations
+type ComplianceReportResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	//+optional
+	Namespace  string `json:"namespace,omitempty"`
+	Violations int    `json:"violations"`
+}
+
+// ComplianceReportSpec is the result of a completed audit
+type ComplianceReportSpec struct {
+	// Trigger is the audit type, e.g. initial-audit, periodic-audit
+	Trigger string `json:"trigger"`
+	// Kinds are the kinds audited by the audit of a schedule, all the kinds are audited if not set
+	//+optional
+	Kinds []string `json:"kinds,omitempty"`
+	// ExcludedKinds are the kinds audited by the other schedules and skipped by the audit
+	//+optional
+	ExcludedKinds []string                   `json:"excludedKinds,omitempty"`
+	StartTime     metav1.Time                `json:"startTime"`
+	EndTime       metav1.Time                `json:"endTime"`
+	Entities      ComplianceReportEntities   `json:"entities"`
+	Violations    ComplianceReportViolations `json:"violations"`
+	// Errors are the list and validation errors of the audit
+	//+optional
+	Errors []string `json:"errors,omitempty"`
+	// TopViolatingResources are the resources with the most violations
+	//+optional
+	TopViolatingResources []ComplianceReportResource `json:"topViolatingResources,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Trigger",type=string,JSONPath=`.spec.trigger`
+// +kubebuilder:printcolumn:name="Audited",type=integer,JSONPath=`.spec.entities.audited`
+// +kubebuilder:printcolumn:name="Violating",type=integer,JSONPath=`.spec.entities.violating`
+// +kubebuilder:printcolumn:name="Violations",type=integer,JSONPath=`.spec.violations.total`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ComplianceReport is the Schema for the compliancereports API
+type ComplianceReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ComplianceReportSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:storageversion
+
+// ComplianceReportList contains a list of ComplianceReport
+type ComplianceReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ComplianceReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ComplianceReport{}, &ComplianceReportList{})
+}
@@ -26,6 +26,169 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComplianceReport) DeepCopyInto(out *ComplianceReport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComplianceReport.
+func (in *ComplianceReport) DeepCopy() *ComplianceReport {
+	if in == nil {
+		return nil
+	}
+	out := new(ComplianceReport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ComplianceReport) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComplianceReportEntities) DeepCopyInto(out *ComplianceReportEntities) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComplianceReportEntities.
+func (in *ComplianceReportEntities) DeepCopy() *ComplianceReportEntities {
+	if in == nil {
+		return nil
+	}
+	out := new(ComplianceReportEntities)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComplianceReportList) DeepCopyInto(out *ComplianceReportList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ComplianceReport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComplianceReportList.
+func (in *ComplianceReportList) DeepCopy() *ComplianceReportList {
+	if in == nil {
+		return nil
+	}
+	out := new(ComplianceReportList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ComplianceReportList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComplianceReportResource) DeepCopyInto(out *ComplianceReportResource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComplianceReportResource.
+func (in *ComplianceReportResource) DeepCopy() *ComplianceReportResource {
+	if in == nil {
+		return nil
+	}
+	out := new(ComplianceReportResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComplianceReportSpec) DeepCopyInto(out *ComplianceReportSpec) {
+	*out = *in
+	if in.Kinds != nil {
+		in, out := &in.Kinds, &out.Kinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedKinds != nil {
+		in, out := &in.ExcludedKinds, &out.ExcludedKinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.EndTime.DeepCopyInto(&out.EndTime)
+	out.Entities = in.Entities
+	in.Violations.DeepCopyInto(&out.Violations)
+	if in.Errors != nil {
+		in, out := &in.Errors, &out.Errors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TopViolatingResources != nil {
+		in, out := &in.TopViolatingResources, &out.TopViolatingResources
+		*out = make([]ComplianceReportResource, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComplianceReportSpec.
+func (in *ComplianceReportSpec) DeepCopy() *ComplianceReportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ComplianceReportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComplianceReportViolations) DeepCopyInto(out *ComplianceReportViolations) {
+	*out = *in
+	if in.ByPolicy != nil {
+		in, out := &in.ByPolicy, &out.ByPolicy
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.BySeverity != nil {
+		in, out := &in.BySeverity, &out.BySeverity
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ByNamespace != nil {
+		in, out := &in.ByNamespace, &out.ByNamespace
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComplianceReportViolations.
+func (in *ComplianceReportViolations) DeepCopy() *ComplianceReportViolations {
+	if in == nil {
+		return nil
+	}
+	out := new(ComplianceReportViolations)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Policy) DeepCopyInto(out *Policy) {
 	*out = *in
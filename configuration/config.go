@@ -1,30 +1,136 @@
 package configuration
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/weaveworks/policy-agent/pkg/logger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-type SinksConfig struct {
-	FilesystemSink       *FileSystemSink
-	FluxNotificationSink *FluxNotificationSink
-	K8sEventsSink        *K8sEventsSink
-	ElasticSink          *ElasticSink
+// SinkConfig is a sink of a mode
+type SinkConfig struct {
+	// Type is the registered type of the sink, e.g. elasticsearch
+	Type string
+	// Name identifies the sink in the metrics and the dead letter files, the type is used if not set
+	Name   string
+	Filter *SinkFilter
+	Dedup  SinkDedup
+	// Config is the configuration of the sink type
+	Config map[string]interface{}
+	// Env sets configuration keys from environment variables, e.g. password: ELASTIC_PASSWORD, so that the
+	// credentials are read from secrets instead of the configuration file. Nested keys are separated by dots
+	Env map[string]string
 }
 
-type K8sEventsSink struct {
+// redactedKeys are the keys of the sinks configurations holding credentials
+var redactedKeys = map[string]bool{
+	"password": true,
+	"secret":   true,
+	"dsn":      true,
+	"token":    true,
+	"headers":  true,
+}
+
+const redactedValue = "[redacted]"
+
+// resolveEnv sets the configuration keys of the sink from their environment variables
+func (s *SinkConfig) resolveEnv() error {
+	for key, name := range s.Env {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return fmt.Errorf("environment variable %s of %s sink key %s is not set", name, s.Type, key)
+		}
+		if s.Config == nil {
+			s.Config = map[string]interface{}{}
+		}
+		config := s.Config
+		path := strings.Split(strings.ToLower(key), ".")
+		for _, field := range path[:len(path)-1] {
+			nested, ok := config[field].(map[string]interface{})
+			if !ok {
+				nested = map[string]interface{}{}
+				config[field] = nested
+			}
+			config = nested
+		}
+		config[path[len(path)-1]] = value
+	}
+	return nil
+}
+
+// redacted returns a copy of the sink configuration without the credentials
+func (s SinkConfig) redacted() SinkConfig {
+	config := make(map[string]interface{}, len(s.Config))
+	for key, value := range s.Config {
+		config[key] = value
+		if redactedKeys[strings.ToLower(key)] {
+			config[key] = redactedValue
+		}
+	}
+	for key := range s.Env {
+		config[strings.ToLower(strings.Split(key, ".")[0])] = redactedValue
+	}
+	s.Config = config
+	return s
+}
+
+func redactSinks(sinks []SinkConfig) []SinkConfig {
+	if sinks == nil {
+		return nil
+	}
+	redacted := make([]SinkConfig, len(sinks))
+	for i := range sinks {
+		redacted[i] = sinks[i].redacted()
+	}
+	return redacted
+}
+
+type SinksBuffer struct {
+	// Size is the number of results buffered by each sink
+	Size int
+	// Overflow is the policy applied when the buffer is full, block, drop-oldest or drop-newest
+	Overflow   string
+	DeadLetter SinksDeadLetter
+}
+
+type SinksDeadLetter struct {
+	// Directory of the files the overflowed and undeliverable results are spilled to, the results are dropped if not set
+	Directory string
+	// MaxSizeMB is the maximum size of the dead letter file of a sink in megabytes
+	MaxSizeMB      int64
+	ReplayInterval time.Duration
+}
+
+// SinkDedup configures the deduplication of the results written to a sink
+type SinkDedup struct {
 	Enabled bool
+	// ReminderInterval is the interval of writing again an unchanged violation, unchanged violations are not written again if not set
+	ReminderInterval time.Duration
+	// TTL is the time the last result of a policy and resource is kept after its last validation (default: 24h)
+	TTL time.Duration
 }
 
-type FileSystemSink struct {
-	FileName string
+// SinkFilter selects the results written to a sink, all results are written if not set
+type SinkFilter struct {
+	// Statuses are the written result statuses, Violation, Compliance or Resolved
+	Statuses []string
+	// MinSeverity is the minimum severity of the policies of the written results, low, medium or high
+	MinSeverity string
+	Categories  []string
+	Tags        []string
+	PolicyIDs   []string
+	Namespaces  SinkFilterNamespaces
 }
 
-type FluxNotificationSink struct {
-	Address string
+type SinkFilterNamespaces struct {
+	Include []string
+	Exclude []string
 }
 
 type AdmissionWebhook struct {
@@ -32,31 +138,119 @@ type AdmissionWebhook struct {
 	CertDir string
 }
 
-type ElasticSink struct {
-	IndexName     string
-	Address       string
-	Username      string
-	Password      string
-	InsertionMode string
+type AdmissionResponse struct {
+	Template       string
+	DocsURL        string
+	MaxMessageSize int
+}
+
+type AdmissionCache struct {
+	Enabled bool
+	Size    int
+	Dedupe  bool
+}
+
+type AdmissionBudget struct {
+	Timeout       time.Duration
+	Margin        time.Duration
+	FailurePolicy string
 }
 
 type AdmissionConfig struct {
+	Enabled     bool
+	Webhook     AdmissionWebhook
+	Sinks       []SinkConfig
+	SinksBuffer SinksBuffer
+	Mutate      bool
+	Response    AdmissionResponse
+	Cache       AdmissionCache
+	Budget      AdmissionBudget
+}
+
+type AuditSchedule struct {
+	Kinds    []string
+	Schedule string
+}
+
+type AuditWorkers struct {
+	Sources     int
+	Validations int
+	ListQPS     float64
+	ListBurst   int
+}
+
+type AuditScope struct {
+	AllowedGroups []string
+	AllowedKinds  []string
+	DeniedGroups  []string
+	DeniedKinds   []string
+	LabelSelector string
+	// OwnedKinds are the kinds of the owned resources that are audited, "*" audits all owned resources
+	OwnedKinds []string
+}
+
+type AuditComplianceReport struct {
+	Enabled bool
+	// Retention is the number of reports kept, all reports are kept if not set
+	Retention    int
+	TopResources int
+}
+
+type AuditDiscovery struct {
+	// Interval is the interval of refreshing the audited resources, they are not refreshed periodically if not set
+	Interval time.Duration
+	// WatchCRDs refreshes the audited resources when CRDs are created, changed or deleted
+	WatchCRDs bool
+}
+
+type AuditCheckpointConfigMap struct {
+	// Namespace of the ConfigMap, the agent namespace is used if not set
+	Namespace string
+	Name      string
+}
+
+type AuditCheckpoint struct {
 	Enabled bool
-	Webhook AdmissionWebhook
-	Sinks   SinksConfig
-	Mutate  bool
+	// File is the path of the checkpoint file, the checkpoint is stored in a ConfigMap if not set
+	File      string
+	ConfigMap AuditCheckpointConfigMap
 }
 
 type AuditConfig struct {
 	WriteCompliance bool
 	Enabled         bool
-	Sinks           SinksConfig
-	Interval        uint
+	Sinks           []SinkConfig
+	SinksBuffer     SinksBuffer
+	// Interval is the audit interval in hours, used when Schedule is not set
+	Interval         uint
+	Schedule         string
+	Schedules        []AuditSchedule
+	Continuous       bool
+	Workers          AuditWorkers
+	ComplianceReport AuditComplianceReport
+	Scope            AuditScope
+	Discovery        AuditDiscovery
+	Checkpoint       AuditCheckpoint
+	// ManifestsPaths are files and directories of manifests audited with the cluster resources
+	ManifestsPaths []string
 }
 
 type TFAdmissionConfig struct {
+	Enabled     bool
+	Sinks       []SinkConfig
+	SinksBuffer SinksBuffer
+}
+
+type TracingConfig struct {
 	Enabled bool
-	Sinks   SinksConfig
+	// Endpoint is the OTLP collector host and port, e.g. otel-collector:4317
+	Endpoint string
+	// Protocol is the OTLP protocol, grpc or http
+	Protocol    string
+	Insecure    bool
+	Headers     map[string]string
+	SampleRatio float64
+	ServiceName string
 }
 
 type Config struct {
@@ -69,9 +263,28 @@ type Config struct {
 	ProbesListen   string
 	MetricsAddress string
 
+	ExcludeNamespaces []string
+	NamespaceSelector *metav1.LabelSelector
+
 	Admission   AdmissionConfig
 	Audit       AuditConfig
 	TFAdmission TFAdmissionConfig
+	Tracing     TracingConfig
+}
+
+// Redacted returns a copy of the configuration without the sinks credentials and the tracing headers, to be logged
+func (c Config) Redacted() Config {
+	c.Admission.Sinks = redactSinks(c.Admission.Sinks)
+	c.Audit.Sinks = redactSinks(c.Audit.Sinks)
+	c.TFAdmission.Sinks = redactSinks(c.TFAdmission.Sinks)
+	if len(c.Tracing.Headers) > 0 {
+		headers := make(map[string]string, len(c.Tracing.Headers))
+		for key := range c.Tracing.Headers {
+			headers[key] = redactedValue
+		}
+		c.Tracing.Headers = headers
+	}
+	return c
 }
 
 func GetAgentConfiguration(filePath string) Config {
@@ -95,9 +308,35 @@ func GetAgentConfiguration(filePath string) Config {
 	viper.SetDefault("logLevel", "info")
 	viper.SetDefault("admission.webhook.listen", 8443)
 	viper.SetDefault("admission.webhook.certDir", "/certs")
+	viper.SetDefault("admission.budget.timeout", "5s")
+	viper.SetDefault("admission.budget.margin", "500ms")
+	viper.SetDefault("admission.budget.failurePolicy", "Fail")
 	viper.SetDefault("audit.interval", 24)
+	viper.SetDefault("audit.workers.sources", 4)
+	viper.SetDefault("audit.workers.validations", 10)
+	viper.SetDefault("audit.workers.listQPS", 10)
+	viper.SetDefault("audit.workers.listBurst", 20)
+	viper.SetDefault("audit.complianceReport.retention", 10)
+	viper.SetDefault("audit.complianceReport.topResources", 10)
+	viper.SetDefault("audit.discovery.interval", "10m")
+	viper.SetDefault("audit.discovery.watchCRDs", true)
+	viper.SetDefault("audit.checkpoint.configMap.name", "policy-agent-audit-checkpoint")
+	for _, mode := range []string{"audit", "admission", "tfAdmission"} {
+		viper.SetDefault(mode+".sinksBuffer.size", 1000)
+		viper.SetDefault(mode+".sinksBuffer.deadLetter.maxSizeMB", 100)
+		viper.SetDefault(mode+".sinksBuffer.deadLetter.replayInterval", "1m")
+	}
+	// audit results are not dropped, admission responses are not blocked by slow sinks
+	viper.SetDefault("audit.sinksBuffer.overflow", "block")
+	viper.SetDefault("admission.sinksBuffer.overflow", "drop-oldest")
+	viper.SetDefault("tfAdmission.sinksBuffer.overflow", "drop-oldest")
+	viper.SetDefault("tracing.protocol", "grpc")
+	viper.SetDefault("tracing.sampleRatio", 1.0)
+	viper.SetDefault("tracing.serviceName", "policy-agent")
+	viper.SetDefault("excludeNamespaces", []string{metav1.NamespaceSystem, metav1.NamespacePublic})
 
 	checkRequiredFields()
+	migrateSinksFormat()
 
 	c := Config{}
 
@@ -105,32 +344,27 @@ func GetAgentConfiguration(filePath string) Config {
 	if err != nil {
 		logger.Fatal(err)
 	}
-
-	if c.Admission.Enabled && c.Admission.Sinks.ElasticSink != nil {
-		c.Admission.Sinks.ElasticSink.Address = getField(
-			"admission.sinks.elasticSink.address")
-		c.Admission.Sinks.ElasticSink.IndexName = getField(
-			"admission.sinks.elasticSink.indexname")
-		c.Admission.Sinks.ElasticSink.Username = getField(
-			"admission.sinks.elasticSink.username")
-		c.Admission.Sinks.ElasticSink.Password = getField(
-			"admission.sinks.elasticSink.password")
-	}
-
-	if c.Audit.Enabled && c.Audit.Sinks.ElasticSink != nil {
-		c.Audit.Sinks.ElasticSink.Address = getField(
-			"audit.sinks.elasticSink.address")
-		c.Audit.Sinks.ElasticSink.IndexName = getField(
-			"audit.sinks.elasticSink.indexname")
-		c.Audit.Sinks.ElasticSink.Username = getField(
-			"audit.sinks.elasticSink.username")
-		c.Audit.Sinks.ElasticSink.Password = getField(
-			"audit.sinks.elasticSink.password")
+	err = c.resolveSinksEnv()
+	if err != nil {
+		logger.Fatal(err)
 	}
 
 	return c
 }
 
+// resolveSinksEnv sets the sinks configuration keys from their environment variables
+func (c *Config) resolveSinksEnv() error {
+	for _, sinks := range [][]SinkConfig{c.Admission.Sinks, c.Audit.Sinks, c.TFAdmission.Sinks} {
+		for i := range sinks {
+			err := sinks[i].resolveEnv()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func checkRequiredFields() {
 	requiredFields := []string{
 		"accountId",
@@ -147,7 +381,76 @@ func checkRequiredFields() {
 	}
 }
 
-func getField(key string) string {
-	value, _ := viper.Get(key).(string)
-	return value
+// legacySinkTypes are the sink types of the deprecated sinks configured by type, e.g. sinks.k8sEventsSink,
+// the keys are lower case as viper keys are case insensitive
+var legacySinkTypes = map[string]string{
+	"filesystemsink":       "filesystem",
+	"fluxnotificationsink": "flux_notification",
+	"k8seventssink":        "k8s_events",
+	"elasticsink":          "elasticsearch",
+}
+
+// migrateSinksFormat translates the deprecated sinks configured by type, e.g. sinks.k8sEventsSink, to the list
+// of type, name and config entries of the mode sinks
+func migrateSinksFormat() {
+	for _, mode := range []string{"audit", "admission", "tfAdmission"} {
+		legacy, ok := viper.Get(mode + ".sinks").(map[string]interface{})
+		if !ok {
+			continue
+		}
+		sinks, err := migrateSinks(legacy)
+		if err != nil {
+			logger.Fatalw("invalid sinks configuration", "key", mode+".sinks", "error", err)
+		}
+		logger.Warnw(
+			"deprecated sinks configuration, the sinks should be a list of type, name and config entries",
+			"key", mode+".sinks",
+			"sinks", sinks,
+		)
+		viper.Set(mode+".sinks", sinks)
+	}
+}
+
+// migrateSinks returns the sinks entries of the deprecated sinks configured by type, the k8s events sink
+// is only added when enabled
+func migrateSinks(legacy map[string]interface{}) ([]interface{}, error) {
+	keys := make([]string, 0, len(legacy))
+	for key := range legacy {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sinks := []interface{}{}
+	for _, key := range keys {
+		sinkType, ok := legacySinkTypes[strings.ToLower(key)]
+		if !ok {
+			return nil, fmt.Errorf("unknown sink %s", key)
+		}
+		config, ok := legacy[key].(map[string]interface{})
+		if !ok && legacy[key] != nil {
+			return nil, fmt.Errorf("invalid %s sink configuration", key)
+		}
+		if sinkType == "k8s_events" {
+			if enabled, _ := config["enabled"].(bool); !enabled {
+				continue
+			}
+			config = nil
+		}
+		sink := map[string]interface{}{"type": sinkType}
+		if len(config) > 0 {
+			sink["config"] = config
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// DedupEnabled checks if the results written to any of the sinks are deduplicated
+func DedupEnabled(sinks []SinkConfig) bool {
+	for i := range sinks {
+		if sinks[i].Dedup.Enabled {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,108 @@
+package configuration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinkConfig_resolveEnv(t *testing.T) {
+	assert := require.New(t)
+	t.Setenv("ELASTIC_PASSWORD", "password")
+	t.Setenv("WEBHOOK_TOKEN", "Bearer token")
+
+	sink := SinkConfig{
+		Type:   "elasticsearch",
+		Config: map[string]interface{}{"address": "http://elasticsearch:9200"},
+		Env:    map[string]string{"password": "ELASTIC_PASSWORD", "headers.Authorization": "WEBHOOK_TOKEN"},
+	}
+	assert.Nil(sink.resolveEnv())
+	assert.Equal(map[string]interface{}{
+		"address":  "http://elasticsearch:9200",
+		"password": "password",
+		"headers":  map[string]interface{}{"authorization": "Bearer token"},
+	}, sink.Config)
+
+	sink = SinkConfig{Type: "database", Env: map[string]string{"dsn": "DATABASE_DSN"}}
+	assert.Error(sink.resolveEnv(), "missing environment variable should fail")
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	assert := require.New(t)
+	config := Config{
+		Audit: AuditConfig{Sinks: []SinkConfig{{
+			Type: "webhook",
+			Config: map[string]interface{}{
+				"url":     "https://example.com",
+				"secret":  "secret",
+				"headers": map[string]interface{}{"authorization": "Bearer token"},
+				"token":   "token",
+			},
+			Env: map[string]string{"url": "WEBHOOK_URL"},
+		}}},
+		Tracing: TracingConfig{Headers: map[string]string{"authorization": "Bearer token"}},
+	}
+
+	redacted := config.Redacted()
+	assert.Equal(map[string]interface{}{
+		"url":     redactedValue,
+		"secret":  redactedValue,
+		"headers": redactedValue,
+		"token":   redactedValue,
+	}, redacted.Audit.Sinks[0].Config)
+	assert.Equal(map[string]string{"authorization": redactedValue}, redacted.Tracing.Headers)
+	assert.Equal("secret", config.Audit.Sinks[0].Config["secret"], "configuration should not be changed")
+	assert.Equal("Bearer token", config.Tracing.Headers["authorization"])
+}
+
+func TestMigrateSinksFormat(t *testing.T) {
+	assert := require.New(t)
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+audit:
+  sinks:
+    k8sEventsSink:
+      enabled: true
+    fileSystemSink:
+      fileName: audit.json
+admission:
+  sinks:
+    k8sEventsSink:
+      enabled: false
+    elasticSink:
+      address: http://elasticsearch:9200
+      indexName: policy-agent
+tfAdmission:
+  sinks:
+  - type: flux_notification
+    config:
+      address: http://notification-controller
+`))
+	assert.Nil(err)
+
+	migrateSinksFormat()
+
+	c := Config{}
+	assert.Nil(viper.Unmarshal(&c))
+	assert.Equal([]SinkConfig{
+		{Type: "filesystem", Config: map[string]interface{}{"filename": "audit.json"}},
+		{Type: "k8s_events"},
+	}, c.Audit.Sinks)
+	assert.Equal([]SinkConfig{
+		{Type: "elasticsearch", Config: map[string]interface{}{
+			"address":   "http://elasticsearch:9200",
+			"indexname": "policy-agent",
+		}},
+	}, c.Admission.Sinks, "disabled k8s events sink should not be added")
+	assert.Equal([]SinkConfig{
+		{Type: "flux_notification", Config: map[string]interface{}{"address": "http://notification-controller"}},
+	}, c.TFAdmission.Sinks, "list sinks should not be changed")
+
+	_, err = migrateSinks(map[string]interface{}{"unknownsink": map[string]interface{}{}})
+	assert.Error(err, "unknown sink should fail")
+}
@@ -0,0 +1,39 @@
+{{/*
+Namespace selector of the admission and mutation webhooks.
+Excludes the agent namespace (or the top level excludeNamespaces) in addition to
+the agent's config.excludeNamespaces and applies the agent's config.namespaceSelector.
+*/}}
+{{- define "policy-agent.namespaceSelector" -}}
+namespaceSelector:
+  {{- with .Values.config.namespaceSelector }}
+  {{- with .matchLabels }}
+  matchLabels:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+  {{- end }}
+  matchExpressions:
+  - key: kubernetes.io/metadata.name
+    operator: NotIn
+    values:
+    {{- if .Values.excludeNamespaces }}
+    {{- toYaml .Values.excludeNamespaces | nindent 4 }}
+    {{- else }}
+    - {{ .Release.Namespace }}
+    {{- end }}
+    {{- with .Values.config.excludeNamespaces }}
+    {{- toYaml . | nindent 4 }}
+    {{- end }}
+  {{- with .Values.config.namespaceSelector }}
+  {{- with .matchExpressions }}
+  {{- toYaml . | nindent 2 }}
+  {{- end }}
+  {{- end }}
+{{- end }}
+
+{{/*
+Name of the audit checkpoint ConfigMap, the agent is only allowed to access this ConfigMap
+in the release namespace.
+*/}}
+{{- define "policy-agent.checkpointConfigMap" -}}
+{{- dig "audit" "checkpoint" "configMap" "name" "policy-agent-audit-checkpoint" .Values.config -}}
+{{- end }}
@@ -3,39 +3,57 @@ package admission
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/internal/namespace"
+	"github.com/weaveworks/policy-agent/internal/tracing"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/validation"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"go.opentelemetry.io/otel/attribute"
 	ctrl "sigs.k8s.io/controller-runtime"
 	ctrlAdmission "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 // AdmissionHandler listens to admission requests and validates them using a validator
 type AdmissionHandler struct {
-	logLevel  string
-	validator validation.Validator
+	logLevel        string
+	validator       validation.Validator
+	formatter       *ResponseFormatter
+	namespaceFilter *namespace.Filter
+	cache           *ValidationCache
+	budget          LatencyBudget
 }
 
 const (
-	TypeAdmission               = "Admission"
-	DebugLevel                  = "debug"
-	ExcludedefaultNamespacesMsg = "default kubernetes namespaces are excluded"
-	ErrGettingAdmissionEntity   = "failed to get entity info from admission request"
-	ErrValidatingResource       = "failed to validate resource"
+	TypeAdmission             = "Admission"
+	DebugLevel                = "debug"
+	ExcludedNamespaceMsg      = "resource namespace is excluded"
+	ErrGettingAdmissionEntity = "failed to get entity info from admission request"
+	ErrValidatingResource     = "failed to validate resource"
+	ErrFilteringNamespace     = "failed to check if resource namespace is excluded"
+	ErrBudgetExceeded         = "policies evaluation exceeded the admission latency budget, skipped enforced policies"
 )
 
 // NewAdmissionHandler returns an admission handler that listens to k8s validating requests
 func NewAdmissionHandler(
 	logLevel string,
-	validator validation.Validator) *AdmissionHandler {
+	validator validation.Validator,
+	formatter *ResponseFormatter,
+	namespaceFilter *namespace.Filter,
+	cache *ValidationCache,
+	budget LatencyBudget) *AdmissionHandler {
 	return &AdmissionHandler{
-		logLevel:  logLevel,
-		validator: validator,
+		logLevel:        logLevel,
+		validator:       validator,
+		formatter:       formatter,
+		namespaceFilter: namespaceFilter,
+		cache:           cache,
+		budget:          budget,
 	}
 }
 
@@ -43,14 +61,42 @@ func (a *AdmissionHandler) handleErrors(err error, errMsg string) ctrlAdmission.
 	logger.Errorw("validating admission request error", "error", err, "error-message", errMsg)
 	errRsp := ctrlAdmission.ValidationResponse(false, errMsg)
 	errRsp.Result.Code = http.StatusInternalServerError
-	return errRsp
+	return withAuditAnnotations(errRsp, DecisionError, nil)
 }
 
 // Handle validates admission requests, implements interface at sigs.k8s.io/controller-runtime/pkg/webhook/admission.Handler
 func (a *AdmissionHandler) Handle(ctx context.Context, req ctrlAdmission.Request) ctrlAdmission.Response {
-	namespace := req.Namespace
-	if namespace == metav1.NamespacePublic || namespace == metav1.NamespaceSystem {
-		return ctrlAdmission.ValidationResponse(true, ExcludedefaultNamespacesMsg)
+	ctx, span := tracing.Start(ctx, "AdmissionHandler.Handle",
+		attribute.String("admission.uid", string(req.UID)),
+		attribute.String("admission.operation", string(req.Operation)),
+		attribute.String("entity.kind", req.Kind.Kind),
+		attribute.String("entity.namespace", req.Namespace),
+		attribute.String("entity.name", req.Name),
+	)
+	resp := a.handle(ctx, req)
+	decision := resp.AuditAnnotations[AuditAnnotationDecision]
+	metrics.AdmissionDecisions.WithLabelValues(decision).Inc()
+	span.SetAttributes(
+		attribute.Bool("admission.allowed", resp.Allowed),
+		attribute.String("admission.decision", decision),
+	)
+	var err error
+	// validation responses set the error message as the result reason
+	if decision == DecisionError && resp.Result != nil {
+		err = errors.New(string(resp.Result.Reason))
+	}
+	tracing.End(span, err)
+	return resp
+}
+
+func (a *AdmissionHandler) handle(ctx context.Context, req ctrlAdmission.Request) ctrlAdmission.Response {
+	excluded, err := a.namespaceFilter.Excluded(ctx, req.Namespace)
+	if err != nil {
+		return a.handleErrors(err, ErrFilteringNamespace)
+	}
+	if excluded {
+		resp := ctrlAdmission.ValidationResponse(true, ExcludedNamespaceMsg)
+		return withAuditAnnotations(resp, DecisionSkipped, nil)
 	}
 
 	if a.logLevel == DebugLevel {
@@ -58,17 +104,35 @@ func (a *AdmissionHandler) Handle(ctx context.Context, req ctrlAdmission.Request
 	}
 
 	var entitySpec map[string]interface{}
-	err := json.Unmarshal(req.Object.Raw, &entitySpec)
+	err = json.Unmarshal(req.Object.Raw, &entitySpec)
 	if err != nil {
 		return a.handleErrors(err, ErrGettingAdmissionEntity)
 	}
 
 	entity := domain.NewEntityFromSpec(entitySpec)
-	result, err := a.validator.Validate(ctx, entity, string(req.AdmissionRequest.Operation))
+	budgetCtx, cancel := a.budget.context(ctx)
+	defer cancel()
+	result, err := a.validate(budgetCtx, entity, entitySpec, string(req.AdmissionRequest.Operation))
 	if err != nil {
 		return a.handleErrors(err, ErrValidatingResource)
 	}
 
+	var skippedEnforcedPolicies []string
+	if len(result.SkippedPolicies) > 0 {
+		var skipped []string
+		for _, policy := range result.SkippedPolicies {
+			skipped = append(skipped, policy.ID)
+		}
+		logger.Warnw(
+			"admission latency budget exceeded, deciding on completed policies evaluations",
+			"kind", entity.Kind,
+			"name", entity.Name,
+			"namespace", entity.Namespace,
+			"skipped-policies", skipped,
+		)
+		skippedEnforcedPolicies = skippedEnforced(result)
+	}
+
 	if len(result.Violations) > 0 {
 		// If a resource has multiple policies evaluated
 		// and any of those policies are violated and has the enforce flag equals true
@@ -81,36 +145,78 @@ func (a *AdmissionHandler) Handle(ctx context.Context, req ctrlAdmission.Request
 			}
 		}
 
-		return ctrlAdmission.ValidationResponse(allowed, generateResponse(result.Violations))
+		if allowed && len(skippedEnforcedPolicies) > 0 {
+			return a.skippedResponse(result, skippedEnforcedPolicies, a.formatter.Format(result.Violations))
+		}
+
+		decision := DecisionAllowed
+		if !allowed {
+			decision = DecisionDenied
+		}
+
+		resp := ctrlAdmission.ValidationResponse(allowed, a.formatter.Format(result.Violations))
+		return withAuditAnnotations(resp, decision, result)
+	}
+
+	if len(skippedEnforcedPolicies) > 0 {
+		return a.skippedResponse(result, skippedEnforcedPolicies, "")
 	}
 
-	return ctrlAdmission.ValidationResponse(true, "")
+	resp := ctrlAdmission.ValidationResponse(true, "")
+	return withAuditAnnotations(resp, DecisionAllowed, result)
 }
 
-// Run starts the admission webhook server
-func (a *AdmissionHandler) Run(mgr ctrl.Manager) error {
-	webhook := ctrlAdmission.Webhook{Handler: a}
-	mgr.GetWebhookServer().Register("/admission", &webhook)
+// skippedResponse decides a request whose enforced policies were not all evaluated within the latency budget
+func (a *AdmissionHandler) skippedResponse(result *domain.PolicyValidationSummary, skippedEnforcedPolicies []string, message string) ctrlAdmission.Response {
+	allowed := a.budget.allowSkipped()
+	decision := DecisionDenied
+	if allowed {
+		decision = DecisionAllowed
+	}
 
-	return nil
+	reason := fmt.Sprintf("%s: %s", ErrBudgetExceeded, strings.Join(skippedEnforcedPolicies, ", "))
+	if message != "" {
+		reason = fmt.Sprintf("%s\n%s", message, reason)
+	}
+	resp := ctrlAdmission.ValidationResponse(allowed, reason)
+	return withAuditAnnotations(resp, decision, result)
 }
 
-func generateResponse(violations []domain.PolicyValidation) string {
-	var buffer strings.Builder
-	for _, violation := range violations {
-		buffer.WriteString("==================================================================\n")
-		buffer.WriteString(fmt.Sprintf("Policy	: %s\n", violation.Policy.ID))
+// validate validates the entity or returns the cached result of an identical request
+func (a *AdmissionHandler) validate(ctx context.Context, entity domain.Entity, entitySpec map[string]interface{}, operation string) (*domain.PolicyValidationSummary, error) {
+	if a.cache == nil {
+		return a.validator.Validate(ctx, entity, operation)
+	}
 
-		if violation.Entity.Namespace == "" {
-			buffer.WriteString(fmt.Sprintf("Entity	: %s/%s\n", strings.ToLower(violation.Entity.Kind), violation.Entity.Name))
-		} else {
-			buffer.WriteString(fmt.Sprintf("Entity	: %s/%s in namespace: %s\n", strings.ToLower(violation.Entity.Kind), violation.Entity.Name, violation.Entity.Namespace))
-		}
+	key, err := a.cache.Key(ctx, operation, entity.Namespace, entitySpec)
+	if err != nil {
+		logger.Warnw("failed to get admission cache key", "error", err)
+		return a.validator.Validate(ctx, entity, operation)
+	}
 
-		buffer.WriteString("Occurrences:\n")
-		for _, occurrence := range violation.Occurrences {
-			buffer.WriteString(fmt.Sprintf("- %s\n", occurrence.Message))
-		}
+	// the policies are not evaluated for the cached results, they are not observed by the evaluation metrics
+	// and are counted by the cache hits metric instead
+	if result, ok := a.cache.Get(key); ok {
+		logger.Debugw("using cached validation result", "kind", entity.Kind, "name", entity.Name, "namespace", entity.Namespace)
+		a.cache.Replay(ctx, entity, operation, result)
+		return result, nil
+	}
+
+	result, err := a.validator.Validate(ctx, entity, operation)
+	if err != nil {
+		return nil, err
+	}
+	// partial results depend on the evaluation time and are not reusable
+	if len(result.SkippedPolicies) == 0 {
+		a.cache.Add(key, *result)
 	}
-	return buffer.String()
+	return result, nil
+}
+
+// Run starts the admission webhook server
+func (a *AdmissionHandler) Run(mgr ctrl.Manager) error {
+	webhook := ctrlAdmission.Webhook{Handler: a, WithContextFunc: tracing.ContextFromRequest}
+	mgr.GetWebhookServer().Register("/admission", &webhook)
+
+	return nil
 }
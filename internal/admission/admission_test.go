@@ -3,21 +3,36 @@ package admission
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/policy-agent/internal/admission/testdata"
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/internal/namespace"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain/mock"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/validation"
 	validationmock "github.com/weaveworks/policy-agent/pkg/policy-core/validation/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	ctrlAdmission "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -25,10 +40,13 @@ func TestNewAdmissionHandler(t *testing.T) {
 	type args struct {
 		logLevel  string
 		validator validation.Validator
+		formatter *ResponseFormatter
 	}
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	validator := validationmock.NewMockValidator(ctrl)
+	formatter, err := NewResponseFormatter("", "", 0)
+	require.Nil(t, err)
 	tests := []struct {
 		name string
 		args args
@@ -39,16 +57,18 @@ func TestNewAdmissionHandler(t *testing.T) {
 			args: args{
 				logLevel:  "info",
 				validator: validator,
+				formatter: formatter,
 			},
 			want: &AdmissionHandler{
 				logLevel:  "info",
 				validator: validator,
+				formatter: formatter,
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewAdmissionHandler(tt.args.logLevel, tt.args.validator); !reflect.DeepEqual(got, tt.want) {
+			if got := NewAdmissionHandler(tt.args.logLevel, tt.args.validator, tt.args.formatter, nil, nil, LatencyBudget{}); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewAdmissionHandler() = %v, want %v", got, tt.want)
 			}
 		})
@@ -56,6 +76,10 @@ func TestNewAdmissionHandler(t *testing.T) {
 }
 
 func TestAdmissionHandler_Handle(t *testing.T) {
+	formatter, err := NewResponseFormatter("", "", 0)
+	require.Nil(t, err)
+	namespaceFilter, err := namespace.NewFilter(nil, []string{metav1.NamespaceSystem, metav1.NamespacePublic}, nil)
+	require.Nil(t, err)
 	tests := []struct {
 		name         string
 		body         []byte
@@ -72,6 +96,13 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 						Reason: "",
 						Code:   http.StatusOK,
 					},
+					AuditAnnotations: map[string]string{
+						AuditAnnotationDecision:          DecisionAllowed,
+						AuditAnnotationEvaluatedPolicies: "",
+						AuditAnnotationViolatedPolicies:  "",
+						AuditAnnotationEnforcedPolicies:  "",
+						AuditAnnotationViolations:        "",
+					},
 				},
 			},
 			loadStubs: func(val *validationmock.MockValidator) {
@@ -89,6 +120,10 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 						Reason: ErrGettingAdmissionEntity,
 						Code:   http.StatusInternalServerError,
 					},
+					AuditAnnotations: map[string]string{
+						AuditAnnotationDecision: DecisionError,
+						AuditAnnotationReason:   ErrGettingAdmissionEntity,
+					},
 				},
 			},
 			loadStubs: func(val *validationmock.MockValidator) {
@@ -103,9 +138,13 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 				AdmissionResponse: v1.AdmissionResponse{
 					Allowed: true,
 					Result: &metav1.Status{
-						Reason: ExcludedefaultNamespacesMsg,
+						Reason: ExcludedNamespaceMsg,
 						Code:   http.StatusOK,
 					},
+					AuditAnnotations: map[string]string{
+						AuditAnnotationDecision: DecisionSkipped,
+						AuditAnnotationReason:   ExcludedNamespaceMsg,
+					},
 				},
 			},
 			loadStubs: func(val *validationmock.MockValidator) {
@@ -123,6 +162,10 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 						Reason: ErrValidatingResource,
 						Code:   http.StatusInternalServerError,
 					},
+					AuditAnnotations: map[string]string{
+						AuditAnnotationDecision: DecisionError,
+						AuditAnnotationReason:   ErrValidatingResource,
+					},
 				},
 			},
 			loadStubs: func(val *validationmock.MockValidator) {
@@ -137,14 +180,23 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 				AdmissionResponse: v1.AdmissionResponse{
 					Allowed: false,
 					Result: &metav1.Status{
-						Reason: metav1.StatusReason(generateResponse([]domain.PolicyValidation{
+						Reason: metav1.StatusReason(formatter.Format([]domain.PolicyValidation{
 							{
+								ID:       "violation-1",
 								Message:  "violation",
+								Policy:   domain.Policy{ID: "policy-1"},
 								Enforced: true,
 							},
 						})),
 						Code: http.StatusForbidden,
 					},
+					AuditAnnotations: map[string]string{
+						AuditAnnotationDecision:          DecisionDenied,
+						AuditAnnotationEvaluatedPolicies: "policy-1,policy-2",
+						AuditAnnotationViolatedPolicies:  "policy-1",
+						AuditAnnotationEnforcedPolicies:  "policy-1",
+						AuditAnnotationViolations:        "violation-1",
+					},
 				},
 			},
 			loadStubs: func(val *validationmock.MockValidator) {
@@ -152,10 +204,18 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 					Times(1).Return(&domain.PolicyValidationSummary{
 					Violations: []domain.PolicyValidation{
 						{
+							ID:       "violation-1",
 							Message:  "violation",
+							Policy:   domain.Policy{ID: "policy-1"},
 							Enforced: true,
 						},
 					},
+					Compliances: []domain.PolicyValidation{
+						{
+							ID:     "compliance-1",
+							Policy: domain.Policy{ID: "policy-2"},
+						},
+					},
 				}, nil)
 			},
 		},
@@ -166,14 +226,23 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 				AdmissionResponse: v1.AdmissionResponse{
 					Allowed: true,
 					Result: &metav1.Status{
-						Reason: metav1.StatusReason(generateResponse([]domain.PolicyValidation{
+						Reason: metav1.StatusReason(formatter.Format([]domain.PolicyValidation{
 							{
+								ID:       "violation-1",
 								Message:  "violation",
+								Policy:   domain.Policy{ID: "policy-1"},
 								Enforced: false,
 							},
 						})),
 						Code: http.StatusOK,
 					},
+					AuditAnnotations: map[string]string{
+						AuditAnnotationDecision:          DecisionAllowed,
+						AuditAnnotationEvaluatedPolicies: "policy-1",
+						AuditAnnotationViolatedPolicies:  "policy-1",
+						AuditAnnotationEnforcedPolicies:  "",
+						AuditAnnotationViolations:        "violation-1",
+					},
 				},
 			},
 			loadStubs: func(val *validationmock.MockValidator) {
@@ -181,7 +250,9 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 					Times(1).Return(&domain.PolicyValidationSummary{
 					Violations: []domain.PolicyValidation{
 						{
+							ID:       "violation-1",
 							Message:  "violation",
+							Policy:   domain.Policy{ID: "policy-1"},
 							Enforced: false,
 						},
 					},
@@ -197,38 +268,463 @@ func TestAdmissionHandler_Handle(t *testing.T) {
 			validator := validationmock.NewMockValidator(ctrl)
 			tt.loadStubs(validator)
 			a := &AdmissionHandler{
-				logLevel:  "debug",
-				validator: validator,
+				logLevel:        "debug",
+				validator:       validator,
+				formatter:       formatter,
+				namespaceFilter: namespaceFilter,
 			}
 			var req ctrlAdmission.Request
 			err := json.Unmarshal(tt.body, &req)
 			assert.Nil(err, "failed to read admission request body test case")
+			decision := tt.wantResponse.AuditAnnotations[AuditAnnotationDecision]
+			decisions := testutil.ToFloat64(metrics.AdmissionDecisions.WithLabelValues(decision))
 			resp := a.Handle(context.Background(), req)
 			assert.Equal(tt.wantResponse, resp, "unexpected admission response")
+			assert.Equal(decisions+1, testutil.ToFloat64(metrics.AdmissionDecisions.WithLabelValues(decision)), "decision should be counted once")
 		})
 	}
 }
 
 func TestGeneratingMessage(t *testing.T) {
+	violatingKey := "spec.replicas"
 	violations := []domain.PolicyValidation{
 		{
 			Policy: domain.Policy{
-				ID: "policy-1",
+				ID:         "policy-1",
+				Severity:   "high",
+				HowToSolve: "increase replicas",
 			},
 			Entity: domain.Entity{
 				Name:      "entity-1",
 				Namespace: "namespace-1",
 			},
 			Occurrences: []domain.Occurrence{
-				{Message: "occurrence-1"},
+				{Message: "occurrence-1", ViolatingKey: &violatingKey},
 				{Message: "occurrence-2"},
 			},
+			Enforced: true,
 		},
 	}
-	response := generateResponse(violations)
+	formatter, err := NewResponseFormatter("", "https://docs.example.com/policies/{{ .ID }}", 0)
+	require.Nil(t, err)
+
+	response := formatter.Format(violations)
 	assert.Equal(t, strings.Contains(response, violations[0].Policy.ID), true)
 	assert.Equal(t, strings.Contains(response, violations[0].Entity.Name), true)
 	assert.Equal(t, strings.Contains(response, violations[0].Entity.Namespace), true)
 	assert.Equal(t, strings.Contains(response, violations[0].Occurrences[0].Message), true)
 	assert.Equal(t, strings.Contains(response, violations[0].Occurrences[1].Message), true)
+	assert.Equal(t, strings.Contains(response, violations[0].Policy.Severity), true)
+	assert.Equal(t, strings.Contains(response, violations[0].Policy.HowToSolve), true)
+	assert.Equal(t, strings.Contains(response, violatingKey), true)
+	assert.Equal(t, strings.Contains(response, "https://docs.example.com/policies/policy-1"), true)
+	assert.Equal(t, strings.Contains(response, "1 violation(s) found, 1 enforced"), true)
+}
+
+func TestGeneratingMessageCustomTemplate(t *testing.T) {
+	violations := []domain.PolicyValidation{
+		{Policy: domain.Policy{ID: "policy-1", Severity: "high"}},
+		{Policy: domain.Policy{ID: "policy-2", Severity: "low"}},
+	}
+	formatter, err := NewResponseFormatter(
+		`{{ range .Violations }}{{ .Policy.ID }}:{{ upper .Policy.Severity }};{{ end }}total={{ .Total }}`, "", 0)
+	require.Nil(t, err)
+	assert.Equal(t, "policy-1:HIGH;policy-2:LOW;total=2", formatter.Format(violations))
+
+	_, err = NewResponseFormatter("{{ .Violations ", "", 0)
+	assert.NotNil(t, err)
+}
+
+func TestGeneratingMessageTruncation(t *testing.T) {
+	var violations []domain.PolicyValidation
+	for i := 0; i < 100; i++ {
+		violations = append(violations, domain.PolicyValidation{
+			Policy:      domain.Policy{ID: fmt.Sprintf("policy-%d", i)},
+			Occurrences: []domain.Occurrence{{Message: strings.Repeat("x", 100)}},
+		})
+	}
+	formatter, err := NewResponseFormatter("", "", 1024)
+	require.Nil(t, err)
+
+	response := formatter.Format(violations)
+	assert.LessOrEqual(t, len(response), 1024)
+	assert.True(t, strings.HasSuffix(response, "message truncated, 100 violation(s) in total"))
+}
+
+type fakeVersions struct {
+	policies      uint64
+	policyConfigs uint64
+}
+
+func (f *fakeVersions) PoliciesVersion() uint64 {
+	return f.policies
+}
+
+func (f *fakeVersions) PolicyConfigsVersion() uint64 {
+	return f.policyConfigs
+}
+
+func TestValidationCache_Key(t *testing.T) {
+	assert := require.New(t)
+	versions := &fakeVersions{}
+	cache := NewValidationCache(10, true, false, versions, nil)
+
+	object := map[string]interface{}{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name":            "nginx",
+			"resourceVersion": "1",
+			"generation":      float64(1),
+		},
+		"spec": map[string]interface{}{"replicas": float64(1)},
+	}
+	key, err := cache.Key(context.Background(), "UPDATE", "", object)
+	assert.Nil(err)
+
+	object["metadata"].(map[string]interface{})["resourceVersion"] = "2"
+	object["metadata"].(map[string]interface{})["generation"] = float64(2)
+	sameKey, err := cache.Key(context.Background(), "UPDATE", "", object)
+	assert.Nil(err)
+	assert.Equal(key, sameKey, "volatile metadata should not change the key")
+
+	otherOperation, err := cache.Key(context.Background(), "CREATE", "", object)
+	assert.Nil(err)
+	assert.NotEqual(key, otherOperation, "operation should change the key")
+
+	object["spec"].(map[string]interface{})["replicas"] = float64(2)
+	changedSpec, err := cache.Key(context.Background(), "UPDATE", "", object)
+	assert.Nil(err)
+	assert.NotEqual(key, changedSpec, "object content should change the key")
+
+	versions.policies++
+	changedPolicies, err := cache.Key(context.Background(), "UPDATE", "", object)
+	assert.Nil(err)
+	assert.NotEqual(changedSpec, changedPolicies, "policies version should change the key")
+
+	versions.policyConfigs++
+	changedConfigs, err := cache.Key(context.Background(), "UPDATE", "", object)
+	assert.Nil(err)
+	assert.NotEqual(changedPolicies, changedConfigs, "policy configs version should change the key")
+}
+
+func TestValidationCache_KeyNamespaceLabels(t *testing.T) {
+	assert := require.New(t)
+	scheme := runtime.NewScheme()
+	assert.Nil(corev1.AddToScheme(scheme))
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-ns"}}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ns).Build()
+	cache := NewValidationCache(10, true, false, &fakeVersions{}, reader)
+
+	object := map[string]interface{}{
+		"kind":     "Deployment",
+		"metadata": map[string]interface{}{"name": "nginx", "namespace": "tenant-ns"},
+	}
+	key, err := cache.Key(context.Background(), "CREATE", "tenant-ns", object)
+	assert.Nil(err)
+
+	ns.Labels = map[string]string{"toolkit.fluxcd.io/tenant": "tenant"}
+	assert.Nil(reader.Update(context.Background(), ns))
+	labeled, err := cache.Key(context.Background(), "CREATE", "tenant-ns", object)
+	assert.Nil(err)
+	assert.NotEqual(key, labeled, "namespace labels should change the key")
+
+	_, err = cache.Key(context.Background(), "CREATE", "missing-ns", object)
+	assert.Nil(err, "missing namespace should have no labels")
+}
+
+func TestValidationCache_Replay(t *testing.T) {
+	result := domain.PolicyValidationSummary{
+		Violations:  []domain.PolicyValidation{{ID: "violation-1", Policy: domain.Policy{ID: "policy-1"}}},
+		Compliances: []domain.PolicyValidation{{ID: "compliance-1", Policy: domain.Policy{ID: "policy-2"}}},
+	}
+	entity := domain.Entity{Kind: "Deployment", Name: "nginx", Namespace: "default"}
+
+	tests := []struct {
+		name            string
+		dedupe          bool
+		writeCompliance bool
+		want            []string
+	}{
+		{
+			name: "replay violations",
+			want: []string{"policy-1"},
+		},
+		{
+			name:            "replay violations and compliances",
+			writeCompliance: true,
+			want:            []string{"policy-1", "policy-2"},
+		},
+		{
+			name:            "skip sinks when deduped",
+			dedupe:          true,
+			writeCompliance: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			var written []string
+			sink := mock.NewMockPolicyValidationSink(ctrl)
+			sink.EXPECT().Write(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+				func(_ context.Context, results []domain.PolicyValidation) error {
+					for _, result := range results {
+						assert.NotEqual("violation-1", result.ID, "replayed results should be new results")
+						assert.Equal(entity, result.Entity)
+						written = append(written, result.Policy.ID)
+					}
+					return nil
+				})
+
+			cache := NewValidationCache(10, tt.dedupe, tt.writeCompliance, &fakeVersions{}, nil, sink)
+			cached := result
+			cache.Replay(context.Background(), entity, "CREATE", &cached)
+			assert.Equal(tt.want, written)
+		})
+	}
+}
+
+func TestValidationCache_Eviction(t *testing.T) {
+	assert := require.New(t)
+	cache := NewValidationCache(2, true, false, &fakeVersions{}, nil)
+
+	cache.Add("a", domain.PolicyValidationSummary{})
+	cache.Add("b", domain.PolicyValidationSummary{})
+	_, ok := cache.Get("a")
+	assert.True(ok)
+
+	cache.Add("c", domain.PolicyValidationSummary{})
+	_, ok = cache.Get("b")
+	assert.False(ok, "least recently used entry should be evicted")
+	_, ok = cache.Get("a")
+	assert.True(ok)
+	_, ok = cache.Get("c")
+	assert.True(ok)
+}
+
+func TestAdmissionHandler_HandleCached(t *testing.T) {
+	formatter, err := NewResponseFormatter("", "", 0)
+	require.Nil(t, err)
+
+	violations := []domain.PolicyValidation{
+		{
+			ID:       "violation-1",
+			Policy:   domain.Policy{ID: "policy-1"},
+			Enforced: true,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		dedupe     bool
+		sinkWrites int
+	}{
+		{
+			name:       "replay cached violations to sinks",
+			dedupe:     false,
+			sinkWrites: 1,
+		},
+		{
+			name:       "skip sinks on cache hit",
+			dedupe:     true,
+			sinkWrites: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			validator := validationmock.NewMockValidator(ctrl)
+			validator.EXPECT().Validate(gomock.Any(), gomock.Any(), gomock.Any()).
+				Times(1).Return(&domain.PolicyValidationSummary{Violations: violations}, nil)
+			sink := mock.NewMockPolicyValidationSink(ctrl)
+			sink.EXPECT().Write(gomock.Any(), gomock.Any()).Times(tt.sinkWrites).Return(nil)
+
+			cache := NewValidationCache(10, tt.dedupe, false, &fakeVersions{}, nil, sink)
+			a := NewAdmissionHandler("info", validator, formatter, nil, cache, LatencyBudget{})
+
+			var req ctrlAdmission.Request
+			err := json.Unmarshal(testdata.ValidadmissionBody, &req)
+			assert.Nil(err)
+
+			first := a.Handle(context.Background(), req)
+			second := a.Handle(context.Background(), req)
+			assert.False(first.Allowed)
+			assert.False(second.Allowed)
+			assert.Equal(first.Result.Code, second.Result.Code)
+			assert.Equal(DecisionDenied, second.AuditAnnotations[AuditAnnotationDecision])
+		})
+	}
+}
+
+func TestAdmissionHandler_HandleBudgetExceeded(t *testing.T) {
+	formatter, err := NewResponseFormatter("", "", 0)
+	require.Nil(t, err)
+
+	tests := []struct {
+		name          string
+		failurePolicy string
+		result        *domain.PolicyValidationSummary
+		allowed       bool
+		decision      string
+	}{
+		{
+			name:          "skipped enforced policies with fail policy",
+			failurePolicy: FailurePolicyFail,
+			result: &domain.PolicyValidationSummary{
+				SkippedPolicies: []domain.Policy{{ID: "policy-1", Enforce: true}},
+			},
+			allowed:  false,
+			decision: DecisionDenied,
+		},
+		{
+			name:          "skipped enforced policies with ignore policy",
+			failurePolicy: FailurePolicyIgnore,
+			result: &domain.PolicyValidationSummary{
+				SkippedPolicies: []domain.Policy{{ID: "policy-1", Enforce: true}},
+			},
+			allowed:  true,
+			decision: DecisionAllowed,
+		},
+		{
+			name:          "skipped policies are not enforced",
+			failurePolicy: FailurePolicyFail,
+			result: &domain.PolicyValidationSummary{
+				SkippedPolicies: []domain.Policy{{ID: "policy-1"}},
+			},
+			allowed:  true,
+			decision: DecisionAllowed,
+		},
+		{
+			name:          "completed evaluations deny the request",
+			failurePolicy: FailurePolicyIgnore,
+			result: &domain.PolicyValidationSummary{
+				Violations: []domain.PolicyValidation{
+					{
+						ID:       "violation-1",
+						Policy:   domain.Policy{ID: "policy-2", Enforce: true},
+						Enforced: true,
+					},
+				},
+				SkippedPolicies: []domain.Policy{{ID: "policy-1", Enforce: true}},
+			},
+			allowed:  false,
+			decision: DecisionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			validator := validationmock.NewMockValidator(ctrl)
+			validator.EXPECT().Validate(gomock.Any(), gomock.Any(), gomock.Any()).
+				Times(1).Return(tt.result, nil)
+
+			budget := LatencyBudget{Timeout: time.Second, FailurePolicy: tt.failurePolicy}
+			a := NewAdmissionHandler("info", validator, formatter, nil, nil, budget)
+
+			var req ctrlAdmission.Request
+			err := json.Unmarshal(testdata.ValidadmissionBody, &req)
+			assert.Nil(err)
+
+			resp := a.Handle(context.Background(), req)
+			assert.Equal(tt.allowed, resp.Allowed)
+			assert.Equal(tt.decision, resp.AuditAnnotations[AuditAnnotationDecision])
+			assert.Equal("policy-1", resp.AuditAnnotations[AuditAnnotationSkippedPolicies])
+		})
+	}
+}
+
+func TestLatencyBudget_Context(t *testing.T) {
+	assert := require.New(t)
+
+	budget := LatencyBudget{Timeout: time.Second, Margin: 100 * time.Millisecond}
+	ctx, cancel := budget.context(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(ok)
+	assert.WithinDuration(time.Now().Add(900*time.Millisecond), deadline, 50*time.Millisecond)
+
+	requestDeadline := time.Now().Add(3 * time.Second)
+	requestCtx, requestCancel := context.WithDeadline(context.Background(), requestDeadline)
+	defer requestCancel()
+	ctx, cancel = budget.context(requestCtx)
+	defer cancel()
+	deadline, ok = ctx.Deadline()
+	assert.True(ok)
+	assert.Equal(requestDeadline.Add(-100*time.Millisecond), deadline)
+
+	ctx, cancel = LatencyBudget{}.context(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(ok)
+}
+
+func TestAdmissionHandler_HandleTracing(t *testing.T) {
+	assert := require.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	formatter, err := NewResponseFormatter("", "", 0)
+	assert.Nil(err)
+	namespaceFilter, err := namespace.NewFilter(nil, nil, nil)
+	assert.Nil(err)
+
+	// the request trace context is the parent of the handler span, which is the parent of the validation spans
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	var validationSpan trace.SpanContext
+	validator := validationmock.NewMockValidator(ctrl)
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(1).DoAndReturn(func(ctx context.Context, _ domain.Entity, _ string) (*domain.PolicyValidationSummary, error) {
+		validationSpan = trace.SpanContextFromContext(ctx)
+		return &domain.PolicyValidationSummary{
+			Violations: []domain.PolicyValidation{
+				{ID: "violation-1", Policy: domain.Policy{ID: "policy-1"}, Enforced: true},
+			},
+		}, nil
+	})
+	a := &AdmissionHandler{
+		validator:       validator,
+		formatter:       formatter,
+		namespaceFilter: namespaceFilter,
+	}
+
+	var req ctrlAdmission.Request
+	assert.Nil(json.Unmarshal(testdata.ValidadmissionBody, &req))
+	resp := a.Handle(trace.ContextWithRemoteSpanContext(context.Background(), parent), req)
+	assert.False(resp.Allowed)
+
+	spans := recorder.Ended()
+	assert.Len(spans, 1)
+	span := spans[0]
+	assert.Equal("AdmissionHandler.Handle", span.Name())
+	assert.Equal(parent.TraceID(), span.SpanContext().TraceID())
+	assert.Equal(parent.SpanID(), span.Parent().SpanID())
+	assert.Equal(span.SpanContext(), validationSpan)
+	assert.Contains(span.Attributes(), attribute.String("admission.decision", DecisionDenied))
+	assert.Contains(span.Attributes(), attribute.Bool("admission.allowed", false))
+	assert.Equal(codes.Unset, span.Status().Code)
+
+	// the span of a failed request has the error status
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(1).Return(nil, errors.New("validation error"))
+	resp = a.Handle(context.Background(), req)
+	assert.False(resp.Allowed)
+
+	spans = recorder.Ended()
+	assert.Len(spans, 2)
+	span = spans[1]
+	assert.Contains(span.Attributes(), attribute.String("admission.decision", DecisionError))
+	assert.Equal(codes.Error, span.Status().Code)
+	assert.Equal(ErrValidatingResource, span.Status().Description)
 }
@@ -0,0 +1,83 @@
+package admission
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	ctrlAdmission "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Audit annotation keys, the api server prefixes them with the webhook name in the audit log
+const (
+	AuditAnnotationDecision          = "decision"
+	AuditAnnotationEvaluatedPolicies = "evaluated-policies"
+	AuditAnnotationViolatedPolicies  = "violated-policies"
+	AuditAnnotationEnforcedPolicies  = "enforced-policies"
+	AuditAnnotationViolations        = "violations"
+	AuditAnnotationSkippedPolicies   = "skipped-policies"
+	AuditAnnotationReason            = "reason"
+)
+
+// Admission decisions recorded in the audit annotations
+const (
+	DecisionAllowed = "allowed"
+	DecisionDenied  = "denied"
+	DecisionSkipped = "skipped"
+	DecisionError   = "error"
+)
+
+// withAuditAnnotations records the admission decision and the evaluated policies in the response audit annotations
+func withAuditAnnotations(resp ctrlAdmission.Response, decision string, result *domain.PolicyValidationSummary) ctrlAdmission.Response {
+	annotations := map[string]string{
+		AuditAnnotationDecision: decision,
+	}
+
+	if resp.Result != nil && resp.Result.Reason != "" && (decision == DecisionSkipped || decision == DecisionError) {
+		annotations[AuditAnnotationReason] = string(resp.Result.Reason)
+	}
+
+	if result != nil {
+		evaluated := map[string]struct{}{}
+		violated := map[string]struct{}{}
+		enforced := map[string]struct{}{}
+		var violations []string
+
+		for _, compliance := range result.Compliances {
+			evaluated[compliance.Policy.ID] = struct{}{}
+		}
+		for _, violation := range result.Violations {
+			evaluated[violation.Policy.ID] = struct{}{}
+			violated[violation.Policy.ID] = struct{}{}
+			if violation.Enforced {
+				enforced[violation.Policy.ID] = struct{}{}
+			}
+			violations = append(violations, violation.ID)
+		}
+
+		annotations[AuditAnnotationEvaluatedPolicies] = joinKeys(evaluated)
+		annotations[AuditAnnotationViolatedPolicies] = joinKeys(violated)
+		annotations[AuditAnnotationEnforcedPolicies] = joinKeys(enforced)
+		annotations[AuditAnnotationViolations] = strings.Join(violations, ",")
+
+		if len(result.SkippedPolicies) > 0 {
+			skipped := map[string]struct{}{}
+			for _, policy := range result.SkippedPolicies {
+				skipped[policy.ID] = struct{}{}
+			}
+			annotations[AuditAnnotationSkippedPolicies] = joinKeys(skipped)
+		}
+	}
+
+	resp.AuditAnnotations = annotations
+	return resp
+}
+
+func joinKeys(set map[string]struct{}) string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,60 @@
+package admission
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+const (
+	// DefaultBudgetTimeout matches the default timeout of the admission webhook
+	DefaultBudgetTimeout = 5 * time.Second
+	// DefaultBudgetMargin is kept from the budget to build and send the response
+	DefaultBudgetMargin = 500 * time.Millisecond
+)
+
+// Failure policies applied when enforced policies are skipped
+const (
+	FailurePolicyFail   = "Fail"
+	FailurePolicyIgnore = "Ignore"
+)
+
+// LatencyBudget limits the time spent evaluating policies of an admission request
+type LatencyBudget struct {
+	// Timeout is used when the request context has no deadline
+	Timeout time.Duration
+	// Margin is subtracted from the deadline
+	Margin time.Duration
+	// FailurePolicy decides the request when enforced policies are not evaluated within the budget
+	FailurePolicy string
+}
+
+// context returns a context that is done when the budget of the request is exhausted
+func (b LatencyBudget) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		if b.Timeout <= 0 {
+			return context.WithCancel(ctx)
+		}
+		deadline = time.Now().Add(b.Timeout)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-b.Margin))
+}
+
+// allowSkipped returns whether a request with skipped enforced policies is allowed
+func (b LatencyBudget) allowSkipped() bool {
+	return strings.EqualFold(b.FailurePolicy, FailurePolicyIgnore)
+}
+
+// skippedEnforced returns the enforced policies of the skipped policies
+func skippedEnforced(result *domain.PolicyValidationSummary) []string {
+	var policies []string
+	for _, policy := range result.SkippedPolicies {
+		if policy.Enforce {
+			policies = append(policies, policy.ID)
+		}
+	}
+	return policies
+}
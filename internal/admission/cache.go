@@ -0,0 +1,204 @@
+package admission
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"github.com/weaveworks/policy-agent/pkg/uuid-go"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const DefaultCacheSize = 1000
+
+// volatileMetadataFields are metadata fields that change without changing the object content
+var volatileMetadataFields = []string{
+	"resourceVersion",
+	"generation",
+	"uid",
+	"creationTimestamp",
+	"managedFields",
+	"selfLink",
+}
+
+// VersionSource provides the current versions of policies and policy configs
+type VersionSource interface {
+	PoliciesVersion() uint64
+	PolicyConfigsVersion() uint64
+}
+
+type cacheEntry struct {
+	key    string
+	result domain.PolicyValidationSummary
+}
+
+// ValidationCache is an LRU cache of admission validation results keyed by the object content, the labels
+// of its namespace and the versions of policies and policy configs
+type ValidationCache struct {
+	lock            sync.Mutex
+	size            int
+	items           map[string]*list.Element
+	order           *list.List
+	dedupe          bool
+	writeCompliance bool
+	versions        VersionSource
+	namespaces      client.Reader
+	sinks           []domain.PolicyValidationSink
+}
+
+// NewValidationCache returns a validation cache of the given size. When dedupe is false cached
+// results are written again to the sinks on every cache hit, the compliances are written when
+// writeCompliance is set like the validator does. The namespaces reader gets the labels of the
+// objects namespaces, they are not part of the key if it's not set.
+func NewValidationCache(
+	size int,
+	dedupe bool,
+	writeCompliance bool,
+	versions VersionSource,
+	namespaces client.Reader,
+	sinks ...domain.PolicyValidationSink,
+) *ValidationCache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	return &ValidationCache{
+		size:            size,
+		items:           make(map[string]*list.Element),
+		order:           list.New(),
+		dedupe:          dedupe,
+		writeCompliance: writeCompliance,
+		versions:        versions,
+		namespaces:      namespaces,
+		sinks:           sinks,
+	}
+}
+
+// Key returns the cache key of an admission request object, the labels of the object namespace are part of the key
+// as the policy configs matching workspaces and the namespace selectors depend on them
+func (c *ValidationCache) Key(ctx context.Context, operation, namespace string, entitySpec map[string]interface{}) (string, error) {
+	object := runtime.DeepCopyJSON(entitySpec)
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		for _, field := range volatileMetadataFields {
+			delete(metadata, field)
+		}
+	}
+
+	// json encoding of maps is sorted by key which makes the hash stable
+	content, err := json.Marshal(object)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode object: %w", err)
+	}
+
+	namespaceLabels, err := c.namespaceLabels(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(operation))
+	hash.Write(content)
+	hash.Write(namespaceLabels)
+	hash.Write([]byte(fmt.Sprintf("%d/%d", c.versions.PoliciesVersion(), c.versions.PolicyConfigsVersion())))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// namespaceLabels returns the encoded labels of a namespace, missing namespaces have no labels
+func (c *ValidationCache) namespaceLabels(ctx context.Context, namespace string) ([]byte, error) {
+	if c.namespaces == nil || namespace == "" {
+		return nil, nil
+	}
+	var ns v1.Namespace
+	err := c.namespaces.Get(ctx, client.ObjectKey{Name: namespace}, &ns)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+	labels, err := json.Marshal(ns.GetLabels())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode namespace labels: %w", err)
+	}
+	return labels, nil
+}
+
+// Get returns the cached validation result of a key
+func (c *ValidationCache) Get(key string) (*domain.PolicyValidationSummary, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		metrics.AdmissionCacheRequests.WithLabelValues(metrics.CacheResultMiss).Inc()
+		return nil, false
+	}
+	metrics.AdmissionCacheRequests.WithLabelValues(metrics.CacheResultHit).Inc()
+	c.order.MoveToFront(element)
+	result := element.Value.(*cacheEntry).result
+	return &result, true
+}
+
+// Add stores a validation result, evicts the least recently used result if the cache is full
+func (c *ValidationCache) Add(key string, result domain.PolicyValidationSummary) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// mutation results hold the patched resource and are not reusable
+	result.Mutation = nil
+
+	if element, ok := c.items[key]; ok {
+		c.order.MoveToFront(element)
+		element.Value.(*cacheEntry).result = result
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, result: result})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+	metrics.AdmissionCacheSize.Set(float64(c.order.Len()))
+}
+
+// Replay writes the results of a cached result to the sinks as new results of the entity, the violations and the
+// compliances when writeCompliance is set, does nothing if dedupe is enabled
+func (c *ValidationCache) Replay(ctx context.Context, entity domain.Entity, trigger string, result *domain.PolicyValidationSummary) {
+	if c.dedupe {
+		return
+	}
+
+	result.Violations = replayed(result.Violations, entity, trigger)
+	result.Compliances = replayed(result.Compliances, entity, trigger)
+	for _, sink := range c.sinks {
+		if len(result.Violations) > 0 {
+			sink.Write(ctx, result.Violations)
+		}
+		if c.writeCompliance && len(result.Compliances) > 0 {
+			sink.Write(ctx, result.Compliances)
+		}
+	}
+}
+
+// replayed returns copies of the cached results as new results of the entity
+func replayed(results []domain.PolicyValidation, entity domain.Entity, trigger string) []domain.PolicyValidation {
+	if len(results) == 0 {
+		return results
+	}
+	copies := make([]domain.PolicyValidation, len(results))
+	for i, result := range results {
+		result.ID = uuid.NewV4().String()
+		result.Entity = entity
+		result.Trigger = trigger
+		result.CreatedAt = time.Now()
+		copies[i] = result
+	}
+	return copies
+}
@@ -0,0 +1,175 @@
+package admission
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+const (
+	// DefaultMaxMessageSize keeps admission messages well below the size the api server relays back to clients
+	DefaultMaxMessageSize = 16 * 1024
+	truncatedMsg          = "\n... message truncated, %d violation(s) in total"
+)
+
+// DefaultResponseTemplate is the template used to render admission messages when no template is configured
+const DefaultResponseTemplate = `
+{{- range .Violations }}
+==================================================================
+Policy	: {{ .Policy.ID }}
+{{- if .Entity.Namespace }}
+Entity	: {{ lower .Entity.Kind }}/{{ .Entity.Name }} in namespace: {{ .Entity.Namespace }}
+{{- else }}
+Entity	: {{ lower .Entity.Kind }}/{{ .Entity.Name }}
+{{- end }}
+{{- if .Policy.Severity }}
+Severity: {{ .Policy.Severity }}
+{{- end }}
+Occurrences:
+{{- range .Occurrences }}
+- {{ .Message }}{{ if .ViolatingKey }} (violating key: {{ .ViolatingKey }}){{ end }}
+{{- end }}
+{{- if .Policy.HowToSolve }}
+How to solve:
+{{ .Policy.HowToSolve }}
+{{- end }}
+{{- if .DocsURL }}
+Docs	: {{ .DocsURL }}
+{{- end }}
+{{- end }}
+==================================================================
+{{ .Total }} violation(s) found, {{ .Enforced }} enforced
+`
+
+// ResponseData is the data passed to the admission response template
+type ResponseData struct {
+	Violations []ViolationData
+	// Total is the number of violations
+	Total int
+	// Enforced is the number of violations of enforced policies
+	Enforced int
+}
+
+// ViolationData wraps a violation with extra fields available to the admission response template
+type ViolationData struct {
+	domain.PolicyValidation
+	DocsURL       string
+	ViolatingKeys []string
+}
+
+// ResponseFormatter renders violations into admission response messages
+type ResponseFormatter struct {
+	template       *template.Template
+	docsURL        *template.Template
+	maxMessageSize int
+}
+
+// NewResponseFormatter returns a formatter that renders violations using the given go templates.
+// docsURL is a go template evaluated against the violated policy to get its documentation link.
+func NewResponseFormatter(responseTemplate, docsURL string, maxMessageSize int) (*ResponseFormatter, error) {
+	if responseTemplate == "" {
+		responseTemplate = DefaultResponseTemplate
+	}
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+
+	funcs := template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"join":  strings.Join,
+	}
+
+	tmpl, err := template.New("response").Funcs(funcs).Parse(responseTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse admission response template: %w", err)
+	}
+
+	formatter := ResponseFormatter{
+		template:       tmpl,
+		maxMessageSize: maxMessageSize,
+	}
+
+	if docsURL != "" {
+		formatter.docsURL, err = template.New("docs").Funcs(funcs).Parse(docsURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse policy docs url template: %w", err)
+		}
+	}
+
+	return &formatter, nil
+}
+
+// Format renders violations into an admission response message
+func (f *ResponseFormatter) Format(violations []domain.PolicyValidation) string {
+	data := ResponseData{
+		Violations: make([]ViolationData, 0, len(violations)),
+		Total:      len(violations),
+	}
+
+	for _, violation := range violations {
+		if violation.Enforced {
+			data.Enforced++
+		}
+		item := ViolationData{
+			PolicyValidation: violation,
+			DocsURL:          f.getDocsURL(violation.Policy),
+		}
+		for _, occurrence := range violation.Occurrences {
+			if occurrence.ViolatingKey != nil {
+				item.ViolatingKeys = append(item.ViolatingKeys, *occurrence.ViolatingKey)
+			}
+		}
+		data.Violations = append(data.Violations, item)
+	}
+
+	var buffer bytes.Buffer
+	err := f.template.Execute(&buffer, data)
+	if err != nil {
+		logger.Errorw("failed to render admission response template", "error", err)
+		buffer.Reset()
+		buffer.WriteString(fmt.Sprintf("%d policy violation(s) found", len(violations)))
+	}
+
+	return truncate(buffer.String(), f.maxMessageSize, len(violations))
+}
+
+func (f *ResponseFormatter) getDocsURL(policy domain.Policy) string {
+	if f.docsURL == nil {
+		return ""
+	}
+	var buffer bytes.Buffer
+	err := f.docsURL.Execute(&buffer, policy)
+	if err != nil {
+		logger.Errorw("failed to render policy docs url", "policy", policy.ID, "error", err)
+		return ""
+	}
+	return buffer.String()
+}
+
+// truncate cuts the message at the last line that fits in the size limit and marks it as truncated
+func truncate(message string, maxSize, total int) string {
+	if len(message) <= maxSize {
+		return message
+	}
+
+	suffix := fmt.Sprintf(truncatedMsg, total)
+	limit := maxSize - len(suffix)
+	if limit <= 0 {
+		return suffix[:maxSize]
+	}
+
+	cut := message[:limit]
+	if idx := strings.LastIndex(cut, "\n"); idx > 0 {
+		cut = cut[:idx]
+	}
+	for !utf8.ValidString(cut) {
+		cut = cut[:len(cut)-1]
+	}
+	return cut + suffix
+}
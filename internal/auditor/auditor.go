@@ -2,29 +2,84 @@ package auditor
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/validation"
+	"golang.org/x/time/rate"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
-// AuditorController performs audit on a regular interval by using entitites sources to retrieve resources
+// AuditWorkers configures the audit parallelism and the rate of its list calls
+type AuditWorkers struct {
+	// Sources is the number of entities sources audited in parallel
+	Sources int
+	// Validations is the number of entities of each source validated in parallel
+	Validations int
+	// ListQPS and ListBurst limit the list calls of all the sources, no limit is applied if ListQPS is not set
+	ListQPS   float64
+	ListBurst int
+}
+
+func (w AuditWorkers) sources() int {
+	if w.Sources < 1 {
+		return 1
+	}
+	return w.Sources
+}
+
+func (w AuditWorkers) validations() int {
+	if w.Validations < 1 {
+		return 1
+	}
+	return w.Validations
+}
+
+func (w AuditWorkers) limiter() *rate.Limiter {
+	if w.ListQPS <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := w.ListBurst
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(w.ListQPS), burst)
+}
+
+// AuditorController performs audit on schedules by using entitites sources to retrieve resources
 type AuditorController struct {
+	sourcesLock        sync.RWMutex
 	entitiesSources    []domain.EntitiesSource
-	auditEvent         chan AuditEvent
 	validator          validation.Validator
 	auditEventListener AuditEventListener
-	auditInterval      time.Duration
+	reportWriter       ReportWriter
+	resultsPruners     []ResultsPruner
+	checkpointStore    CheckpointStore
+	ownedKinds         []string
+	schedules          []AuditSchedule
+	workers            AuditWorkers
+	listLimiter        *rate.Limiter
+	lock               sync.Mutex
+	pending            []AuditEvent
+	notify             chan struct{}
+	// violatingResources are the violating resources of each policy by kind found by the last complete audits
+	violatingResources map[string]map[string]int
 }
 
 // NewAuditController returns a new instance of AuditController with an audit event listener
-func NewAuditController(validator validation.Validator, auditInterval time.Duration, entitiesSources ...domain.EntitiesSource) *AuditorController {
+func NewAuditController(validator validation.Validator, schedules []AuditSchedule, workers AuditWorkers, entitiesSources ...domain.EntitiesSource) *AuditorController {
 	auditController := &AuditorController{
-		entitiesSources: entitiesSources,
-		auditEvent:      make(chan AuditEvent, 1),
-		validator:       validator,
-		auditInterval:   auditInterval,
+		entitiesSources:    entitiesSources,
+		validator:          validator,
+		schedules:          schedules,
+		workers:            workers,
+		listLimiter:        workers.limiter(),
+		notify:             make(chan struct{}, 1),
+		violatingResources: map[string]map[string]int{},
 	}
 	auditController.auditEventListener = auditController.doAudit
 	return auditController
@@ -35,21 +90,77 @@ func (a *AuditorController) RegisterAuditEventListener(auditEventListener AuditE
 	a.auditEventListener = auditEventListener
 }
 
+// RegisterReportWriter sets the writer of the completed audits reports
+func (a *AuditorController) RegisterReportWriter(reportWriter ReportWriter) {
+	a.reportWriter = reportWriter
+}
+
+// RegisterResultsPruner adds a pruner of the results of the entities that were not validated by the complete audits
+func (a *AuditorController) RegisterResultsPruner(resultsPruner ResultsPruner) {
+	a.resultsPruners = append(a.resultsPruners, resultsPruner)
+}
+
+// RegisterCheckpointStore sets the store of the audits checkpoints, audits of all entities
+// interrupted by a restart are resumed from their checkpoint
+func (a *AuditorController) RegisterCheckpointStore(checkpointStore CheckpointStore) {
+	a.checkpointStore = checkpointStore
+}
+
+// AuditOwnedKinds enables auditing the entities of the given kinds that are owned by other entities,
+// "*" enables auditing all owned entities. Owned entities are skipped by default
+func (a *AuditorController) AuditOwnedKinds(kinds []string) {
+	a.ownedKinds = kinds
+}
+
+// UpdateEntitiesSources adds and removes entities sources, audits that are running keep using the previous sources
+func (a *AuditorController) UpdateEntitiesSources(added, removed []domain.EntitiesSource) {
+	a.sourcesLock.Lock()
+	defer a.sourcesLock.Unlock()
+
+	sources := make([]domain.EntitiesSource, 0, len(a.entitiesSources)+len(added))
+	for _, source := range a.entitiesSources {
+		if !containsSource(removed, source) {
+			sources = append(sources, source)
+		}
+	}
+	a.entitiesSources = append(sources, added...)
+}
+
+func (a *AuditorController) getEntitiesSources() []domain.EntitiesSource {
+	a.sourcesLock.RLock()
+	defer a.sourcesLock.RUnlock()
+	return a.entitiesSources
+}
+
+func containsSource(sources []domain.EntitiesSource, source domain.EntitiesSource) bool {
+	for i := range sources {
+		if sources[i] == source {
+			return true
+		}
+	}
+	return false
+}
+
 // Start starts the audit controller
 func (a *AuditorController) Start(ctx context.Context) error {
 	logger.Info("starting audit controller...")
-	auditTicker := time.NewTicker(a.auditInterval)
-	defer auditTicker.Stop()
+	for i := range a.schedules {
+		go a.run(ctx, a.schedules[i])
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("stopping audit controller...")
 			return nil
-		case <-auditTicker.C:
-			auditEvent := AuditEvent{Type: AuditEventTypePeriodical}
-			a.auditEventListener(ctx, auditEvent)
-		case event := <-a.auditEvent:
-			a.auditEventListener(ctx, event)
+		case <-a.notify:
+			for {
+				event, ok := a.next()
+				if !ok {
+					break
+				}
+				a.auditEventListener(ctx, event)
+			}
 		}
 	}
 }
@@ -57,46 +168,337 @@ func (a *AuditorController) Start(ctx context.Context) error {
 // doAudit lists available entities and performs validation on each entity
 func (a *AuditorController) doAudit(ctx context.Context, auditEvent AuditEvent) {
 	logger.Infof("starting %s", auditEvent.Type)
-	for i := range a.entitiesSources {
-		hasNext := true
-		keySet := ""
-		entitySource := a.entitiesSources[i]
-		for hasNext {
-			opts := domain.ListOptions{
-				Limit:  entitiesSizeLimit,
-				KeySet: keySet,
+	start := time.Now()
+	metrics.AuditRunning.Set(1)
+	defer metrics.AuditRunning.Set(0)
+
+	var scope *AuditScope
+	if auditScope, ok := auditEvent.Data.(AuditScope); ok {
+		scope = &auditScope
+	}
+	report := newAuditReport(auditEvent.Type, scope, start)
+	// audits of all entities and scheduled audits of all the entities of their kinds are resumed after a restart,
+	// audits scoped to changes are not resumed, they are triggered again by the changes
+	scheduled := scope == nil || scope.Scheduled
+	var checkpoint *auditCheckpoint
+	if scheduled {
+		checkpoint = a.loadCheckpoint(ctx, auditEvent.Type, scope, start)
+	}
+	// the violating resources are only known when all the entities of the audited kinds are audited by the same audit
+	complete := scheduled && !checkpoint.resumed()
+
+	entitiesSources := a.getEntitiesSources()
+	sources := make(chan int)
+	var group sync.WaitGroup
+	for i := 0; i < a.workers.sources(); i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for index := range sources {
+				a.auditSource(ctx, entitiesSources[index], index, auditEvent.Type, scope, report, checkpoint)
+			}
+		}()
+	}
+
+schedule:
+	for i := range entitiesSources {
+		entitySource := entitiesSources[i]
+		// sources without a kind list entities of all kinds, their entities are matched one by one
+		kind := entitySource.Kind()
+		if scope != nil && kind != "" && !scope.matchKind(kind) {
+			continue
+		}
+		if checkpoint.source(i, kind).Done {
+			continue
+		}
+		select {
+		case sources <- i:
+		case <-ctx.Done():
+			break schedule
+		}
+	}
+	close(sources)
+	group.Wait()
+
+	logger.Infow("finished audit", "type", auditEvent.Type, "duration", time.Since(start).String())
+
+	if ctx.Err() != nil {
+		// the agent is stopping, the checkpoint is saved to resume the audit after the restart
+		saveCtx, cancel := context.WithTimeout(context.Background(), checkpointSaveTimeout)
+		defer cancel()
+		checkpoint.save(saveCtx)
+		return
+	}
+	if checkpoint != nil {
+		err := a.checkpointStore.Delete(ctx)
+		if err != nil {
+			logger.Errorw("failed to delete audit checkpoint", "type", auditEvent.Type, "error", err)
+		}
+	}
+
+	// the entities of the sources that failed to be listed are not audited, their results are kept
+	if complete && !report.listed() {
+		logger.Warnw("audit failed to list entities, keeping the previous results", "type", auditEvent.Type)
+		complete = false
+	}
+	if complete {
+		a.setViolatingResources(scope, report.violatingResources())
+		a.pruneResults(ctx, scope, start)
+	}
+
+	// reports are only written for completed audits of all the entities of their kinds, the audits scoped to
+	// changes only audit the affected entities
+	if a.reportWriter == nil || !scheduled {
+		return
+	}
+	err := a.reportWriter.WriteReport(ctx, report.finish(time.Now()))
+	if err != nil {
+		logger.Errorw("failed to write audit report", "type", auditEvent.Type, "error", err)
+	}
+}
+
+// pruneResults prunes the results of the audited kinds that were not validated by a complete audit
+func (a *AuditorController) pruneResults(ctx context.Context, scope *AuditScope, start time.Time) {
+	var kinds, excludedKinds []string
+	if scope != nil {
+		kinds, excludedKinds = scope.Kinds, scope.ExcludedKinds
+	}
+	for _, pruner := range a.resultsPruners {
+		err := pruner.PruneResults(ctx, kinds, excludedKinds, start)
+		if err != nil {
+			logger.Errorw("failed to prune audit results", "error", err)
+		}
+	}
+}
+
+// setViolatingResources replaces the violating resources of the kinds audited by a complete audit, the metrics
+// are the violating resources of all the kinds as the schedules audit different kinds
+func (a *AuditorController) setViolatingResources(scope *AuditScope, counts map[string]map[string]int) {
+	for kind := range a.violatingResources {
+		if scope == nil || scope.matchKind(kind) {
+			delete(a.violatingResources, kind)
+		}
+	}
+	for kind, policies := range counts {
+		a.violatingResources[kind] = policies
+	}
+
+	total := map[string]int{}
+	for _, policies := range a.violatingResources {
+		for policy, count := range policies {
+			total[policy] += count
+		}
+	}
+	metrics.SetViolatingResources(total)
+}
+
+// loadCheckpoint returns the checkpoint of an interrupted audit to resume, or a new checkpoint. Audits of all entities
+// resume any interrupted audit, scheduled audits only resume the interrupted audits of their schedule
+func (a *AuditorController) loadCheckpoint(ctx context.Context, auditType AuditEventType, scope *AuditScope, start time.Time) *auditCheckpoint {
+	if a.checkpointStore == nil {
+		return nil
+	}
+	state := Checkpoint{Type: auditType, StartTime: start}
+	if scope != nil {
+		state.Kinds, state.ExcludedKinds = scope.Kinds, scope.ExcludedKinds
+	}
+	checkpoint, err := a.checkpointStore.Load(ctx)
+	if err != nil {
+		logger.Errorw("failed to load audit checkpoint, auditing all entities", "type", auditType, "error", err)
+	}
+	if checkpoint == nil {
+		return newAuditCheckpoint(a.checkpointStore, state)
+	}
+	if scope != nil && !sameKinds(*scope, AuditScope{Kinds: checkpoint.Kinds, ExcludedKinds: checkpoint.ExcludedKinds}) {
+		logger.Infow(
+			"ignoring the checkpoint of an audit of another schedule",
+			"type", auditType,
+			"interrupted-type", checkpoint.Type,
+			"interrupted-start", checkpoint.StartTime.String(),
+		)
+		return newAuditCheckpoint(a.checkpointStore, state)
+	}
+	logger.Infow(
+		"resuming interrupted audit",
+		"type", auditType,
+		"interrupted-type", checkpoint.Type,
+		"interrupted-start", checkpoint.StartTime.String(),
+	)
+	// the resumed audit covers the kinds of the audit
+	checkpoint.Kinds, checkpoint.ExcludedKinds = state.Kinds, state.ExcludedKinds
+	return newAuditCheckpoint(a.checkpointStore, *checkpoint)
+}
+
+// auditSource lists the entities of a source and validates them using the validation workers, the
+// source is audited from its checkpoint if set
+func (a *AuditorController) auditSource(
+	ctx context.Context,
+	entitySource domain.EntitiesSource,
+	index int,
+	auditType AuditEventType,
+	scope *AuditScope,
+	report *auditReport,
+	checkpoint *auditCheckpoint,
+) {
+	kind := entitySource.Kind()
+	start := time.Now()
+	defer func() {
+		metrics.AuditKindDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	}()
+
+	entities := make(chan domain.Entity)
+	var pending, group sync.WaitGroup
+	for i := 0; i < a.workers.validations(); i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for entity := range entities {
+				a.auditEntity(ctx, entity, auditType, report)
+				pending.Done()
+			}
+		}()
+	}
+	defer func() {
+		close(entities)
+		group.Wait()
+	}()
+
+	progress := checkpoint.source(index, kind)
+	restarted := false
+	hasNext := true
+	for hasNext {
+		err := a.listLimiter.Wait(ctx)
+		if err != nil {
+			return
+		}
+		opts := domain.ListOptions{
+			Limit:  entitiesSizeLimit,
+			KeySet: progress.KeySet,
+		}
+		entitiesList, err := entitySource.List(ctx, &opts)
+		if err != nil && progress.KeySet != "" && !restarted && apierrors.IsResourceExpired(err) {
+			logger.Warnw("entities list key set expired, auditing the source from the start", "kind", kind)
+			restarted = true
+			progress.KeySet = ""
+			continue
+		}
+		if err != nil {
+			metrics.AuditListFailures.WithLabelValues(kind).Inc()
+			logger.Errorw("failed to list entities during audit", "kind", kind, "error", err)
+			report.addListError(fmt.Errorf("failed to list %s entities: %w", kind, err))
+			return
+		}
+		hasNext = entitiesList.HasNext
+		metrics.AuditEntitiesListed.WithLabelValues(kind).Add(float64(len(entitiesList.Data)))
+
+		for idx := range entitiesList.Data {
+			entity := entitiesList.Data[idx]
+			if scope != nil && !scope.matchEntity(ctx, entity) {
+				continue
 			}
-			entitiesList, err := entitySource.List(ctx, &opts)
-			if err != nil {
-				logger.Errorw("failed to list entities during audit", "kind", entitySource.Kind(), "error", err)
-				break
+			pending.Add(1)
+			select {
+			case entities <- entity:
+			case <-ctx.Done():
+				pending.Done()
+				return
 			}
-			hasNext = entitiesList.HasNext
-			keySet = entitiesList.KeySet
+		}
 
-			for idx := range entitiesList.Data {
-				entity := entitiesList.Data[idx]
-				if entity.HasParent {
-					continue
-				}
-				_, err := a.validator.Validate(ctx, entity, string(auditEvent.Type))
-				if err != nil {
-					logger.Errorw(
-						"failed to validate entity during audit",
-						"entity-kind", entity.Kind,
-						"entity-name", entity.Name,
-						"error", err)
-				}
+		progress.KeySet = entitiesList.KeySet
+		progress.Done = !hasNext
+		if checkpoint != nil {
+			// the checkpoint moves past the entities of a page once they are validated
+			pending.Wait()
+			if ctx.Err() != nil {
+				return
 			}
+			checkpoint.update(ctx, index, progress)
 		}
 	}
-	logger.Info("finished audit")
 }
 
-// Audit triggers an audit with specified audit type
+// AuditEntity validates a changed entity, used by the continuous audit
+func (a *AuditorController) AuditEntity(ctx context.Context, entity domain.Entity) {
+	a.auditEntity(ctx, entity, AuditEventTypeContinuous, nil)
+}
+
+// auditEntity validates an entity and adds its result to the audit report if set
+func (a *AuditorController) auditEntity(ctx context.Context, entity domain.Entity, auditType AuditEventType, report *auditReport) {
+	if entity.HasParent && !contains(a.ownedKinds, entity.Kind) && !contains(a.ownedKinds, allKinds) {
+		return
+	}
+	summary, err := a.validator.Validate(ctx, entity, string(auditType))
+	if err != nil {
+		metrics.AuditEntitiesFailed.WithLabelValues(entity.Kind).Inc()
+		logger.Errorw(
+			"failed to validate entity during audit",
+			"entity-kind", entity.Kind,
+			"entity-name", entity.Name,
+			"error", err)
+		report.addFailure(fmt.Errorf("failed to validate %s %s/%s: %w", entity.Kind, entity.Namespace, entity.Name, err))
+		return
+	}
+	metrics.AuditEntitiesValidated.WithLabelValues(entity.Kind).Inc()
+	report.addResult(entity, summary)
+}
+
+// Audit triggers an audit with specified audit type, audits triggered while another audit is
+// running are coalesced with the pending audits
 func (a *AuditorController) Audit(auditType AuditEventType, data interface{}) {
-	a.auditEvent <- AuditEvent{
+	a.lock.Lock()
+	a.pending = coalesce(a.pending, AuditEvent{
 		Type: auditType,
 		Data: data,
+	})
+	a.lock.Unlock()
+
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next pops the next pending audit
+func (a *AuditorController) next() (AuditEvent, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.pending) == 0 {
+		return AuditEvent{}, false
+	}
+	event := a.pending[0]
+	a.pending = a.pending[1:]
+	return event, true
+}
+
+// coalesce adds an audit event to the pending events, an audit of all entities covers the scoped
+// audits and scoped audits of the same type are merged
+func coalesce(pending []AuditEvent, event AuditEvent) []AuditEvent {
+	scope, scoped := event.Data.(AuditScope)
+	for i := range pending {
+		pendingScope, pendingScoped := pending[i].Data.(AuditScope)
+		if !pendingScoped {
+			logger.Debugw("coalescing audit with a pending audit of all entities", "type", event.Type, "pending-type", pending[i].Type)
+			return pending
+		}
+		// scheduled audits are kept apart to audit all the entities of their kinds
+		if scoped && (scope.Scheduled || pendingScope.Scheduled) {
+			if scope.Scheduled && pendingScope.Scheduled && sameKinds(scope, pendingScope) {
+				logger.Debugw("coalescing audit with a pending audit of the same schedule", "type", event.Type)
+				return pending
+			}
+			continue
+		}
+		if scoped && pending[i].Type == event.Type {
+			logger.Debugw("coalescing audit with a pending audit", "type", event.Type)
+			pending[i].Data = unionScope(pendingScope, scope)
+			return pending
+		}
+	}
+
+	if !scoped {
+		// the scoped audits are covered by the audit of all entities
+		pending = pending[:0]
 	}
+	return append(pending, event)
 }
@@ -2,12 +2,16 @@ package auditor
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/require"
 	entitiesmock "github.com/weaveworks/policy-agent/internal/entities/mock"
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	validationmock "github.com/weaveworks/policy-agent/pkg/policy-core/validation/mock"
 	"golang.org/x/sync/errgroup"
@@ -17,6 +21,8 @@ const (
 	auditInterval = 2 * time.Second
 )
 
+var auditSchedules = []AuditSchedule{{Schedule: cron.Every(auditInterval)}}
+
 func TestNewAuditController(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -37,7 +43,7 @@ func TestNewAuditController(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			assert := require.New(t)
-			got := NewAuditController(validator, auditInterval, entitiesSource)
+			got := NewAuditController(validator, auditSchedules, AuditWorkers{}, entitiesSource)
 			assert.Equal(test.want.entitiesSources, got.entitiesSources, "unexpected auditor entities source")
 			assert.Equal(test.want.validator, got.validator, "unexpected auditor validator")
 		})
@@ -131,8 +137,9 @@ func TestAuditorController_doAudit(t *testing.T) {
 			defer ctrl.Finish()
 			validator := validationmock.NewMockValidator(ctrl)
 			entitiesSource := entitiesmock.NewMockEntitiesSource(ctrl)
+			entitiesSource.EXPECT().Kind().AnyTimes().Return("Deployment")
 			test.loadStubs(validator, entitiesSource)
-			a := NewAuditController(validator, auditInterval, entitiesSource)
+			a := NewAuditController(validator, auditSchedules, AuditWorkers{}, entitiesSource)
 			auditEvent := AuditEvent{Type: test.args.auditType}
 			a.doAudit(context.Background(), auditEvent)
 		})
@@ -171,7 +178,7 @@ func TestAuditorController_Audit(t *testing.T) {
 			entitiesSource := entitiesmock.NewMockEntitiesSource(ctrl)
 
 			auditEventChan := make(chan AuditEvent, 1)
-			a := NewAuditController(validator, auditInterval, entitiesSource)
+			a := NewAuditController(validator, auditSchedules, AuditWorkers{}, entitiesSource)
 			a.RegisterAuditEventListener(func(ctx context.Context, auditEvent AuditEvent) {
 				auditEventChan <- auditEvent
 			})
@@ -189,3 +196,189 @@ func TestAuditorController_Audit(t *testing.T) {
 		})
 	}
 }
+
+func TestAuditorController_AuditEntity(t *testing.T) {
+	tests := []struct {
+		name        string
+		entity      domain.Entity
+		ownedKinds  []string
+		validations int
+	}{
+		{
+			name:        "validate changed entity",
+			entity:      domain.Entity{Name: "test", Kind: "Deployment"},
+			validations: 1,
+		},
+		{
+			name:        "ignore entity with parents",
+			entity:      domain.Entity{Name: "test", Kind: "ReplicaSet", HasParent: true},
+			validations: 0,
+		},
+		{
+			name:        "validate entity with parents of owned kinds",
+			entity:      domain.Entity{Name: "test", Kind: "Pod", HasParent: true},
+			ownedKinds:  []string{"Pod"},
+			validations: 1,
+		},
+		{
+			name:        "ignore entity with parents of other kinds",
+			entity:      domain.Entity{Name: "test", Kind: "ReplicaSet", HasParent: true},
+			ownedKinds:  []string{"Pod"},
+			validations: 0,
+		},
+		{
+			name:        "validate all entities with parents",
+			entity:      domain.Entity{Name: "test", Kind: "ReplicaSet", HasParent: true},
+			ownedKinds:  []string{"*"},
+			validations: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			validator := validationmock.NewMockValidator(ctrl)
+			validator.EXPECT().Validate(gomock.Any(), test.entity, string(AuditEventTypeContinuous)).
+				Times(test.validations).Return(&domain.PolicyValidationSummary{}, nil)
+
+			a := NewAuditController(validator, auditSchedules, AuditWorkers{})
+			a.AuditOwnedKinds(test.ownedKinds)
+			a.AuditEntity(context.Background(), test.entity)
+		})
+	}
+}
+
+func TestAuditorController_doAuditScoped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	validator := validationmock.NewMockValidator(ctrl)
+	deployments := entitiesmock.NewMockEntitiesSource(ctrl)
+	pods := entitiesmock.NewMockEntitiesSource(ctrl)
+
+	deployments.EXPECT().Kind().AnyTimes().Return("Deployment")
+	deployments.EXPECT().List(gomock.Any(), gomock.Any()).
+		Times(1).Return(&domain.EntitiesList{
+		HasNext: false,
+		Data: []domain.Entity{
+			{Name: "test", Kind: "Deployment", Namespace: "default"},
+			{Name: "test", Kind: "Deployment", Namespace: "other"},
+		},
+	}, nil)
+	pods.EXPECT().Kind().AnyTimes().Return("Pod")
+	pods.EXPECT().List(gomock.Any(), gomock.Any()).Times(0)
+
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), string(AuditEventTypePolicyChange)).
+		Times(1).DoAndReturn(func(_ context.Context, entity domain.Entity, _ string) (*domain.PolicyValidationSummary, error) {
+		require.Equal(t, "default", entity.Namespace)
+		return &domain.PolicyValidationSummary{}, nil
+	})
+
+	a := NewAuditController(validator, auditSchedules, AuditWorkers{}, deployments, pods)
+	a.doAudit(context.Background(), AuditEvent{
+		Type: AuditEventTypePolicyChange,
+		Data: AuditScope{Kinds: []string{"Deployment"}, Namespaces: []string{"default"}},
+	})
+}
+
+func TestAuditorController_UpdateEntitiesSources(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	validator := validationmock.NewMockValidator(ctrl)
+	deployments := entitiesmock.NewMockEntitiesSource(ctrl)
+	pods := entitiesmock.NewMockEntitiesSource(ctrl)
+	widgets := entitiesmock.NewMockEntitiesSource(ctrl)
+
+	deployments.EXPECT().Kind().AnyTimes().Return("Deployment")
+	deployments.EXPECT().List(gomock.Any(), gomock.Any()).
+		Times(1).Return(&domain.EntitiesList{
+		Data: []domain.Entity{{Name: "test", Kind: "Deployment", Namespace: "default"}},
+	}, nil)
+	pods.EXPECT().Kind().AnyTimes().Return("Pod")
+	pods.EXPECT().List(gomock.Any(), gomock.Any()).Times(0)
+	widgets.EXPECT().Kind().AnyTimes().Return("Widget")
+	widgets.EXPECT().List(gomock.Any(), gomock.Any()).
+		Times(1).Return(&domain.EntitiesList{
+		Data: []domain.Entity{{Name: "test", Kind: "Widget", Namespace: "default"}},
+	}, nil)
+
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), string(AuditEventTypeDiscovery)).
+		Times(2).Return(&domain.PolicyValidationSummary{}, nil)
+
+	a := NewAuditController(validator, auditSchedules, AuditWorkers{}, deployments, pods)
+	a.UpdateEntitiesSources([]domain.EntitiesSource{widgets}, []domain.EntitiesSource{pods})
+	require.Equal(t, []domain.EntitiesSource{deployments, widgets}, a.getEntitiesSources())
+
+	a.doAudit(context.Background(), AuditEvent{Type: AuditEventTypeDiscovery})
+}
+
+func TestAuditorController_doAuditParallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	validator := validationmock.NewMockValidator(ctrl)
+
+	var sources []domain.EntitiesSource
+	for _, kind := range []string{"Deployment", "StatefulSet", "DaemonSet"} {
+		source := entitiesmock.NewMockEntitiesSource(ctrl)
+		source.EXPECT().Kind().AnyTimes().Return(kind)
+		source.EXPECT().List(gomock.Any(), gomock.Any()).
+			Times(1).Return(&domain.EntitiesList{
+			HasNext: true,
+			KeySet:  "next",
+			Data: []domain.Entity{
+				{Name: "test-1", Kind: kind},
+				{Name: "test-2", Kind: kind},
+			},
+		}, nil)
+		source.EXPECT().List(gomock.Any(), &domain.ListOptions{Limit: entitiesSizeLimit, KeySet: "next"}).
+			Times(1).Return(&domain.EntitiesList{
+			HasNext: false,
+			Data: []domain.Entity{
+				{Name: "test-3", Kind: kind},
+			},
+		}, nil)
+		sources = append(sources, source)
+	}
+
+	var lock sync.Mutex
+	validated := map[string]int{}
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(9).DoAndReturn(func(_ context.Context, entity domain.Entity, _ string) (*domain.PolicyValidationSummary, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		validated[entity.Kind]++
+		return &domain.PolicyValidationSummary{}, nil
+	})
+
+	workers := AuditWorkers{Sources: 2, Validations: 3, ListQPS: 100, ListBurst: 1}
+	a := NewAuditController(validator, auditSchedules, workers, sources...)
+	a.doAudit(context.Background(), AuditEvent{Type: AuditEventTypePeriodical})
+
+	require.Equal(t, map[string]int{"Deployment": 3, "StatefulSet": 3, "DaemonSet": 3}, validated)
+}
+
+func TestAuditorController_setViolatingResources(t *testing.T) {
+	assert := require.New(t)
+	schedules := []AuditSchedule{{}, {Kinds: []string{"Secret"}}}
+	a := NewAuditController(nil, schedules, AuditWorkers{})
+	violating := func(policy string) float64 {
+		return testutil.ToFloat64(metrics.ViolatingResources.WithLabelValues(policy))
+	}
+
+	a.setViolatingResources(nil, map[string]map[string]int{
+		"Deployment": {"policy-1": 2},
+		"Secret":     {"policy-1": 1, "policy-2": 1},
+	})
+	assert.Equal(3.0, violating("policy-1"))
+	assert.Equal(1.0, violating("policy-2"))
+
+	// the scheduled audits replace the violating resources of their kinds
+	defaultScope := schedules[0].scope(schedules).(AuditScope)
+	a.setViolatingResources(&defaultScope, map[string]map[string]int{"Pod": {"policy-1": 1}})
+	assert.Equal(2.0, violating("policy-1"))
+	assert.Equal(1.0, violating("policy-2"))
+
+	secretsScope := schedules[1].scope(schedules).(AuditScope)
+	a.setViolatingResources(&secretsScope, map[string]map[string]int{})
+	assert.Equal(1.0, violating("policy-1"))
+	assert.Equal(0.0, violating("policy-2"))
+}
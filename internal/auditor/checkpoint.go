@@ -0,0 +1,276 @@
+package auditor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	checkpointKey = "checkpoint.json"
+	// checkpointSaveInterval limits the checkpoint writes, the progress of the last interval
+	// is audited again after a restart
+	checkpointSaveInterval = 5 * time.Second
+	checkpointSaveTimeout  = 5 * time.Second
+)
+
+// Checkpoint is the progress of an audit of all entities, it is used to resume the audit after a restart
+type Checkpoint struct {
+	Type      AuditEventType `json:"type"`
+	StartTime time.Time      `json:"startTime"`
+	// Kinds and ExcludedKinds are the kinds audited by the scheduled audit, all kinds are audited if they are empty
+	Kinds         []string `json:"kinds,omitempty"`
+	ExcludedKinds []string `json:"excludedKinds,omitempty"`
+	// Sources are the progress of the entities sources by their index in the audited sources
+	Sources map[int]SourceCheckpoint `json:"sources"`
+}
+
+// SourceCheckpoint is the progress of an entities source
+type SourceCheckpoint struct {
+	Kind string `json:"kind"`
+	// KeySet is the key set of the next entities to list
+	KeySet string `json:"keySet,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+// CheckpointStore persists the checkpoint of the running audit
+type CheckpointStore interface {
+	// Load returns the saved checkpoint, or nil if there is no checkpoint
+	Load(ctx context.Context) (*Checkpoint, error)
+	Save(ctx context.Context, checkpoint Checkpoint) error
+	Delete(ctx context.Context) error
+}
+
+// auditCheckpoint tracks the progress of an audit and saves it to the checkpoint store
+type auditCheckpoint struct {
+	store     CheckpointStore
+	lock      sync.Mutex
+	saveLock  sync.Mutex
+	lastSaved time.Time
+	state     Checkpoint
+}
+
+func newAuditCheckpoint(store CheckpointStore, state Checkpoint) *auditCheckpoint {
+	if state.Sources == nil {
+		state.Sources = map[int]SourceCheckpoint{}
+	}
+	return &auditCheckpoint{store: store, state: state}
+}
+
+// source returns the progress of a source, the progress of a source whose kind changed is ignored
+func (c *auditCheckpoint) source(index int, kind string) SourceCheckpoint {
+	if c == nil {
+		return SourceCheckpoint{Kind: kind}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	source, ok := c.state.Sources[index]
+	if !ok || source.Kind != kind {
+		return SourceCheckpoint{Kind: kind}
+	}
+	return source
+}
+
+// resumed returns whether the checkpoint is the progress of an interrupted audit
+func (c *auditCheckpoint) resumed() bool {
+	if c == nil {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.state.Sources) > 0
+}
+
+// update sets the progress of a source and saves the checkpoint if the last save is older than the save interval
+func (c *auditCheckpoint) update(ctx context.Context, index int, source SourceCheckpoint) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	c.state.Sources[index] = source
+	save := source.Done || time.Since(c.lastSaved) >= checkpointSaveInterval
+	c.lock.Unlock()
+
+	if save {
+		c.save(ctx)
+	}
+}
+
+// save writes the current progress to the checkpoint store
+func (c *auditCheckpoint) save(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.saveLock.Lock()
+	defer c.saveLock.Unlock()
+
+	c.lock.Lock()
+	state := Checkpoint{
+		Type:          c.state.Type,
+		StartTime:     c.state.StartTime,
+		Kinds:         c.state.Kinds,
+		ExcludedKinds: c.state.ExcludedKinds,
+		Sources:       make(map[int]SourceCheckpoint, len(c.state.Sources)),
+	}
+	for index, source := range c.state.Sources {
+		state.Sources[index] = source
+	}
+	c.lastSaved = time.Now()
+	c.lock.Unlock()
+
+	err := c.store.Save(ctx, state)
+	if err != nil {
+		logger.Errorw("failed to save audit checkpoint", "type", state.Type, "error", err)
+	}
+}
+
+// FileCheckpointStore stores the audit checkpoint in a local file, e.g. on a persistent volume
+type FileCheckpointStore struct {
+	path string
+}
+
+// NewFileCheckpointStore returns a checkpoint store of the file in path
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+// Load reads the checkpoint file
+func (s *FileCheckpointStore) Load(_ context.Context) (*Checkpoint, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read audit checkpoint file %s: %w", s.path, err)
+	}
+	var checkpoint Checkpoint
+	err = json.Unmarshal(data, &checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audit checkpoint file %s: %w", s.path, err)
+	}
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint to a temporary file that replaces the checkpoint file
+func (s *FileCheckpointStore) Save(_ context.Context, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create audit checkpoint file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write audit checkpoint file: %w", err)
+	}
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		return fmt.Errorf("failed to write audit checkpoint file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// Delete removes the checkpoint file
+func (s *FileCheckpointStore) Delete(_ context.Context) error {
+	err := os.Remove(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete audit checkpoint file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// ConfigMapCheckpointStore stores the audit checkpoint in a ConfigMap
+type ConfigMapCheckpointStore struct {
+	reader    client.Reader
+	writer    client.Writer
+	namespace string
+	name      string
+}
+
+// NewConfigMapCheckpointStore returns a checkpoint store of the ConfigMap with the given namespace and name
+func NewConfigMapCheckpointStore(reader client.Reader, writer client.Writer, namespace, name string) *ConfigMapCheckpointStore {
+	return &ConfigMapCheckpointStore{
+		reader:    reader,
+		writer:    writer,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Load reads the checkpoint of the ConfigMap
+func (s *ConfigMapCheckpointStore) Load(ctx context.Context) (*Checkpoint, error) {
+	var configMap corev1.ConfigMap
+	err := s.reader.Get(ctx, client.ObjectKey{Namespace: s.namespace, Name: s.name}, &configMap)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get audit checkpoint configmap %s/%s: %w", s.namespace, s.name, err)
+	}
+	data, ok := configMap.Data[checkpointKey]
+	if !ok {
+		return nil, nil
+	}
+	var checkpoint Checkpoint
+	err = json.Unmarshal([]byte(data), &checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audit checkpoint configmap %s/%s: %w", s.namespace, s.name, err)
+	}
+	return &checkpoint, nil
+}
+
+// Save creates or updates the ConfigMap with the checkpoint
+func (s *ConfigMapCheckpointStore) Save(ctx context.Context, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit checkpoint: %w", err)
+	}
+	configMap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.namespace,
+			Name:      s.name,
+		},
+		Data: map[string]string{checkpointKey: string(data)},
+	}
+	err = s.writer.Update(ctx, &configMap)
+	if apierrors.IsNotFound(err) {
+		err = s.writer.Create(ctx, &configMap)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write audit checkpoint configmap %s/%s: %w", s.namespace, s.name, err)
+	}
+	return nil
+}
+
+// Delete deletes the checkpoint ConfigMap
+func (s *ConfigMapCheckpointStore) Delete(ctx context.Context) error {
+	configMap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.namespace,
+			Name:      s.name,
+		},
+	}
+	err := s.writer.Delete(ctx, &configMap)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete audit checkpoint configmap %s/%s: %w", s.namespace, s.name, err)
+	}
+	return nil
+}
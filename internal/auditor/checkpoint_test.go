@@ -0,0 +1,207 @@
+package auditor
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	entitiesmock "github.com/weaveworks/policy-agent/internal/entities/mock"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	validationmock "github.com/weaveworks/policy-agent/pkg/policy-core/validation/mock"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckpointStores(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.Nil(t, corev1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	tests := []struct {
+		name  string
+		store CheckpointStore
+	}{
+		{
+			name:  "file",
+			store: NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json")),
+		},
+		{
+			name:  "configmap",
+			store: NewConfigMapCheckpointStore(cl, cl, "policy-system", "audit-checkpoint"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			ctx := context.Background()
+
+			checkpoint, err := tt.store.Load(ctx)
+			assert.Nil(err)
+			assert.Nil(checkpoint)
+
+			want := Checkpoint{
+				Type:      AuditEventTypePeriodical,
+				StartTime: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				Sources: map[int]SourceCheckpoint{
+					0: {Kind: "Deployment", Done: true},
+					1: {Kind: "Pod", KeySet: "next"},
+				},
+			}
+			assert.Nil(tt.store.Save(ctx, want))
+			want.Sources[1] = SourceCheckpoint{Kind: "Pod", KeySet: "last"}
+			assert.Nil(tt.store.Save(ctx, want))
+
+			checkpoint, err = tt.store.Load(ctx)
+			assert.Nil(err)
+			assert.Equal(&want, checkpoint)
+
+			assert.Nil(tt.store.Delete(ctx))
+			assert.Nil(tt.store.Delete(ctx))
+			checkpoint, err = tt.store.Load(ctx)
+			assert.Nil(err)
+			assert.Nil(checkpoint)
+		})
+	}
+}
+
+func TestAuditorController_doAuditResume(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	validator := validationmock.NewMockValidator(ctrl)
+	deployments := entitiesmock.NewMockEntitiesSource(ctrl)
+	pods := entitiesmock.NewMockEntitiesSource(ctrl)
+	services := entitiesmock.NewMockEntitiesSource(ctrl)
+
+	deployments.EXPECT().Kind().AnyTimes().Return("Deployment")
+	deployments.EXPECT().List(gomock.Any(), gomock.Any()).Times(0)
+	pods.EXPECT().Kind().AnyTimes().Return("Pod")
+	pods.EXPECT().List(gomock.Any(), &domain.ListOptions{Limit: entitiesSizeLimit, KeySet: "next"}).
+		Times(1).Return(&domain.EntitiesList{
+		Data: []domain.Entity{{Name: "test", Kind: "Pod", Namespace: "default"}},
+	}, nil)
+	// the expired key set restarts the source
+	services.EXPECT().Kind().AnyTimes().Return("Service")
+	services.EXPECT().List(gomock.Any(), &domain.ListOptions{Limit: entitiesSizeLimit, KeySet: "expired"}).
+		Times(1).Return(nil, apierrors.NewResourceExpired("continue token expired"))
+	services.EXPECT().List(gomock.Any(), &domain.ListOptions{Limit: entitiesSizeLimit}).
+		Times(1).Return(&domain.EntitiesList{
+		Data: []domain.Entity{{Name: "test", Kind: "Service", Namespace: "default"}},
+	}, nil)
+
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), string(AuditEventTypeInitial)).
+		Times(2).Return(&domain.PolicyValidationSummary{}, nil)
+
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	assert.Nil(store.Save(context.Background(), Checkpoint{
+		Type:      AuditEventTypePeriodical,
+		StartTime: time.Now(),
+		Sources: map[int]SourceCheckpoint{
+			0: {Kind: "Deployment", Done: true},
+			1: {Kind: "Pod", KeySet: "next"},
+			2: {Kind: "Service", KeySet: "expired"},
+		},
+	}))
+
+	a := NewAuditController(validator, auditSchedules, AuditWorkers{}, deployments, pods, services)
+	a.RegisterCheckpointStore(store)
+	a.doAudit(context.Background(), AuditEvent{Type: AuditEventTypeInitial})
+
+	checkpoint, err := store.Load(context.Background())
+	assert.Nil(err)
+	assert.Nil(checkpoint, "checkpoint should be deleted after a completed audit")
+}
+
+func TestAuditorController_doAuditInterrupted(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	validator := validationmock.NewMockValidator(ctrl)
+	deployments := entitiesmock.NewMockEntitiesSource(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deployments.EXPECT().Kind().AnyTimes().Return("Deployment")
+	deployments.EXPECT().List(gomock.Any(), &domain.ListOptions{Limit: entitiesSizeLimit}).
+		Times(1).Return(&domain.EntitiesList{
+		HasNext: true,
+		KeySet:  "next",
+		Data:    []domain.Entity{{Name: "first", Kind: "Deployment", Namespace: "default"}},
+	}, nil)
+	deployments.EXPECT().List(gomock.Any(), &domain.ListOptions{Limit: entitiesSizeLimit, KeySet: "next"}).
+		Times(1).Return(&domain.EntitiesList{
+		HasNext: true,
+		KeySet:  "last",
+		Data:    []domain.Entity{{Name: "second", Kind: "Deployment", Namespace: "default"}},
+	}, nil)
+
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), string(AuditEventTypePeriodical)).
+		Times(2).DoAndReturn(func(_ context.Context, entity domain.Entity, _ string) (*domain.PolicyValidationSummary, error) {
+		// the agent stops while validating the second page
+		if entity.Name == "second" {
+			cancel()
+		}
+		return &domain.PolicyValidationSummary{}, nil
+	})
+
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	a := NewAuditController(validator, auditSchedules, AuditWorkers{}, deployments)
+	a.RegisterCheckpointStore(store)
+	a.doAudit(ctx, AuditEvent{Type: AuditEventTypePeriodical})
+
+	checkpoint, err := store.Load(context.Background())
+	assert.Nil(err)
+	assert.NotNil(checkpoint)
+	assert.Equal(AuditEventTypePeriodical, checkpoint.Type)
+	assert.Equal(map[int]SourceCheckpoint{0: {Kind: "Deployment", KeySet: "next"}}, checkpoint.Sources)
+}
+
+func TestAuditorController_doAuditScheduledResume(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	validator := validationmock.NewMockValidator(ctrl)
+	deployments := entitiesmock.NewMockEntitiesSource(ctrl)
+	secrets := entitiesmock.NewMockEntitiesSource(ctrl)
+
+	deployments.EXPECT().Kind().AnyTimes().Return("Deployment")
+	deployments.EXPECT().List(gomock.Any(), &domain.ListOptions{Limit: entitiesSizeLimit, KeySet: "next"}).
+		Times(1).Return(&domain.EntitiesList{
+		Data: []domain.Entity{{Name: "test", Kind: "Deployment", Namespace: "default"}},
+	}, nil)
+	// the secrets have their own schedule
+	secrets.EXPECT().Kind().AnyTimes().Return("Secret")
+	secrets.EXPECT().List(gomock.Any(), gomock.Any()).Times(0)
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), string(AuditEventTypePeriodical)).
+		Times(1).Return(&domain.PolicyValidationSummary{}, nil)
+
+	scope := AuditScope{ExcludedKinds: []string{"Secret"}, Scheduled: true}
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	// the checkpoint of another schedule is not resumed
+	assert.Nil(store.Save(context.Background(), Checkpoint{
+		Type:      AuditEventTypePeriodical,
+		StartTime: time.Now(),
+		Kinds:     []string{"Secret"},
+		Sources:   map[int]SourceCheckpoint{1: {Kind: "Secret", KeySet: "next"}},
+	}))
+	a := NewAuditController(validator, auditSchedules, AuditWorkers{}, deployments, secrets)
+	a.RegisterCheckpointStore(store)
+	checkpoint := a.loadCheckpoint(context.Background(), AuditEventTypePeriodical, &scope, time.Now())
+	assert.False(checkpoint.resumed())
+
+	assert.Nil(store.Save(context.Background(), Checkpoint{
+		Type:          AuditEventTypePeriodical,
+		StartTime:     time.Now(),
+		ExcludedKinds: []string{"Secret"},
+		Sources:       map[int]SourceCheckpoint{0: {Kind: "Deployment", KeySet: "next"}},
+	}))
+	a.doAudit(context.Background(), AuditEvent{Type: AuditEventTypePeriodical, Data: scope})
+
+	saved, err := store.Load(context.Background())
+	assert.Nil(err)
+	assert.Nil(saved, "checkpoint should be deleted after a completed audit")
+}
@@ -0,0 +1,274 @@
+package auditor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pacv2 "github.com/weaveworks/policy-agent/api/v2beta3"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const maxReportErrors = 20
+
+// ReportWriter writes the report of a completed audit
+type ReportWriter interface {
+	WriteReport(ctx context.Context, spec pacv2.ComplianceReportSpec) error
+}
+
+// ResultsPruner is implemented by the sinks keeping the latest result of each entity and policy, the results of the
+// kinds audited by a complete audit that are older than the audit are pruned as they were not validated again
+type ResultsPruner interface {
+	// PruneResults prunes the results older than before of the given kinds, or of all but the excluded kinds
+	PruneResults(ctx context.Context, kinds, excludedKinds []string, before time.Time) error
+}
+
+// auditReport collects the results of an audit
+type auditReport struct {
+	lock   sync.Mutex
+	spec   pacv2.ComplianceReportSpec
+	errors int
+	// listFailed is set when a source fails to be listed, its entities are missing from the audit
+	listFailed bool
+	resources  map[string]*pacv2.ComplianceReportResource
+	// violating are the violations of each policy by kind
+	violating map[string]map[string]int
+}
+
+func newAuditReport(auditType AuditEventType, scope *AuditScope, start time.Time) *auditReport {
+	var kinds, excludedKinds []string
+	if scope != nil {
+		kinds, excludedKinds = scope.Kinds, scope.ExcludedKinds
+	}
+	return &auditReport{
+		spec: pacv2.ComplianceReportSpec{
+			Trigger:       string(auditType),
+			Kinds:         kinds,
+			ExcludedKinds: excludedKinds,
+			StartTime:     metav1.NewTime(start),
+			Violations: pacv2.ComplianceReportViolations{
+				ByPolicy:    map[string]int{},
+				BySeverity:  map[string]int{},
+				ByNamespace: map[string]int{},
+			},
+		},
+		resources: map[string]*pacv2.ComplianceReportResource{},
+		violating: map[string]map[string]int{},
+	}
+}
+
+// addResult adds the validation result of an entity to the report
+func (r *auditReport) addResult(entity domain.Entity, summary *domain.PolicyValidationSummary) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.spec.Entities.Audited++
+	if summary == nil || len(summary.Violations) == 0 {
+		r.spec.Entities.Compliant++
+		return
+	}
+	r.spec.Entities.Violating++
+
+	violations := &r.spec.Violations
+	if r.violating[entity.Kind] == nil {
+		r.violating[entity.Kind] = map[string]int{}
+	}
+	for _, violation := range summary.Violations {
+		violations.Total++
+		violations.ByPolicy[violation.Policy.ID]++
+		r.violating[entity.Kind][violation.Policy.ID]++
+		violations.BySeverity[violation.Policy.Severity]++
+		if entity.Namespace != "" {
+			violations.ByNamespace[entity.Namespace]++
+		}
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s", entity.APIVersion, entity.Kind, entity.Namespace, entity.Name)
+	resource, ok := r.resources[key]
+	if !ok {
+		resource = &pacv2.ComplianceReportResource{
+			APIVersion: entity.APIVersion,
+			Kind:       entity.Kind,
+			Name:       entity.Name,
+			Namespace:  entity.Namespace,
+		}
+		r.resources[key] = resource
+	}
+	resource.Violations += len(summary.Violations)
+}
+
+// violatingResources returns the number of resources violating each policy by kind
+func (r *auditReport) violatingResources() map[string]map[string]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	counts := make(map[string]map[string]int, len(r.violating))
+	for kind, policies := range r.violating {
+		counts[kind] = make(map[string]int, len(policies))
+		for policy, count := range policies {
+			counts[kind][policy] = count
+		}
+	}
+	return counts
+}
+
+// addError adds an audit error to the report, only the first errors are kept
+func (r *auditReport) addError(err error) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.errors++
+	if len(r.spec.Errors) < maxReportErrors {
+		r.spec.Errors = append(r.spec.Errors, err.Error())
+	}
+}
+
+// addListError adds a source list error to the report
+func (r *auditReport) addListError(err error) {
+	r.lock.Lock()
+	r.listFailed = true
+	r.lock.Unlock()
+	r.addError(err)
+}
+
+// listed returns whether all the sources were listed
+func (r *auditReport) listed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return !r.listFailed
+}
+
+// addFailure adds an entity that failed to be validated to the report
+func (r *auditReport) addFailure(err error) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	r.spec.Entities.Failed++
+	r.lock.Unlock()
+	r.addError(err)
+}
+
+// finish returns the report spec with the violating resources sorted by their violations
+func (r *auditReport) finish(end time.Time) pacv2.ComplianceReportSpec {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	spec := r.spec
+	spec.EndTime = metav1.NewTime(end)
+	if r.errors > len(spec.Errors) {
+		spec.Errors = append(spec.Errors, fmt.Sprintf("%d more errors were omitted", r.errors-len(spec.Errors)))
+	}
+
+	spec.TopViolatingResources = make([]pacv2.ComplianceReportResource, 0, len(r.resources))
+	for _, resource := range r.resources {
+		spec.TopViolatingResources = append(spec.TopViolatingResources, *resource)
+	}
+	sort.Slice(spec.TopViolatingResources, func(i, j int) bool {
+		a, b := spec.TopViolatingResources[i], spec.TopViolatingResources[j]
+		if a.Violations != b.Violations {
+			return a.Violations > b.Violations
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return spec
+}
+
+// ComplianceReportWriter writes the audit reports as ComplianceReport resources
+// and deletes the oldest reports of each trigger and audited kinds exceeding the retention limit
+type ComplianceReportWriter struct {
+	reader       client.Reader
+	writer       client.Writer
+	retention    int
+	topResources int
+}
+
+// NewComplianceReportWriter returns a compliance report writer that keeps the latest retention reports,
+// all reports are kept if retention is not set
+func NewComplianceReportWriter(reader client.Reader, writer client.Writer, retention, topResources int) *ComplianceReportWriter {
+	return &ComplianceReportWriter{
+		reader:       reader,
+		writer:       writer,
+		retention:    retention,
+		topResources: topResources,
+	}
+}
+
+// WriteReport creates a compliance report of an audit
+func (w *ComplianceReportWriter) WriteReport(ctx context.Context, spec pacv2.ComplianceReportSpec) error {
+	if len(spec.TopViolatingResources) > w.topResources {
+		spec.TopViolatingResources = spec.TopViolatingResources[:w.topResources]
+	}
+	report := pacv2.ComplianceReport{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: spec.Trigger + "-",
+			Labels: map[string]string{
+				domain.PolicyValidationTriggerLabel: spec.Trigger,
+			},
+		},
+		Spec: spec,
+	}
+	err := w.writer.Create(ctx, &report)
+	if err != nil {
+		return fmt.Errorf("failed to create compliance report: %w", err)
+	}
+	logger.Infow("created compliance report", "name", report.GetName(), "trigger", spec.Trigger)
+
+	return w.prune(ctx, spec)
+}
+
+// prune deletes the oldest reports exceeding the retention limit among the reports of the same trigger and audited
+// kinds as the written report, so that the reports of the frequent audits don't evict the reports of the other audits
+func (w *ComplianceReportWriter) prune(ctx context.Context, spec pacv2.ComplianceReportSpec) error {
+	if w.retention <= 0 {
+		return nil
+	}
+	var reports pacv2.ComplianceReportList
+	err := w.reader.List(ctx, &reports, client.MatchingLabels{domain.PolicyValidationTriggerLabel: spec.Trigger})
+	if err != nil {
+		return fmt.Errorf("failed to list compliance reports: %w", err)
+	}
+	var items []pacv2.ComplianceReport
+	for _, report := range reports.Items {
+		if report.Spec.Trigger == spec.Trigger &&
+			sameItems(report.Spec.Kinds, spec.Kinds) &&
+			sameItems(report.Spec.ExcludedKinds, spec.ExcludedKinds) {
+			items = append(items, report)
+		}
+	}
+	if len(items) <= w.retention {
+		return nil
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Spec.EndTime.Equal(&items[j].Spec.EndTime) {
+			return items[i].Spec.EndTime.Before(&items[j].Spec.EndTime)
+		}
+		return items[i].GetName() < items[j].GetName()
+	})
+	for i := range items[:len(items)-w.retention] {
+		err := w.writer.Delete(ctx, &items[i])
+		if client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete compliance report %s: %w", items[i].GetName(), err)
+		}
+		logger.Debugw("deleted compliance report", "name", items[i].GetName())
+	}
+	return nil
+}
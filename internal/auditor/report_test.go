@@ -0,0 +1,207 @@
+package auditor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	pacv2 "github.com/weaveworks/policy-agent/api/v2beta3"
+	entitiesmock "github.com/weaveworks/policy-agent/internal/entities/mock"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	validationmock "github.com/weaveworks/policy-agent/pkg/policy-core/validation/mock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type fakeReportWriter struct {
+	specs []pacv2.ComplianceReportSpec
+}
+
+func (w *fakeReportWriter) WriteReport(_ context.Context, spec pacv2.ComplianceReportSpec) error {
+	w.specs = append(w.specs, spec)
+	return nil
+}
+
+type fakeResultsPruner struct {
+	kinds [][]string
+}
+
+func (p *fakeResultsPruner) PruneResults(_ context.Context, kinds, _ []string, _ time.Time) error {
+	p.kinds = append(p.kinds, kinds)
+	return nil
+}
+
+func newViolation(policyID, severity string) domain.PolicyValidation {
+	return domain.PolicyValidation{
+		Policy: domain.Policy{ID: policyID, Severity: severity},
+		Status: domain.PolicyValidationStatusViolating,
+	}
+}
+
+func TestAuditReport(t *testing.T) {
+	assert := require.New(t)
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	report := newAuditReport(AuditEventTypePeriodical, nil, start)
+
+	deployment := domain.Entity{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "default"}
+	role := domain.Entity{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "admin"}
+	report.addResult(deployment, &domain.PolicyValidationSummary{
+		Violations: []domain.PolicyValidation{newViolation("policy-1", "high"), newViolation("policy-2", "low")},
+	})
+	report.addResult(role, &domain.PolicyValidationSummary{
+		Violations: []domain.PolicyValidation{newViolation("policy-1", "high")},
+	})
+	report.addResult(domain.Entity{Kind: "Deployment", Name: "compliant", Namespace: "default"}, &domain.PolicyValidationSummary{})
+	report.addError(errors.New("failed to list Pod entities"))
+	for i := 0; i < maxReportErrors; i++ {
+		report.addFailure(fmt.Errorf("failed to validate entity %d", i))
+	}
+
+	spec := report.finish(start.Add(time.Minute))
+	assert.Equal(string(AuditEventTypePeriodical), spec.Trigger)
+	assert.True(spec.EndTime.Time.Equal(start.Add(time.Minute)))
+	assert.Equal(pacv2.ComplianceReportEntities{Audited: 3, Compliant: 1, Violating: 2, Failed: maxReportErrors}, spec.Entities)
+	assert.Equal(3, spec.Violations.Total)
+	assert.Equal(map[string]int{"policy-1": 2, "policy-2": 1}, spec.Violations.ByPolicy)
+	assert.Equal(map[string]int{"high": 2, "low": 1}, spec.Violations.BySeverity)
+	assert.Equal(map[string]int{"default": 2}, spec.Violations.ByNamespace)
+	assert.Equal(map[string]map[string]int{
+		"Deployment":  {"policy-1": 1, "policy-2": 1},
+		"ClusterRole": {"policy-1": 1},
+	}, report.violatingResources())
+	assert.Len(spec.Errors, maxReportErrors+1)
+	assert.Equal("1 more errors were omitted", spec.Errors[maxReportErrors])
+	assert.Equal([]pacv2.ComplianceReportResource{
+		{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "default", Violations: 2},
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "admin", Violations: 1},
+	}, spec.TopViolatingResources)
+}
+
+func TestComplianceReportWriter(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	assert.Nil(pacv2.AddToScheme(scheme))
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	newReport := func(name string, auditType AuditEventType, kinds []string, end time.Time) *pacv2.ComplianceReport {
+		return &pacv2.ComplianceReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{domain.PolicyValidationTriggerLabel: string(auditType)},
+			},
+			Spec: pacv2.ComplianceReportSpec{
+				Trigger: string(auditType),
+				Kinds:   kinds,
+				EndTime: metav1.NewTime(end),
+			},
+		}
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newReport("periodic-audit-2", AuditEventTypePeriodical, nil, start.Add(2*time.Hour)),
+		newReport("periodic-audit-0", AuditEventTypePeriodical, nil, start),
+		newReport("periodic-audit-1", AuditEventTypePeriodical, nil, start.Add(time.Hour)),
+		// the reports of other triggers and schedules are retained separately
+		newReport("initial-audit-0", AuditEventTypeInitial, nil, start),
+		newReport("periodic-audit-secrets-0", AuditEventTypePeriodical, []string{"Secret"}, start),
+	).Build()
+
+	writer := NewComplianceReportWriter(cl, cl, 2, 1)
+	err := writer.WriteReport(ctx, pacv2.ComplianceReportSpec{
+		Trigger: string(AuditEventTypePeriodical),
+		EndTime: metav1.NewTime(start.Add(24 * time.Hour)),
+		TopViolatingResources: []pacv2.ComplianceReportResource{
+			{Kind: "Deployment", Name: "app", Violations: 2},
+			{Kind: "Deployment", Name: "other", Violations: 1},
+		},
+	})
+	assert.Nil(err)
+
+	var reports pacv2.ComplianceReportList
+	assert.Nil(cl.List(ctx, &reports))
+	assert.Len(reports.Items, 4, "oldest reports should be deleted")
+	var names []string
+	for _, report := range reports.Items {
+		names = append(names, report.GetName())
+		if report.Spec.EndTime.Time.Equal(start.Add(24 * time.Hour)) {
+			assert.Len(report.Spec.TopViolatingResources, 1)
+			assert.Equal(string(AuditEventTypePeriodical), report.GetLabels()[domain.PolicyValidationTriggerLabel])
+		}
+	}
+	assert.Contains(names, "periodic-audit-2")
+	assert.Contains(names, "initial-audit-0")
+	assert.Contains(names, "periodic-audit-secrets-0")
+	assert.NotContains(names, "periodic-audit-0")
+	assert.NotContains(names, "periodic-audit-1")
+}
+
+func TestAuditorController_doAuditReport(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	validator := validationmock.NewMockValidator(ctrl)
+	entitiesSource := entitiesmock.NewMockEntitiesSource(ctrl)
+	entitiesSource.EXPECT().Kind().AnyTimes().Return("Deployment")
+	entitiesSource.EXPECT().List(gomock.Any(), gomock.Any()).Times(3).Return(&domain.EntitiesList{
+		Data: []domain.Entity{
+			{Kind: "Deployment", Name: "app", Namespace: "default"},
+			{Kind: "Deployment", Name: "compliant", Namespace: "default"},
+			{Kind: "ReplicaSet", Name: "app", Namespace: "default", HasParent: true},
+		},
+	}, nil)
+	validator.EXPECT().Validate(gomock.Any(), gomock.Any(), gomock.Any()).Times(6).DoAndReturn(
+		func(_ context.Context, entity domain.Entity, _ string) (*domain.PolicyValidationSummary, error) {
+			if entity.Name == "compliant" {
+				return &domain.PolicyValidationSummary{}, nil
+			}
+			return &domain.PolicyValidationSummary{
+				Violations: []domain.PolicyValidation{newViolation("policy-1", "high")},
+			}, nil
+		})
+
+	reportWriter := &fakeReportWriter{}
+	auditor := NewAuditController(validator, auditSchedules, AuditWorkers{}, entitiesSource)
+	auditor.RegisterReportWriter(reportWriter)
+	resultsPruner := &fakeResultsPruner{}
+	auditor.RegisterResultsPruner(resultsPruner)
+	auditor.doAudit(context.Background(), AuditEvent{Type: AuditEventTypeInitial})
+
+	assert.Len(reportWriter.specs, 1)
+	spec := reportWriter.specs[0]
+	assert.Equal(string(AuditEventTypeInitial), spec.Trigger)
+	assert.Equal(pacv2.ComplianceReportEntities{Audited: 2, Compliant: 1, Violating: 1}, spec.Entities)
+	assert.Equal(1, spec.Violations.Total)
+	assert.Len(spec.TopViolatingResources, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	auditor.doAudit(ctx, AuditEvent{Type: AuditEventTypeInitial})
+	assert.Len(reportWriter.specs, 1, "reports should not be written for incomplete audits")
+
+	auditor.doAudit(context.Background(), AuditEvent{
+		Type: AuditEventTypePolicyChange,
+		Data: AuditScope{Kinds: []string{"Deployment"}},
+	})
+	assert.Len(reportWriter.specs, 1, "reports should not be written for audits scoped to changes")
+
+	auditor.doAudit(context.Background(), AuditEvent{
+		Type: AuditEventTypePeriodical,
+		Data: AuditScope{Kinds: []string{"Deployment"}, Scheduled: true},
+	})
+	assert.Len(reportWriter.specs, 2)
+	assert.Equal([]string{"Deployment"}, reportWriter.specs[1].Kinds)
+
+	assert.Equal([][]string{nil, {"Deployment"}}, resultsPruner.kinds, "results should be pruned by complete audits")
+
+	entitiesSource.EXPECT().List(gomock.Any(), gomock.Any()).Times(1).Return(nil, errors.New("list error"))
+	auditor.doAudit(context.Background(), AuditEvent{Type: AuditEventTypePeriodical})
+	assert.Len(reportWriter.specs, 3)
+	assert.Equal([]string{"failed to list Deployment entities: list error"}, reportWriter.specs[2].Errors)
+	assert.Len(resultsPruner.kinds, 2, "results should not be pruned by audits that failed to list entities")
+}
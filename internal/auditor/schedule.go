@@ -0,0 +1,68 @@
+package auditor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule returns the next activation time after the given time
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+// AuditSchedule triggers periodic audits of the entities of specific kinds, a schedule without
+// kinds audits all the kinds that don't have their own schedule
+type AuditSchedule struct {
+	Kinds    []string
+	Schedule Schedule
+}
+
+// ParseSchedule parses a go duration, e.g. "10m", or a standard cron expression, e.g. "0 2 * * *"
+func ParseSchedule(spec string) (Schedule, error) {
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval < time.Second {
+			return nil, fmt.Errorf("audit interval %s can not be less than 1 second", spec)
+		}
+		return cron.Every(interval), nil
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit schedule %s, expected a duration or a cron expression: %w", spec, err)
+	}
+	return schedule, nil
+}
+
+// scope returns the audit scope of the schedule
+func (s AuditSchedule) scope(schedules []AuditSchedule) interface{} {
+	if len(s.Kinds) != 0 {
+		return AuditScope{Kinds: s.Kinds, Scheduled: true}
+	}
+
+	var excluded []string
+	for i := range schedules {
+		excluded = append(excluded, schedules[i].Kinds...)
+	}
+	if len(excluded) == 0 {
+		return nil
+	}
+	return AuditScope{ExcludedKinds: excluded, Scheduled: true}
+}
+
+// run triggers periodic audits until the context is done
+func (a *AuditorController) run(ctx context.Context, schedule AuditSchedule) {
+	data := schedule.scope(a.schedules)
+	for {
+		timer := time.NewTimer(time.Until(schedule.Schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			a.Audit(AuditEventTypePeriodical, data)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package auditor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2023, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		spec    string
+		next    time.Time
+		wantErr bool
+	}{
+		{
+			name: "duration",
+			spec: "10m",
+			next: now.Add(10 * time.Minute),
+		},
+		{
+			name: "hours duration",
+			spec: "24h",
+			next: now.Add(24 * time.Hour),
+		},
+		{
+			name: "cron expression",
+			spec: "0 2 * * *",
+			next: time.Date(2023, 1, 2, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "cron descriptor",
+			spec: "@hourly",
+			next: time.Date(2023, 1, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "duration less than a second",
+			spec:    "500ms",
+			wantErr: true,
+		},
+		{
+			name:    "zero duration",
+			spec:    "0h",
+			wantErr: true,
+		},
+		{
+			name:    "invalid schedule",
+			spec:    "every day",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			schedule, err := ParseSchedule(tt.spec)
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.Nil(err)
+			assert.Equal(tt.next, schedule.Next(now))
+		})
+	}
+}
+
+func TestAuditSchedule_scope(t *testing.T) {
+	assert := require.New(t)
+	schedules := []AuditSchedule{
+		{},
+		{Kinds: []string{"Secret"}},
+		{Kinds: []string{"CustomResourceDefinition"}},
+	}
+
+	defaultScope, ok := schedules[0].scope(schedules).(AuditScope)
+	assert.True(ok)
+	assert.False(defaultScope.matchKind("Secret"))
+	assert.False(defaultScope.matchKind("CustomResourceDefinition"))
+	assert.True(defaultScope.matchKind("Deployment"))
+	assert.True(defaultScope.Scheduled)
+
+	secretsScope, ok := schedules[1].scope(schedules).(AuditScope)
+	assert.True(ok)
+	assert.True(secretsScope.matchKind("Secret"))
+	assert.False(secretsScope.matchKind("Deployment"))
+
+	assert.Nil(AuditSchedule{}.scope([]AuditSchedule{{}}), "default schedule should audit all entities")
+}
+
+func TestCoalesce(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	deployments := AuditScope{Kinds: []string{"Deployment"}}
+	pods := AuditScope{Kinds: []string{"Pod"}}
+
+	var pending []AuditEvent
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePolicyChange, Data: deployments})
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePolicyChange, Data: pods})
+	assert.Len(pending, 1, "scoped audits of the same type should be merged")
+	merged := pending[0].Data.(AuditScope)
+	assert.True(merged.matchEntity(ctx, domain.Entity{Kind: "Deployment"}))
+	assert.True(merged.matchEntity(ctx, domain.Entity{Kind: "Pod"}))
+	assert.False(merged.matchEntity(ctx, domain.Entity{Kind: "Secret"}))
+
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePeriodical, Data: pods})
+	assert.Len(pending, 2, "scoped audits of different types should not be merged")
+
+	secrets := AuditScope{Kinds: []string{"Secret"}, Scheduled: true}
+	others := AuditScope{ExcludedKinds: []string{"Secret"}, Scheduled: true}
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePeriodical, Data: secrets})
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePeriodical, Data: others})
+	assert.Len(pending, 4, "scheduled audits should not be merged with other audits")
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePeriodical, Data: secrets})
+	assert.Len(pending, 4, "scheduled audits of the same schedule should be merged")
+
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePeriodical})
+	assert.Equal([]AuditEvent{{Type: AuditEventTypePeriodical}}, pending, "audit of all entities should cover scoped audits")
+
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypePolicyChange, Data: deployments})
+	pending = coalesce(pending, AuditEvent{Type: AuditEventTypeInitial})
+	assert.Equal([]AuditEvent{{Type: AuditEventTypePeriodical}}, pending, "pending audit of all entities should cover new audits")
+}
@@ -0,0 +1,188 @@
+package auditor
+
+import (
+	"context"
+
+	pacv2 "github.com/weaveworks/policy-agent/api/v2beta3"
+	"github.com/weaveworks/policy-agent/internal/utils"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const tenantLabel = "toolkit.fluxcd.io/tenant"
+
+// AuditScope limits an audit to the entities matching it, it's passed as the audit event data
+type AuditScope struct {
+	// Kinds of the audited entities, all kinds are audited if empty
+	Kinds []string
+	// ExcludedKinds are the kinds of the entities that are not audited
+	ExcludedKinds []string
+	// Namespaces of the audited entities, all namespaces are audited if empty
+	Namespaces []string
+	// Match filters the audited entities when set
+	Match func(ctx context.Context, entity domain.Entity) bool
+	// Scheduled is set for the audits of a schedule, they audit all the entities of their kinds like
+	// the audits of all entities
+	Scheduled bool
+}
+
+func (s AuditScope) matchKind(kind string) bool {
+	if contains(s.ExcludedKinds, kind) {
+		return false
+	}
+	return len(s.Kinds) == 0 || contains(s.Kinds, kind)
+}
+
+func (s AuditScope) matchEntity(ctx context.Context, entity domain.Entity) bool {
+	if !s.matchKind(entity.Kind) {
+		return false
+	}
+	if len(s.Namespaces) != 0 && !contains(s.Namespaces, entity.Namespace) {
+		return false
+	}
+	return s.Match == nil || s.Match(ctx, entity)
+}
+
+// sameKinds returns whether both scopes audit the same kinds
+func sameKinds(a, b AuditScope) bool {
+	return sameItems(a.Kinds, b.Kinds) && sameItems(a.ExcludedKinds, b.ExcludedKinds)
+}
+
+// unionScope returns a scope that includes the entities of both scopes
+func unionScope(a, b AuditScope) AuditScope {
+	scope := AuditScope{
+		Match: func(ctx context.Context, entity domain.Entity) bool {
+			return a.matchEntity(ctx, entity) || b.matchEntity(ctx, entity)
+		},
+	}
+	// kinds are kept to skip listing the entities of other kinds
+	if len(a.Kinds) != 0 && len(b.Kinds) != 0 {
+		scope.Kinds = append(append(scope.Kinds, a.Kinds...), b.Kinds...)
+	}
+	return scope
+}
+
+// ChangeScope returns the scope of the entities affected by a policy or policy config change,
+// oldObj is nil on creation and newObj is nil on deletion. It returns false if no entity is affected.
+func ChangeScope(reader client.Reader, oldObj, newObj client.Object) (AuditScope, bool) {
+	// status updates don't change the generation
+	if oldObj != nil && newObj != nil && oldObj.GetGeneration() == newObj.GetGeneration() {
+		return AuditScope{}, false
+	}
+
+	var scopes []AuditScope
+	switch obj := newObj.(type) {
+	case *pacv2.Policy:
+		if obj.Spec.Provider == pacv2.PolicyKubernetesProvider {
+			scopes = append(scopes, policyScope(obj))
+		}
+	case *pacv2.PolicyConfig:
+		scopes = append(scopes, policyConfigScope(reader, obj))
+	}
+	switch obj := oldObj.(type) {
+	case *pacv2.Policy:
+		// entities that are no longer targeted by the updated or deleted policy are no longer violating it
+		if obj.Spec.Provider == pacv2.PolicyKubernetesProvider {
+			scopes = append(scopes, policyScope(obj))
+		}
+	case *pacv2.PolicyConfig:
+		// entities that are no longer matched by the policy config get the policies parameters back
+		scopes = append(scopes, policyConfigScope(reader, obj))
+	}
+
+	if len(scopes) == 0 {
+		return AuditScope{}, false
+	}
+	scope := scopes[0]
+	for _, other := range scopes[1:] {
+		scope = unionScope(scope, other)
+	}
+	return scope, true
+}
+
+func policyScope(policy *pacv2.Policy) AuditScope {
+	return AuditScope{
+		Kinds:      policy.Spec.Targets.Kinds,
+		Namespaces: policy.Spec.Targets.Namespaces,
+	}
+}
+
+func policyConfigScope(reader client.Reader, config *pacv2.PolicyConfig) AuditScope {
+	match := config.Spec.Match
+	switch {
+	case len(match.Namespaces) != 0:
+		return AuditScope{Namespaces: match.Namespaces}
+	case len(match.Resources) != 0:
+		var kinds []string
+		for _, resource := range match.Resources {
+			kinds = append(kinds, resource.Kind)
+		}
+		return AuditScope{
+			Kinds: kinds,
+			Match: func(_ context.Context, entity domain.Entity) bool {
+				for _, resource := range match.Resources {
+					if resource.Kind == entity.Kind && resource.Name == entity.Name &&
+						(resource.Namespace == "" || resource.Namespace == entity.Namespace) {
+						return true
+					}
+				}
+				return false
+			},
+		}
+	case len(match.Applications) != 0:
+		return AuditScope{
+			Match: func(_ context.Context, entity domain.Entity) bool {
+				fluxApp := utils.GetFluxObject(entity.Labels)
+				if fluxApp == nil {
+					return false
+				}
+				for _, app := range match.Applications {
+					if app.Kind == fluxApp.GetKind() && app.Name == fluxApp.GetName() &&
+						(app.Namespace == "" || app.Namespace == fluxApp.GetNamespace()) {
+						return true
+					}
+				}
+				return false
+			},
+		}
+	case len(match.Workspaces) != 0:
+		return AuditScope{
+			Match: func(ctx context.Context, entity domain.Entity) bool {
+				if entity.Namespace == "" {
+					return false
+				}
+				var ns v1.Namespace
+				if err := reader.Get(ctx, client.ObjectKey{Name: entity.Namespace}, &ns); err != nil {
+					logger.Errorw("failed to get entity namespace", "namespace", entity.Namespace, "error", err)
+					return false
+				}
+				return contains(match.Workspaces, ns.GetLabels()[tenantLabel])
+			},
+		}
+	}
+	return AuditScope{}
+}
+
+// sameItems returns whether both lists have the same items regardless of their order
+func sameItems(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !contains(b, a[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(items []string, item string) bool {
+	for i := range items {
+		if items[i] == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package auditor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	pacv2 "github.com/weaveworks/policy-agent/api/v2beta3"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPolicy(generation int64, provider string, targets pacv2.PolicyTargets) *pacv2.Policy {
+	return &pacv2.Policy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Generation: generation},
+		Spec: pacv2.PolicySpec{
+			ID:       "policy",
+			Provider: provider,
+			Targets:  targets,
+		},
+	}
+}
+
+func newPolicyConfig(generation int64, match pacv2.PolicyConfigTarget) *pacv2.PolicyConfig {
+	return &pacv2.PolicyConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "config", Generation: generation},
+		Spec:       pacv2.PolicyConfigSpec{Match: match},
+	}
+}
+
+func TestChangeScope(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.Nil(t, v1.AddToScheme(scheme))
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-ns", Labels: map[string]string{tenantLabel: "tenant"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other-ns"}},
+	).Build()
+
+	deployment := domain.Entity{Kind: "Deployment", Name: "app", Namespace: "default"}
+	tests := []struct {
+		name     string
+		oldObj   client.Object
+		newObj   client.Object
+		affected bool
+		kinds    []string
+		match    []domain.Entity
+		noMatch  []domain.Entity
+	}{
+		{
+			name:     "policy status update",
+			oldObj:   newPolicy(1, pacv2.PolicyKubernetesProvider, pacv2.PolicyTargets{}),
+			newObj:   newPolicy(1, pacv2.PolicyKubernetesProvider, pacv2.PolicyTargets{}),
+			affected: false,
+		},
+		{
+			name:     "terraform policy",
+			newObj:   newPolicy(1, pacv2.PolicyTerraformProvider, pacv2.PolicyTargets{}),
+			affected: false,
+		},
+		{
+			name:     "deleted policy",
+			oldObj:   newPolicy(1, pacv2.PolicyKubernetesProvider, pacv2.PolicyTargets{Kinds: []string{"Deployment"}}),
+			affected: true,
+			kinds:    []string{"Deployment"},
+			match:    []domain.Entity{deployment},
+			noMatch:  []domain.Entity{{Kind: "Pod", Name: "app", Namespace: "default"}},
+		},
+		{
+			name:     "deleted terraform policy",
+			oldObj:   newPolicy(1, pacv2.PolicyTerraformProvider, pacv2.PolicyTargets{}),
+			affected: false,
+		},
+		{
+			name:     "updated policy targets",
+			oldObj:   newPolicy(1, pacv2.PolicyKubernetesProvider, pacv2.PolicyTargets{Namespaces: []string{"default", "other"}}),
+			newObj:   newPolicy(2, pacv2.PolicyKubernetesProvider, pacv2.PolicyTargets{Namespaces: []string{"default"}}),
+			affected: true,
+			match: []domain.Entity{
+				deployment,
+				{Kind: "Deployment", Name: "app", Namespace: "other"},
+			},
+			noMatch: []domain.Entity{{Kind: "Deployment", Name: "app", Namespace: "kube-system"}},
+		},
+		{
+			name:     "created policy",
+			newObj:   newPolicy(1, pacv2.PolicyKubernetesProvider, pacv2.PolicyTargets{Kinds: []string{"Deployment"}, Namespaces: []string{"default"}}),
+			affected: true,
+			kinds:    []string{"Deployment"},
+			match:    []domain.Entity{deployment},
+			noMatch: []domain.Entity{
+				{Kind: "Deployment", Name: "app", Namespace: "other"},
+				{Kind: "Pod", Name: "app", Namespace: "default"},
+			},
+		},
+		{
+			name:     "updated policy config namespaces",
+			oldObj:   newPolicyConfig(1, pacv2.PolicyConfigTarget{Namespaces: []string{"default"}}),
+			newObj:   newPolicyConfig(2, pacv2.PolicyConfigTarget{Namespaces: []string{"other"}}),
+			affected: true,
+			match: []domain.Entity{
+				deployment,
+				{Kind: "Pod", Name: "app", Namespace: "other"},
+			},
+			noMatch: []domain.Entity{{Kind: "Deployment", Name: "app", Namespace: "kube-system"}},
+		},
+		{
+			name: "deleted policy config resources",
+			oldObj: newPolicyConfig(1, pacv2.PolicyConfigTarget{Resources: []pacv2.PolicyTargetResource{
+				{Kind: "Deployment", Name: "app"},
+			}}),
+			affected: true,
+			kinds:    []string{"Deployment"},
+			match:    []domain.Entity{deployment},
+			noMatch:  []domain.Entity{{Kind: "Deployment", Name: "other", Namespace: "default"}},
+		},
+		{
+			name: "created policy config apps",
+			newObj: newPolicyConfig(1, pacv2.PolicyConfigTarget{Applications: []pacv2.PolicyTargetApplication{
+				{Kind: "Kustomization", Name: "app", Namespace: "flux-system"},
+			}}),
+			affected: true,
+			match: []domain.Entity{{
+				Kind: "Deployment",
+				Name: "app",
+				Labels: map[string]string{
+					"kustomize.toolkit.fluxcd.io/name":      "app",
+					"kustomize.toolkit.fluxcd.io/namespace": "flux-system",
+				},
+			}},
+			noMatch: []domain.Entity{deployment},
+		},
+		{
+			name:     "created policy config workspaces",
+			newObj:   newPolicyConfig(1, pacv2.PolicyConfigTarget{Workspaces: []string{"tenant"}}),
+			affected: true,
+			match:    []domain.Entity{{Kind: "Deployment", Name: "app", Namespace: "tenant-ns"}},
+			noMatch: []domain.Entity{
+				{Kind: "Deployment", Name: "app", Namespace: "other-ns"},
+				{Kind: "ClusterRole", Name: "app"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			scope, affected := ChangeScope(reader, tt.oldObj, tt.newObj)
+			assert.Equal(tt.affected, affected)
+			assert.Equal(tt.kinds, scope.Kinds)
+			for _, entity := range tt.match {
+				assert.True(scope.matchEntity(context.Background(), entity), "entity %s/%s should be in scope", entity.Namespace, entity.Name)
+			}
+			for _, entity := range tt.noMatch {
+				assert.False(scope.matchEntity(context.Background(), entity), "entity %s/%s should not be in scope", entity.Namespace, entity.Name)
+			}
+		})
+	}
+}
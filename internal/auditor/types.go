@@ -7,8 +7,16 @@ type AuditEventType string
 const (
 	AuditEventTypeInitial    AuditEventType = "initial-audit"
 	AuditEventTypePeriodical AuditEventType = "periodic-audit"
-	entitiesSizeLimit                       = 50
-	TypeAudit                               = "Audit"
+	AuditEventTypeContinuous AuditEventType = "continuous-audit"
+	// AuditEventTypePolicyChange audits the entities affected by a policy or policy config change,
+	// its data is the AuditScope of the change
+	AuditEventTypePolicyChange AuditEventType = "policy-change-audit"
+	// AuditEventTypeDiscovery audits the entities of the sources added by a discovery refresh,
+	// its data is the AuditScope of the added kinds
+	AuditEventTypeDiscovery AuditEventType = "discovery-audit"
+	entitiesSizeLimit                      = 50
+	TypeAudit                              = "Audit"
+	allKinds                               = "*"
 )
 
 type AuditEvent struct {
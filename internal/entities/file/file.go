@@ -0,0 +1,208 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const helmChartFile = "Chart.yaml"
+
+var manifestsExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// FileEntitySource lists the entities of the manifests in a file or a directory. Directories are
+// read recursively, directories with a kustomization file are rendered using kustomize build
+type FileEntitySource struct {
+	path string
+	lock sync.Mutex
+	// entities are the entities loaded by the first list call of an audit
+	entities []domain.Entity
+}
+
+// NewFileEntitySource returns an entities source of the manifests in the path
+func NewFileEntitySource(path string) (*FileEntitySource, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifests path %s: %w", path, err)
+	}
+	return &FileEntitySource{path: path}, nil
+}
+
+// List returns the entities of the manifests, they are loaded again when the list starts without a key set
+func (f *FileEntitySource) List(_ context.Context, listOptions *domain.ListOptions) (*domain.EntitiesList, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	offset := 0
+	if listOptions.KeySet == "" {
+		entities, err := f.load()
+		if err != nil {
+			return nil, err
+		}
+		f.entities = entities
+	} else {
+		// the entities are not loaded when a list is resumed after a restart
+		if f.entities == nil {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("manifests of key set %s are not loaded", listOptions.KeySet))
+		}
+		var err error
+		offset, err = strconv.Atoi(listOptions.KeySet)
+		if err != nil || offset < 0 || offset > len(f.entities) {
+			return nil, fmt.Errorf("invalid key set %s", listOptions.KeySet)
+		}
+	}
+
+	end := len(f.entities)
+	if listOptions.Limit > 0 && offset+listOptions.Limit < end {
+		end = offset + listOptions.Limit
+	}
+	list := &domain.EntitiesList{
+		Data: f.entities[offset:end],
+	}
+	if end < len(f.entities) {
+		list.HasNext = true
+		list.KeySet = strconv.Itoa(end)
+	}
+	return list, nil
+}
+
+// Kind returns an empty kind, the source lists entities of all kinds
+func (f *FileEntitySource) Kind() string {
+	return ""
+}
+
+// load reads the entities of the source path
+func (f *FileEntitySource) load() ([]domain.Entity, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifests path %s: %w", f.path, err)
+	}
+	if !info.IsDir() {
+		return readFile(f.path)
+	}
+
+	// the manifests that fail to be read or built are not skipped, their resources would be missing from the audit
+	var entities []domain.Entity
+	err = filepath.WalkDir(f.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if !manifestsExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			fileEntities, err := readFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read manifests file %s: %w", path, err)
+			}
+			entities = append(entities, fileEntities...)
+			return nil
+		}
+
+		if path != f.path && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if exists(filepath.Join(path, helmChartFile)) {
+			logger.Warnw("skipping helm chart, render the chart using helm template to audit its manifests", "path", path)
+			return filepath.SkipDir
+		}
+		if isKustomization(path) {
+			kustomizeEntities, err := build(path)
+			if err != nil {
+				return fmt.Errorf("failed to build kustomization %s: %w", path, err)
+			}
+			entities = append(entities, kustomizeEntities...)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifests path %s: %w", f.path, err)
+	}
+	return entities, nil
+}
+
+// readFile reads the entities of a multi documents yaml or json file, e.g. a rendered helm chart
+func readFile(path string) ([]domain.Entity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	nodes, err := (&kio.ByteReader{Reader: file, OmitReaderAnnotations: true}).Read()
+	if err != nil {
+		return nil, err
+	}
+	return newEntities(nodes)
+}
+
+// build renders a kustomization using kustomize build
+func build(path string) ([]domain.Entity, error) {
+	kustomizer := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	resources, err := kustomizer.Run(filesys.MakeFsOnDisk(), path)
+	if err != nil {
+		return nil, err
+	}
+	return newEntities(resources.ToRNodeSlice())
+}
+
+// newEntities returns the entities of the manifests nodes, the items of lists are returned as entities
+func newEntities(nodes []*yaml.RNode) ([]domain.Entity, error) {
+	var entities []domain.Entity
+	for _, node := range nodes {
+		manifest, err := node.Map()
+		if err != nil {
+			return nil, err
+		}
+		if items, ok := manifest["items"].([]interface{}); ok && strings.HasSuffix(node.GetKind(), "List") {
+			for i := range items {
+				item, ok := items[i].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				entities = appendEntity(entities, item)
+			}
+			continue
+		}
+		entities = appendEntity(entities, manifest)
+	}
+	return entities, nil
+}
+
+func appendEntity(entities []domain.Entity, manifest map[string]interface{}) []domain.Entity {
+	entity := domain.NewEntityFromSpec(manifest)
+	// skip documents that are not kubernetes resources
+	if entity.Kind == "" || entity.APIVersion == "" || entity.Name == "" {
+		return entities
+	}
+	return append(entities, entity)
+}
+
+func isKustomization(path string) bool {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if exists(filepath.Join(path, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
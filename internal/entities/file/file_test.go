@@ -0,0 +1,216 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	deployments = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+spec:
+  replicas: 1
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  namespace: default
+`
+	service = `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "app", "namespace": "default"}}`
+
+	helmOutput = `---
+# Source: app/templates/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: release-app
+---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: release-app
+data:
+  key: value
+`
+	list = `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: secret
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: team
+`
+	kustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+namePrefix: prod-
+namespace: prod
+resources:
+- role.yaml
+`
+	role = `apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: reader
+`
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func entityRefs(entities []domain.Entity) []string {
+	var refs []string
+	for _, entity := range entities {
+		refs = append(refs, entity.Kind+":"+entity.Namespace+"/"+entity.Name)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+func TestFileEntitySource_List(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"apps/deployments.yaml":             deployments,
+		"apps/service.json":                 service,
+		"rendered/helm.yaml":                helmOutput,
+		"list.yml":                          list,
+		"README.md":                         "# manifests",
+		"overlays/prod/kustomization.yaml":  kustomization,
+		"overlays/prod/role.yaml":           role,
+		"charts/app/Chart.yaml":             "name: app\nversion: 0.1.0\n",
+		"charts/app/templates/service.yaml": "name: {{ .Release.Name }}\n",
+		".git/config.yaml":                  role,
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "directory",
+			path: root,
+			want: []string{
+				"ConfigMap:/release-app",
+				"Deployment:default/app",
+				"Deployment:default/worker",
+				"Namespace:/team",
+				"Role:prod/prod-reader",
+				"Secret:/secret",
+				"Service:default/app",
+				"ServiceAccount:/release-app",
+			},
+		},
+		{
+			name: "file",
+			path: filepath.Join(root, "rendered", "helm.yaml"),
+			want: []string{"ConfigMap:/release-app", "ServiceAccount:/release-app"},
+		},
+		{
+			name: "kustomization",
+			path: filepath.Join(root, "overlays", "prod"),
+			want: []string{"Role:prod/prod-reader"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			source, err := NewFileEntitySource(tt.path)
+			assert.Nil(err)
+			list, err := source.List(context.Background(), &domain.ListOptions{})
+			assert.Nil(err)
+			assert.False(list.HasNext)
+			assert.Equal(tt.want, entityRefs(list.Data))
+		})
+	}
+
+	_, err := NewFileEntitySource(filepath.Join(root, "missing"))
+	require.Error(t, err)
+}
+
+func TestFileEntitySource_ListKustomizationError(t *testing.T) {
+	assert := require.New(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"apps/deployments.yaml": deployments,
+		// the kustomization resource file is missing
+		"overlays/prod/kustomization.yaml": kustomization,
+	})
+
+	// the manifests are not audited without the kustomization resources
+	source, err := NewFileEntitySource(root)
+	assert.Nil(err)
+	_, err = source.List(context.Background(), &domain.ListOptions{})
+	assert.ErrorContains(err, "failed to build kustomization")
+}
+
+func TestFileEntitySource_ListInvalidManifest(t *testing.T) {
+	assert := require.New(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"apps/deployments.yaml": deployments,
+		"apps/invalid.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata: [name\n",
+	})
+
+	// the manifests are not audited without the resources of the invalid file
+	for _, path := range []string{root, filepath.Join(root, "apps", "invalid.yaml")} {
+		source, err := NewFileEntitySource(path)
+		assert.Nil(err)
+		_, err = source.List(context.Background(), &domain.ListOptions{})
+		assert.Error(err)
+	}
+}
+
+func TestFileEntitySource_ListPagination(t *testing.T) {
+	assert := require.New(t)
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"deployments.yaml": deployments,
+		"helm.yaml":        helmOutput,
+	})
+
+	source, err := NewFileEntitySource(root)
+	assert.Nil(err)
+
+	// the key set of a list resumed after a restart is expired
+	_, err = source.List(context.Background(), &domain.ListOptions{KeySet: "3"})
+	assert.True(apierrors.IsResourceExpired(err))
+
+	var entities []domain.Entity
+	opts := domain.ListOptions{Limit: 3}
+	for {
+		list, err := source.List(context.Background(), &opts)
+		assert.Nil(err)
+		assert.LessOrEqual(len(list.Data), 3)
+		entities = append(entities, list.Data...)
+		if !list.HasNext {
+			break
+		}
+		opts.KeySet = list.KeySet
+	}
+	assert.Len(entities, 4)
+
+	_, err = source.List(context.Background(), &domain.ListOptions{KeySet: "10"})
+	assert.Error(err)
+}
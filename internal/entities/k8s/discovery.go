@@ -0,0 +1,243 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/policy-agent/internal/clients/kube"
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/internal/namespace"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// refreshDelay coalesces the refresh requests of bursts of CRDs changes and
+// gives the api server time to serve the resources of new CRDs
+const refreshDelay = 5 * time.Second
+
+var crdsResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// SourcesListener is called with the entities sources added and removed by a discovery refresh
+type SourcesListener func(added, removed []domain.EntitiesSource)
+
+// SourcesDiscovery refreshes the entities sources by running the api discovery and the permissions
+// check again periodically and when CRDs change
+type SourcesDiscovery struct {
+	kubeClient      *kube.KubeClient
+	namespaceFilter *namespace.Filter
+	scope           Scope
+	interval        time.Duration
+	watchCRDs       bool
+	lock            sync.Mutex
+	sources         map[string]*K8SEntitySource
+	listeners       []SourcesListener
+	refresh         chan struct{}
+}
+
+// NewSourcesDiscovery returns a discovery of the entities sources that starts from the given sources,
+// sources are not refreshed periodically if interval is not set
+func NewSourcesDiscovery(
+	kubeClient *kube.KubeClient,
+	namespaceFilter *namespace.Filter,
+	scope Scope,
+	interval time.Duration,
+	watchCRDs bool,
+	entitiesSources []domain.EntitiesSource,
+) *SourcesDiscovery {
+	sources := make(map[string]*K8SEntitySource)
+	for i := range entitiesSources {
+		if source, ok := entitiesSources[i].(*K8SEntitySource); ok {
+			sources[source.resource.String()] = source
+		}
+	}
+	metrics.EntitiesSources.Set(float64(len(sources)))
+	return &SourcesDiscovery{
+		kubeClient:      kubeClient,
+		namespaceFilter: namespaceFilter,
+		scope:           scope,
+		interval:        interval,
+		watchCRDs:       watchCRDs,
+		sources:         sources,
+		refresh:         make(chan struct{}, 1),
+	}
+}
+
+// OnChange registers a listener of the entities sources changes
+func (d *SourcesDiscovery) OnChange(listener SourcesListener) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.listeners = append(d.listeners, listener)
+}
+
+// Refresh requests a discovery refresh, requests are coalesced
+func (d *SourcesDiscovery) Refresh() {
+	select {
+	case d.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Start refreshes the entities sources until the context is done
+func (d *SourcesDiscovery) Start(ctx context.Context) error {
+	logger.Infow("starting entities sources discovery...", "interval", d.interval.String(), "watch-crds", d.watchCRDs)
+	if d.watchCRDs {
+		d.watchCRDsChanges(ctx)
+	}
+
+	var ticks <-chan time.Time
+	if d.interval > 0 {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("stopping entities sources discovery...")
+			return nil
+		case <-ticks:
+			d.discover(ctx)
+		case <-d.refresh:
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(refreshDelay):
+			}
+			d.discover(ctx)
+		}
+	}
+}
+
+// watchCRDsChanges requests a refresh when a CRD is created, changed or deleted after the watch started
+func (d *SourcesDiscovery) watchCRDsChanges(ctx context.Context) {
+	started := time.Now().Truncate(time.Second)
+	informer := dynamicinformer.NewFilteredDynamicInformer(
+		d.kubeClient.DynamicClient,
+		crdsResource,
+		metav1.NamespaceAll,
+		0,
+		toolscache.Indexers{},
+		nil,
+	).Informer()
+	_, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			// skip the CRDs of the informer initial list
+			crd, ok := obj.(*unstructured.Unstructured)
+			if ok && crd.GetCreationTimestamp().Time.Before(started) {
+				return
+			}
+			d.Refresh()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCRD, ok := oldObj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			newCRD, ok := newObj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			if oldCRD.GetResourceVersion() != newCRD.GetResourceVersion() {
+				d.Refresh()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			d.Refresh()
+		},
+	})
+	if err != nil {
+		logger.Errorw("failed to watch CRDs changes", "error", err)
+		return
+	}
+	go informer.Run(ctx.Done())
+}
+
+// discover runs the entities sources discovery and notifies the listeners with the changed sources
+func (d *SourcesDiscovery) discover(ctx context.Context) {
+	start := time.Now()
+	entitiesSources, err := GetEntitiesSources(ctx, d.kubeClient, d.namespaceFilter, d.scope)
+	if err != nil {
+		metrics.DiscoveryRefreshes.WithLabelValues(metrics.ResultFailure).Inc()
+		logger.Errorw("failed to refresh entities sources", "error", err)
+		return
+	}
+	metrics.DiscoveryRefreshes.WithLabelValues(metrics.ResultSuccess).Inc()
+
+	d.lock.Lock()
+	added, removed := d.update(entitiesSources)
+	listeners := d.listeners
+	d.lock.Unlock()
+
+	metrics.EntitiesSources.Set(float64(len(entitiesSources)))
+	if len(added) == 0 && len(removed) == 0 {
+		logger.Debugw("entities sources are up to date", "sources", len(entitiesSources), "duration", time.Since(start).String())
+		return
+	}
+	metrics.EntitiesSourcesChanges.WithLabelValues(metrics.ChangeAdded).Add(float64(len(added)))
+	metrics.EntitiesSourcesChanges.WithLabelValues(metrics.ChangeRemoved).Add(float64(len(removed)))
+	logger.Infow(
+		"refreshed entities sources",
+		"added", sourcesResources(added),
+		"removed", sourcesResources(removed),
+		"sources", len(entitiesSources),
+		"duration", time.Since(start).String(),
+	)
+
+	for _, listener := range listeners {
+		listener(added, removed)
+	}
+}
+
+// update replaces the current sources with the discovered sources, it returns the added and removed
+// sources. Sources whose kind or resource names changed are replaced
+func (d *SourcesDiscovery) update(entitiesSources []domain.EntitiesSource) (added, removed []domain.EntitiesSource) {
+	sources := make(map[string]*K8SEntitySource)
+	for i := range entitiesSources {
+		source, ok := entitiesSources[i].(*K8SEntitySource)
+		if !ok {
+			continue
+		}
+		resource := source.resource.String()
+		current, ok := d.sources[resource]
+		if ok && current.kind == source.kind && reflect.DeepEqual(current.resourceNames, source.resourceNames) {
+			sources[resource] = current
+			continue
+		}
+		if ok {
+			removed = append(removed, current)
+		}
+		sources[resource] = source
+		added = append(added, source)
+	}
+	for resource, current := range d.sources {
+		if _, ok := sources[resource]; !ok {
+			removed = append(removed, current)
+		}
+	}
+	d.sources = sources
+	return added, removed
+}
+
+func sourcesResources(entitiesSources []domain.EntitiesSource) []string {
+	var resources []string
+	for i := range entitiesSources {
+		if source, ok := entitiesSources[i].(*K8SEntitySource); ok {
+			resources = append(resources, source.resource.String())
+		}
+	}
+	sort.Strings(resources)
+	return resources
+}
@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSourcesDiscovery_update(t *testing.T) {
+	deployments := &K8SEntitySource{
+		resource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		kind:     "Deployment",
+	}
+	services := &K8SEntitySource{
+		resource: schema.GroupVersionResource{Version: "v1", Resource: "services"},
+		kind:     "Service",
+	}
+	secrets := &K8SEntitySource{
+		resource:      schema.GroupVersionResource{Version: "v1", Resource: "secrets"},
+		kind:          "Secret",
+		resourceNames: []string{"test"},
+	}
+
+	tests := []struct {
+		name        string
+		discovered  []domain.EntitiesSource
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name: "unchanged",
+			discovered: []domain.EntitiesSource{
+				&K8SEntitySource{resource: deployments.resource, kind: "Deployment"},
+				&K8SEntitySource{resource: services.resource, kind: "Service"},
+				&K8SEntitySource{resource: secrets.resource, kind: "Secret", resourceNames: []string{"test"}},
+			},
+		},
+		{
+			name: "added and removed",
+			discovered: []domain.EntitiesSource{
+				&K8SEntitySource{resource: deployments.resource, kind: "Deployment"},
+				&K8SEntitySource{resource: secrets.resource, kind: "Secret", resourceNames: []string{"test"}},
+				&K8SEntitySource{
+					resource: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"},
+					kind:     "Widget",
+				},
+			},
+			wantAdded:   []string{"example.com/v1, Resource=widgets"},
+			wantRemoved: []string{"/v1, Resource=services"},
+		},
+		{
+			name: "changed resource names",
+			discovered: []domain.EntitiesSource{
+				&K8SEntitySource{resource: deployments.resource, kind: "Deployment"},
+				&K8SEntitySource{resource: services.resource, kind: "Service"},
+				&K8SEntitySource{resource: secrets.resource, kind: "Secret"},
+			},
+			wantAdded:   []string{"/v1, Resource=secrets"},
+			wantRemoved: []string{"/v1, Resource=secrets"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			discovery := NewSourcesDiscovery(nil, nil, Scope{}, 0, false, []domain.EntitiesSource{deployments, services, secrets})
+
+			added, removed := discovery.update(tt.discovered)
+			assert.Equal(tt.wantAdded, sourcesResources(added))
+			assert.Equal(tt.wantRemoved, sourcesResources(removed))
+			assert.Len(discovery.sources, len(tt.discovered))
+			// unchanged sources are kept to not restart their audits and watches
+			assert.Same(deployments, discovery.sources[deployments.resource.String()])
+		})
+	}
+}
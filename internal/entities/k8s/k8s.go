@@ -7,6 +7,7 @@ import (
 
 	pacv2 "github.com/weaveworks/policy-agent/api/v2beta3"
 	"github.com/weaveworks/policy-agent/internal/clients/kube"
+	"github.com/weaveworks/policy-agent/internal/namespace"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	corev1 "k8s.io/api/core/v1"
@@ -89,8 +90,13 @@ func getValidateRules(ctx context.Context, kubeClient *kube.KubeClient) ([]rules
 	return rulesCaches, nil
 }
 
-// GetEntitiesSources returns entities sources based on allowed list permissions
-func GetEntitiesSources(ctx context.Context, kubeClient *kube.KubeClient) ([]domain.EntitiesSource, error) {
+// GetEntitiesSources returns entities sources based on allowed list permissions limited to the scope resources
+func GetEntitiesSources(ctx context.Context, kubeClient *kube.KubeClient, namespaceFilter *namespace.Filter, scope Scope) ([]domain.EntitiesSource, error) {
+	err := scope.validate()
+	if err != nil {
+		return nil, err
+	}
+
 	rulesCaches, err := getValidateRules(ctx, kubeClient)
 	if err != nil {
 		return nil, err
@@ -126,6 +132,10 @@ func GetEntitiesSources(ctx context.Context, kubeClient *kube.KubeClient) ([]dom
 			if !foundList {
 				continue
 			}
+			if !scope.allowed(groupVersion.Group, apiResource.Kind) {
+				logger.Debugw("skipping resource out of audit scope", "group", groupVersion.Group, "kind", apiResource.Kind)
+				continue
+			}
 
 			for j := range rulesCaches {
 				cache := rulesCaches[j]
@@ -146,6 +156,8 @@ func GetEntitiesSources(ctx context.Context, kubeClient *kube.KubeClient) ([]dom
 						kind:             apiResource.Kind,
 						resourceNames:    cache.resourceNames,
 						ignoredNamespace: ignoredNamespace,
+						namespaceFilter:  namespaceFilter,
+						labelSelector:    scope.LabelSelector,
 					})
 					break
 				}
@@ -162,13 +174,16 @@ type K8SEntitySource struct {
 	kind             string
 	resourceNames    []string
 	ignoredNamespace string
+	namespaceFilter  *namespace.Filter
+	labelSelector    string
 }
 
 // List returns list of resources from the entities source
 func (k *K8SEntitySource) List(ctx context.Context, listOptions *domain.ListOptions) (*domain.EntitiesList, error) {
 	metaListOptions := meta.ListOptions{
-		Limit:    int64(listOptions.Limit),
-		Continue: listOptions.KeySet,
+		Limit:         int64(listOptions.Limit),
+		Continue:      listOptions.KeySet,
+		LabelSelector: k.labelSelector,
 	}
 	var entitiesList *unstructured.UnstructuredList
 	var err error
@@ -176,7 +191,7 @@ func (k *K8SEntitySource) List(ctx context.Context, listOptions *domain.ListOpti
 		var items []unstructured.Unstructured
 		for i := range k.resourceNames {
 			selector := fieldSelectors.OneTermEqualSelector(entityMetadataName, k.resourceNames[i])
-			opts := meta.ListOptions{FieldSelector: selector.String()}
+			opts := meta.ListOptions{FieldSelector: selector.String(), LabelSelector: k.labelSelector}
 			entitiesList, err = k.kubeClient.ListResourceItems(ctx, k.resource, corev1.NamespaceAll, opts)
 			if err != nil {
 				return nil, fmt.Errorf("error while getting resource with name %s: %w", k.resourceNames[i], err)
@@ -195,10 +210,15 @@ func (k *K8SEntitySource) List(ctx context.Context, listOptions *domain.ListOpti
 	var data []domain.Entity
 
 	for i := range entitiesList.Items {
-		if entitiesList.Items[i].GetNamespace() != k.ignoredNamespace {
-			entity := domain.NewEntityFromSpec(entitiesList.Items[i].Object)
-			data = append(data, entity)
+		excluded, err := k.excluded(ctx, entitiesList.Items[i].GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+		if excluded {
+			continue
 		}
+		entity := domain.NewEntityFromSpec(entitiesList.Items[i].Object)
+		data = append(data, entity)
 	}
 	return &domain.EntitiesList{
 		HasNext: keySet != "",
@@ -207,6 +227,14 @@ func (k *K8SEntitySource) List(ctx context.Context, listOptions *domain.ListOpti
 	}, nil
 }
 
+// excluded checks if the entities of a namespace are skipped
+func (k *K8SEntitySource) excluded(ctx context.Context, entityNamespace string) (bool, error) {
+	if entityNamespace == k.ignoredNamespace {
+		return true, nil
+	}
+	return k.namespaceFilter.Excluded(ctx, entityNamespace)
+}
+
 // Kind indicates the k8s kind of the source
 func (k *K8SEntitySource) Kind() string {
 	return k.kind
@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/policy-agent/internal/clients/kube"
+	"github.com/weaveworks/policy-agent/internal/namespace"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	authv1 "k8s.io/api/authorization/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -45,6 +46,7 @@ func TestGetEntitiesSources(t *testing.T) {
 		permissions     Permissions
 		dynamicClient   dynamic.Interface
 		discoveryClient discovery.DiscoveryInterface
+		scope           Scope
 	}
 	tests := []struct {
 		name    string
@@ -687,6 +689,107 @@ func TestGetEntitiesSources(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "allowed groups and denied kinds",
+			args: args{
+				permissions: Permissions{
+					review: &authv1.SelfSubjectRulesReview{
+						Status: authv1.SubjectRulesReviewStatus{
+							ResourceRules: []authv1.ResourceRule{
+								{
+									Verbs:     []string{"list"},
+									Resources: []string{"*"},
+									APIGroups: []string{"*"},
+								},
+							},
+						},
+					},
+				},
+				discoveryClient: &DiscoveryMock{
+					ApiList: []*meta.APIResourceList{
+						{
+							GroupVersion: "apps/v1",
+							APIResources: []meta.APIResource{
+								{Name: "deployments", Kind: "Deployment", Verbs: []string{"list"}},
+								{Name: "replicasets", Kind: "ReplicaSet", Verbs: []string{"list"}},
+							},
+						},
+						{
+							GroupVersion: "v1",
+							APIResources: []meta.APIResource{
+								{Name: "pods", Kind: "Pod", Verbs: []string{"list"}},
+								{Name: "events", Kind: "Event", Verbs: []string{"list"}},
+							},
+						},
+					},
+				},
+				scope: Scope{
+					AllowedGroups: []string{""},
+					DeniedKinds:   []string{"Event"},
+					LabelSelector: "app=test",
+				},
+			},
+			want: []domain.EntitiesSource{
+				&K8SEntitySource{
+					resource:      schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+					kind:          "Pod",
+					labelSelector: "app=test",
+				},
+			},
+		},
+		{
+			name: "allowed kinds and denied groups",
+			args: args{
+				permissions: Permissions{
+					review: &authv1.SelfSubjectRulesReview{
+						Status: authv1.SubjectRulesReviewStatus{
+							ResourceRules: []authv1.ResourceRule{
+								{
+									Verbs:     []string{"list"},
+									Resources: []string{"*"},
+									APIGroups: []string{"*"},
+								},
+							},
+						},
+					},
+				},
+				discoveryClient: &DiscoveryMock{
+					ApiList: []*meta.APIResourceList{
+						{
+							GroupVersion: "apps/v1",
+							APIResources: []meta.APIResource{
+								{Name: "deployments", Kind: "Deployment", Verbs: []string{"list"}},
+								{Name: "replicasets", Kind: "ReplicaSet", Verbs: []string{"list"}},
+							},
+						},
+						{
+							GroupVersion: "v1",
+							APIResources: []meta.APIResource{
+								{Name: "pods", Kind: "Pod", Verbs: []string{"list"}},
+								{Name: "events", Kind: "Event", Verbs: []string{"list"}},
+							},
+						},
+					},
+				},
+				scope: Scope{
+					AllowedKinds: []string{"Deployment", "ReplicaSet", "Pod"},
+					DeniedGroups: []string{"apps"},
+				},
+			},
+			want: []domain.EntitiesSource{
+				&K8SEntitySource{
+					resource: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+					kind:     "Pod",
+				},
+			},
+		},
+		{
+			name: "invalid label selector",
+			args: args{
+				scope: Scope{LabelSelector: "app in test"},
+			},
+			wantErr: true,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -703,7 +806,7 @@ func TestGetEntitiesSources(t *testing.T) {
 				ClientSet:       cli,
 				DynamicClient:   test.args.dynamicClient,
 				DiscoveryClient: test.args.discoveryClient}
-			gotSources, err := GetEntitiesSources(ctx, kubeClient)
+			gotSources, err := GetEntitiesSources(ctx, kubeClient, nil, test.args.scope)
 			assert.Equal(test.wantErr, err != nil, "unexpected error result")
 			assert.Equal(len(test.want), len(gotSources), "unexpected entities sources number")
 
@@ -715,6 +818,7 @@ func TestGetEntitiesSources(t *testing.T) {
 				assert.Equal(wantSource.resource, gotSource.resource, "unexpected entity source resource")
 				assert.Equal(wantSource.kind, gotSource.kind, "unexpected entity source kind")
 				assert.Equal(wantSource.resourceNames, gotSource.resourceNames, "unexpected entity source resource names")
+				assert.Equal(wantSource.labelSelector, gotSource.labelSelector, "unexpected entity source label selector")
 			}
 		})
 	}
@@ -731,6 +835,7 @@ func TestK8SEntitySource_List(t *testing.T) {
 		kind             string
 		resourceNames    []string
 		ignoredNamespace string
+		excludeNamespace []string
 	}
 	type args struct {
 		listOptions *domain.ListOptions
@@ -884,6 +989,56 @@ func TestK8SEntitySource_List(t *testing.T) {
 				hasNext: false,
 			},
 		},
+		{
+			name: "excluded namespace",
+			fields: fields{
+				resource:         schema.GroupVersionResource{Resource: "deployments", Version: "v1", Group: "apps"},
+				kind:             "Deployment",
+				excludeNamespace: []string{"kube-system"},
+			},
+			args: args{
+				listOptions: &domain.ListOptions{},
+			},
+			reaction: Reaction{
+				objects: []unstructured.Unstructured{
+					{
+						Object: map[string]interface{}{
+							"apiVersion": "v1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"namespace": "kube-system",
+								"name":      "excluded",
+							},
+						},
+					},
+					{
+						Object: map[string]interface{}{
+							"apiVersion": "v1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"namespace": "default",
+								"name":      "test",
+							},
+						},
+					},
+				},
+			},
+			want: want{
+				data: []unstructured.Unstructured{
+					{
+						Object: map[string]interface{}{
+							"apiVersion": "v1",
+							"kind":       "Deployment",
+							"metadata": map[string]interface{}{
+								"namespace": "default",
+								"name":      "test",
+							},
+						},
+					},
+				},
+				hasNext: false,
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -904,12 +1059,15 @@ func TestK8SEntitySource_List(t *testing.T) {
 				return true, &unstructured.UnstructuredList{
 					Items: test.reaction.objects}, nil
 			})
+			namespaceFilter, err := namespace.NewFilter(nil, test.fields.excludeNamespace, nil)
+			assert.Nil(err)
 			k := &K8SEntitySource{
 				resource:         test.fields.resource,
 				kubeClient:       kubeClient,
 				kind:             test.fields.kind,
 				resourceNames:    test.fields.resourceNames,
 				ignoredNamespace: test.fields.ignoredNamespace,
+				namespaceFilter:  namespaceFilter,
 			}
 			ctx := context.Background()
 			got, err := k.List(ctx, test.args.listOptions)
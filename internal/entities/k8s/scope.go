@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Scope limits the resources listed by the entities sources
+type Scope struct {
+	// AllowedGroups and AllowedKinds limit the sources to the resources of these api groups and kinds,
+	// all the resources are allowed if empty. The core api group is ""
+	AllowedGroups []string
+	AllowedKinds  []string
+	// DeniedGroups and DeniedKinds exclude the resources of these api groups and kinds
+	DeniedGroups []string
+	DeniedKinds  []string
+	// LabelSelector filters the listed entities by their labels
+	LabelSelector string
+}
+
+// validate checks the scope label selector
+func (s Scope) validate() error {
+	if _, err := labels.Parse(s.LabelSelector); err != nil {
+		return fmt.Errorf("invalid label selector %s: %w", s.LabelSelector, err)
+	}
+	return nil
+}
+
+// allowed checks if the resources of the api group and kind are in scope
+func (s Scope) allowed(group, kind string) bool {
+	if contains(s.DeniedGroups, group) || contains(s.DeniedKinds, kind) {
+		return false
+	}
+	if len(s.AllowedGroups) != 0 && !contains(s.AllowedGroups, group) {
+		return false
+	}
+	return len(s.AllowedKinds) == 0 || contains(s.AllowedKinds, kind)
+}
+
+func contains(items []string, item string) bool {
+	for i := range items {
+		if items[i] == item {
+			return true
+		}
+	}
+	return false
+}
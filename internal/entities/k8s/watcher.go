@@ -0,0 +1,268 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	watcherWorkers = 5
+	// resyncDelay coalesces the resync requests of bursts of policies changes
+	resyncDelay = 5 * time.Second
+)
+
+// EntityHandler is called with the entities changed since they were last handled
+type EntityHandler func(ctx context.Context, entity domain.Entity)
+
+type watchedSource struct {
+	source   *K8SEntitySource
+	informer toolscache.SharedIndexInformer
+	stop     context.CancelFunc
+}
+
+type watcherItem struct {
+	resource string
+	key      string
+}
+
+// EntitiesWatcher watches the resources of the entities sources using dynamic informers and
+// calls the handler with the entities whose resource version changed
+type EntitiesWatcher struct {
+	client  dynamic.Interface
+	sources []*K8SEntitySource
+	handler EntityHandler
+	lock    sync.RWMutex
+	ctx     context.Context
+	watched map[string]*watchedSource
+	queue   workqueue.RateLimitingInterface
+	resync  chan struct{}
+}
+
+// NewEntitiesWatcher returns a watcher of the kubernetes entities sources, sources restricted
+// to specific resource names are not watched
+func NewEntitiesWatcher(client dynamic.Interface, handler EntityHandler, entitiesSources ...domain.EntitiesSource) *EntitiesWatcher {
+	return &EntitiesWatcher{
+		client:  client,
+		sources: watchableSources(entitiesSources),
+		handler: handler,
+		watched: make(map[string]*watchedSource),
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "entities"),
+		resync:  make(chan struct{}, 1),
+	}
+}
+
+func watchableSources(entitiesSources []domain.EntitiesSource) []*K8SEntitySource {
+	var sources []*K8SEntitySource
+	for i := range entitiesSources {
+		source, ok := entitiesSources[i].(*K8SEntitySource)
+		if !ok {
+			continue
+		}
+		if len(source.resourceNames) != 0 {
+			logger.Infow("skipping watching resource restricted to specific names", "resource", source.resource.String())
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// Start starts the informers and handles the changed entities until the context is done
+func (w *EntitiesWatcher) Start(ctx context.Context) error {
+	defer w.queue.ShutDown()
+
+	w.lock.Lock()
+	logger.Infow("starting entities watcher...", "resources", len(w.sources))
+	w.ctx = ctx
+	var synced []toolscache.InformerSynced
+	for _, source := range w.sources {
+		synced = append(synced, w.watch(source).informer.HasSynced)
+	}
+	w.lock.Unlock()
+
+	if !toolscache.WaitForCacheSync(ctx.Done(), synced...) {
+		logger.Error("failed to sync resources informers")
+	}
+
+	for i := 0; i < watcherWorkers; i++ {
+		go w.work(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("stopping entities watcher...")
+			return nil
+		case <-w.resync:
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(resyncDelay):
+			}
+			w.enqueueAll()
+		}
+	}
+}
+
+// UpdateSources starts watching the added sources and stops watching the removed sources,
+// the entities of the added sources are handled when their informers list them
+func (w *EntitiesWatcher) UpdateSources(added, removed []domain.EntitiesSource) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, source := range watchableSources(removed) {
+		resource := source.resource.String()
+		if watched, ok := w.watched[resource]; ok {
+			watched.stop()
+			delete(w.watched, resource)
+			logger.Infow("stopped watching resource", "resource", resource)
+		}
+		for i := range w.sources {
+			if w.sources[i].resource == source.resource {
+				w.sources = append(w.sources[:i], w.sources[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for _, source := range watchableSources(added) {
+		w.sources = append(w.sources, source)
+		// sources added before the watcher starts are watched on start
+		if w.ctx != nil {
+			w.watch(source)
+			logger.Infow("started watching resource", "resource", source.resource.String())
+		}
+	}
+}
+
+// watch starts the informer of a source, it must be called with the lock held
+func (w *EntitiesWatcher) watch(source *K8SEntitySource) *watchedSource {
+	resource := source.resource.String()
+	labelSelector := source.labelSelector
+	informer := dynamicinformer.NewFilteredDynamicInformer(
+		w.client,
+		source.resource,
+		metav1.NamespaceAll,
+		0,
+		toolscache.Indexers{},
+		func(opts *metav1.ListOptions) {
+			opts.LabelSelector = labelSelector
+		},
+	).Informer()
+	_, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.enqueue(resource, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldEntity, ok := oldObj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			newEntity, ok := newObj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			// skip informer resyncs
+			if oldEntity.GetResourceVersion() != newEntity.GetResourceVersion() {
+				w.enqueue(resource, newObj)
+			}
+		},
+	})
+	if err != nil {
+		logger.Errorw("failed to add resource informer handler", "resource", resource, "error", err)
+	}
+
+	ctx, stop := context.WithCancel(w.ctx)
+	watched := &watchedSource{source: source, informer: informer, stop: stop}
+	w.watched[resource] = watched
+	go informer.Run(ctx.Done())
+	return watched
+}
+
+// Resync requests handling all the watched entities again, requests are coalesced
+func (w *EntitiesWatcher) Resync() {
+	select {
+	case w.resync <- struct{}{}:
+	default:
+	}
+}
+
+func (w *EntitiesWatcher) enqueueAll() {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	count := 0
+	for resource, watched := range w.watched {
+		for _, key := range watched.informer.GetStore().ListKeys() {
+			w.queue.Add(watcherItem{resource: resource, key: key})
+			count++
+		}
+	}
+	logger.Infow("resyncing watched entities", "count", count)
+}
+
+func (w *EntitiesWatcher) enqueue(resource string, obj interface{}) {
+	key, err := toolscache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		logger.Errorw("failed to get watched entity key", "error", err)
+		return
+	}
+	w.queue.Add(watcherItem{resource: resource, key: key})
+}
+
+func (w *EntitiesWatcher) work(ctx context.Context) {
+	for {
+		item, shutdown := w.queue.Get()
+		if shutdown {
+			return
+		}
+		w.handle(ctx, item.(watcherItem))
+		w.queue.Done(item)
+	}
+}
+
+func (w *EntitiesWatcher) handle(ctx context.Context, item watcherItem) {
+	defer w.queue.Forget(item)
+
+	w.lock.RLock()
+	watched, ok := w.watched[item.resource]
+	w.lock.RUnlock()
+	// the source was removed
+	if !ok {
+		return
+	}
+
+	obj, exists, err := watched.informer.GetStore().GetByKey(item.key)
+	if err != nil {
+		logger.Errorw("failed to get watched entity", "key", item.key, "error", err)
+		return
+	}
+	if !exists {
+		return
+	}
+	entity, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	excluded, err := watched.source.excluded(ctx, entity.GetNamespace())
+	if err != nil {
+		logger.Errorw("failed to check if watched entity namespace is excluded", "key", item.key, "error", err)
+		return
+	}
+	if excluded {
+		return
+	}
+
+	w.handler(ctx, domain.NewEntityFromSpec(entity.DeepCopy().Object))
+}
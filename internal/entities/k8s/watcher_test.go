@@ -0,0 +1,145 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/internal/namespace"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newDeployment(namespace, name, resourceVersion string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"namespace":       namespace,
+				"name":            name,
+				"resourceVersion": resourceVersion,
+			},
+		},
+	}
+}
+
+func receiveEntity(t *testing.T, entities chan domain.Entity) domain.Entity {
+	select {
+	case entity := <-entities:
+		return entity
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for watched entity")
+	}
+	return domain.Entity{}
+}
+
+func TestEntitiesWatcher(t *testing.T) {
+	assert := require.New(t)
+
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	dynamicCli := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{deployments: "DeploymentList"},
+		newDeployment("default", "test", "1"),
+		newDeployment("excluded", "test", "1"),
+	)
+
+	namespaceFilter, err := namespace.NewFilter(nil, []string{"excluded"}, nil)
+	assert.Nil(err)
+	sources := []domain.EntitiesSource{
+		&K8SEntitySource{
+			resource:        deployments,
+			kind:            "Deployment",
+			namespaceFilter: namespaceFilter,
+		},
+		&K8SEntitySource{
+			resource:      schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"},
+			kind:          "ReplicaSet",
+			resourceNames: []string{"test"},
+		},
+	}
+
+	entities := make(chan domain.Entity, 10)
+	watcher := NewEntitiesWatcher(dynamicCli, func(ctx context.Context, entity domain.Entity) {
+		entities <- entity
+	}, sources...)
+	assert.Len(watcher.sources, 1, "sources restricted to resource names should not be watched")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Start(ctx)
+
+	entity := receiveEntity(t, entities)
+	assert.Equal("default", entity.Namespace)
+	assert.Equal("1", entity.ResourceVersion)
+
+	_, err = dynamicCli.Resource(deployments).Namespace("default").
+		Update(ctx, newDeployment("default", "test", "2"), meta.UpdateOptions{})
+	assert.Nil(err)
+
+	entity = receiveEntity(t, entities)
+	assert.Equal("default", entity.Namespace)
+	assert.Equal("2", entity.ResourceVersion)
+
+	watcher.enqueueAll()
+	entity = receiveEntity(t, entities)
+	assert.Equal("2", entity.ResourceVersion)
+
+	select {
+	case entity := <-entities:
+		t.Fatalf("unexpected entity %s/%s", entity.Namespace, entity.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEntitiesWatcher_UpdateSources(t *testing.T) {
+	assert := require.New(t)
+
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	dynamicCli := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{deployments: "DeploymentList"},
+		newDeployment("default", "test", "1"),
+	)
+
+	entities := make(chan domain.Entity, 10)
+	watcher := NewEntitiesWatcher(dynamicCli, func(ctx context.Context, entity domain.Entity) {
+		entities <- entity
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Start(ctx)
+
+	source := &K8SEntitySource{resource: deployments, kind: "Deployment"}
+	assert.Eventually(func() bool {
+		watcher.lock.RLock()
+		defer watcher.lock.RUnlock()
+		return watcher.ctx != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	watcher.UpdateSources([]domain.EntitiesSource{source}, nil)
+
+	entity := receiveEntity(t, entities)
+	assert.Equal("test", entity.Name)
+	assert.Equal("1", entity.ResourceVersion)
+
+	watcher.UpdateSources(nil, []domain.EntitiesSource{source})
+	assert.Empty(watcher.sources)
+	assert.Empty(watcher.watched)
+
+	_, err := dynamicCli.Resource(deployments).Namespace("default").
+		Update(ctx, newDeployment("default", "test", "2"), meta.UpdateOptions{})
+	assert.Nil(err)
+
+	select {
+	case entity := <-entities:
+		t.Fatalf("unexpected entity of removed source %s/%s", entity.Namespace, entity.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
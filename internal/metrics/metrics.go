@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "policy_agent"
+
+	CacheResultHit  = "hit"
+	CacheResultMiss = "miss"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+
+	StatusError = "error"
+
+	DropReasonBufferFull      = "buffer_full"
+	DropReasonDeadLetterFull  = "dead_letter_full"
+	DropReasonDeadLetterError = "dead_letter_error"
+	DropReasonShutdown        = "shutdown"
+	DropReasonWriteError      = "write_error"
+)
+
+var (
+	// PolicyValidations counts the policies evaluations by validation type, status, policy, severity and namespace
+	PolicyValidations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "validation",
+			Name:      "results_total",
+			Help:      "Number of policies evaluations by validation type, status (Violation, Compliance or error), policy, severity and namespace.",
+		},
+		[]string{"type", "status", "policy", "severity", "namespace"},
+	)
+	// PolicyEvaluationDuration observes the time spent evaluating a policy against an entity
+	PolicyEvaluationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "validation",
+			Name:      "policy_evaluation_duration_seconds",
+			Help:      "Time spent evaluating a policy against an entity.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		},
+		[]string{"policy"},
+	)
+	// ViolatingResources reports the number of resources violating a policy found by the last complete audit
+	ViolatingResources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "violating_resources",
+			Help:      "Number of resources violating a policy found by the last complete audit.",
+		},
+		[]string{"policy"},
+	)
+
+	// AdmissionDecisions counts the admission requests by decision
+	AdmissionDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "admission",
+			Name:      "decisions_total",
+			Help:      "Number of admission requests by decision (allowed, denied, skipped or error).",
+		},
+		[]string{"decision"},
+	)
+
+	// SinkWriteFailures counts the failed writes of the validation results sinks
+	SinkWriteFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "write_failures_total",
+			Help:      "Number of failed writes of validation results by sink.",
+		},
+		[]string{"sink"},
+	)
+	// SinkQueueDepth reports the number of results waiting in the buffer of a sink
+	SinkQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "queue_depth",
+			Help:      "Number of validation results waiting in the buffer of a sink.",
+		},
+		[]string{"mode", "sink"},
+	)
+	// SinkDroppedResults counts the results dropped by the buffer of a sink by reason
+	SinkDroppedResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "dropped_results_total",
+			Help:      "Number of validation results dropped by the buffer of a sink by reason (buffer_full, write_error, shutdown, dead_letter_full or dead_letter_error).",
+		},
+		[]string{"mode", "sink", "reason"},
+	)
+	// SinkSpilledResults counts the results spilled to the dead letter file of a sink
+	SinkSpilledResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "spilled_results_total",
+			Help:      "Number of validation results spilled to the dead letter file of a sink.",
+		},
+		[]string{"mode", "sink"},
+	)
+	// SinkReplayedResults counts the results replayed from the dead letter file of a sink
+	SinkReplayedResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "replayed_results_total",
+			Help:      "Number of validation results replayed from the dead letter file of a sink.",
+		},
+		[]string{"mode", "sink"},
+	)
+	// SinkDeadLetterResults reports the number of results in the dead letter file of a sink
+	SinkDeadLetterResults = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "dead_letter_results",
+			Help:      "Number of validation results in the dead letter file of a sink.",
+		},
+		[]string{"mode", "sink"},
+	)
+	// SinkSuppressedResults counts the repeated results that were not written to a sink of a mode
+	SinkSuppressedResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sink",
+			Name:      "suppressed_results_total",
+			Help:      "Number of repeated validation results not written to a sink of a mode.",
+		},
+		[]string{"mode", "sink"},
+	)
+
+	// AdmissionCacheRequests counts admission cache lookups by result
+	AdmissionCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "admission_cache",
+			Name:      "requests_total",
+			Help:      "Number of admission cache lookups by result (hit or miss).",
+		},
+		[]string{"result"},
+	)
+	// AdmissionCacheSize reports the number of entries in the admission cache
+	AdmissionCacheSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "admission_cache",
+			Name:      "entries",
+			Help:      "Number of entries in the admission cache.",
+		},
+	)
+
+	// AuditRunning reports whether an audit is running
+	AuditRunning = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "running",
+			Help:      "Whether an audit is running.",
+		},
+	)
+	// AuditEntitiesListed counts the entities listed by the audit by kind
+	AuditEntitiesListed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "entities_listed_total",
+			Help:      "Number of entities listed by the audit by kind.",
+		},
+		[]string{"kind"},
+	)
+	// AuditEntitiesValidated counts the entities validated by the audit by kind
+	AuditEntitiesValidated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "entities_validated_total",
+			Help:      "Number of entities validated by the audit by kind.",
+		},
+		[]string{"kind"},
+	)
+	// AuditEntitiesFailed counts the entities the audit failed to validate by kind
+	AuditEntitiesFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "entities_failed_total",
+			Help:      "Number of entities the audit failed to validate by kind.",
+		},
+		[]string{"kind"},
+	)
+	// AuditListFailures counts the failed list calls of the audit by kind
+	AuditListFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "list_failures_total",
+			Help:      "Number of failed list calls of the audit by kind.",
+		},
+		[]string{"kind"},
+	)
+	// AuditKindDuration observes the time spent auditing the entities of a kind
+	AuditKindDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "kind_duration_seconds",
+			Help:      "Time spent auditing the entities of a kind.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+		},
+		[]string{"kind"},
+	)
+
+	// DiscoveryRefreshes counts the entities sources discovery refreshes by result
+	DiscoveryRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "discovery",
+			Name:      "refreshes_total",
+			Help:      "Number of entities sources discovery refreshes by result (success or failure).",
+		},
+		[]string{"result"},
+	)
+	// EntitiesSources reports the number of discovered kubernetes entities sources
+	EntitiesSources = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "discovery",
+			Name:      "entities_sources",
+			Help:      "Number of discovered kubernetes entities sources.",
+		},
+	)
+	// EntitiesSourcesChanges counts the entities sources added and removed by the discovery refreshes
+	EntitiesSourcesChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "discovery",
+			Name:      "entities_sources_changes_total",
+			Help:      "Number of entities sources added and removed by the discovery refreshes.",
+		},
+		[]string{"change"},
+	)
+)
+
+func init() {
+	// registers the agent metrics to the controller manager metrics endpoint
+	metrics.Registry.MustRegister(
+		PolicyValidations,
+		PolicyEvaluationDuration,
+		ViolatingResources,
+		AdmissionDecisions,
+		SinkWriteFailures,
+		SinkQueueDepth,
+		SinkDroppedResults,
+		SinkSpilledResults,
+		SinkReplayedResults,
+		SinkDeadLetterResults,
+		SinkSuppressedResults,
+		AdmissionCacheRequests,
+		AdmissionCacheSize,
+		AuditRunning,
+		AuditEntitiesListed,
+		AuditEntitiesValidated,
+		AuditEntitiesFailed,
+		AuditListFailures,
+		AuditKindDuration,
+		DiscoveryRefreshes,
+		EntitiesSources,
+		EntitiesSourcesChanges,
+	)
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// EvaluationObserver records the policies evaluations metrics, implements
+// github.com/weaveworks/policy-agent/pkg/policy-core/validation.EvaluationObserver
+type EvaluationObserver struct{}
+
+// ObserveEvaluation counts the evaluation by its status and observes its duration
+func (EvaluationObserver) ObserveEvaluation(
+	validationType string,
+	policy domain.Policy,
+	entity domain.Entity,
+	result *domain.PolicyValidation,
+	err error,
+	duration time.Duration,
+) {
+	status := StatusError
+	if err == nil && result != nil {
+		status = result.Status
+	}
+	PolicyValidations.WithLabelValues(validationType, status, policy.ID, policy.Severity, entity.Namespace).Inc()
+	PolicyEvaluationDuration.WithLabelValues(policy.ID).Observe(duration.Seconds())
+}
+
+// SetViolatingResources replaces the violating resources of the policies by the given counts
+func SetViolatingResources(counts map[string]int) {
+	ViolatingResources.Reset()
+	for policy, count := range counts {
+		ViolatingResources.WithLabelValues(policy).Set(float64(count))
+	}
+}
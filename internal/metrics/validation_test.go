@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+func TestEvaluationObserver(t *testing.T) {
+	assert := require.New(t)
+	policy := domain.Policy{ID: "policy", Severity: "high"}
+	entity := domain.Entity{Kind: "Deployment", Name: "app", Namespace: "default"}
+	observer := EvaluationObserver{}
+
+	observer.ObserveEvaluation("Audit", policy, entity, &domain.PolicyValidation{Status: domain.PolicyValidationStatusViolating}, nil, time.Millisecond)
+	observer.ObserveEvaluation("Audit", policy, entity, &domain.PolicyValidation{Status: domain.PolicyValidationStatusViolating}, nil, time.Millisecond)
+	observer.ObserveEvaluation("Audit", policy, entity, nil, errors.New("failed to evaluate policy"), time.Millisecond)
+
+	assert.Equal(2.0, testutil.ToFloat64(PolicyValidations.WithLabelValues("Audit", domain.PolicyValidationStatusViolating, "policy", "high", "default")))
+	assert.Equal(1.0, testutil.ToFloat64(PolicyValidations.WithLabelValues("Audit", StatusError, "policy", "high", "default")))
+	assert.Equal(1, testutil.CollectAndCount(PolicyEvaluationDuration))
+}
+
+func TestSetViolatingResources(t *testing.T) {
+	assert := require.New(t)
+	SetViolatingResources(map[string]int{"policy-1": 2, "policy-2": 1})
+	assert.Equal(2.0, testutil.ToFloat64(ViolatingResources.WithLabelValues("policy-1")))
+	assert.Equal(2, testutil.CollectAndCount(ViolatingResources))
+
+	// policies without violations in the last audit are removed
+	SetViolatingResources(map[string]int{"policy-2": 3})
+	assert.Equal(1, testutil.CollectAndCount(ViolatingResources))
+	assert.Equal(3.0, testutil.ToFloat64(ViolatingResources.WithLabelValues("policy-2")))
+}
@@ -6,21 +6,24 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/weaveworks/policy-agent/internal/namespace"
+	"github.com/weaveworks/policy-agent/internal/tracing"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/validation"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	ctrlAdmission "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 type MutationHandler struct {
-	validator validation.Validator
+	validator       validation.Validator
+	namespaceFilter *namespace.Filter
 }
 
-func NewMutationHandler(validator validation.Validator) *MutationHandler {
+func NewMutationHandler(validator validation.Validator, namespaceFilter *namespace.Filter) *MutationHandler {
 	return &MutationHandler{
-		validator: validator,
+		validator:       validator,
+		namespaceFilter: namespaceFilter,
 	}
 }
 
@@ -32,12 +35,16 @@ func (m *MutationHandler) handleErrors(err error, errMsg string) ctrlAdmission.R
 }
 
 func (m *MutationHandler) Handle(ctx context.Context, req ctrlAdmission.Request) ctrlAdmission.Response {
-	if req.Namespace == metav1.NamespacePublic || req.Namespace == metav1.NamespaceSystem {
-		return ctrlAdmission.ValidationResponse(true, "exclude default system namespaces")
+	excluded, err := m.namespaceFilter.Excluded(ctx, req.Namespace)
+	if err != nil {
+		return m.handleErrors(err, fmt.Sprintf("failed to check if namespace %s is excluded", req.Namespace))
+	}
+	if excluded {
+		return ctrlAdmission.ValidationResponse(true, "resource namespace is excluded")
 	}
 
 	var entitySpec map[string]interface{}
-	err := json.Unmarshal(req.Object.Raw, &entitySpec)
+	err = json.Unmarshal(req.Object.Raw, &entitySpec)
 	if err != nil {
 		return m.handleErrors(err, fmt.Sprintf("failed to unmarshal entity %s/%s spec into a map", req.Namespace, req.Name))
 	}
@@ -62,7 +69,7 @@ func (m *MutationHandler) Handle(ctx context.Context, req ctrlAdmission.Request)
 
 // Run starts the mutation webhook server
 func (m *MutationHandler) Run(mgr ctrl.Manager) error {
-	webhook := ctrlAdmission.Webhook{Handler: m}
+	webhook := ctrlAdmission.Webhook{Handler: m, WithContextFunc: tracing.ContextFromRequest}
 	mgr.GetWebhookServer().Register("/mutation", &webhook)
 	return nil
 }
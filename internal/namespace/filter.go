@@ -0,0 +1,67 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Filter decides whether resources of a namespace are processed by the agent
+// based on a list of excluded namespaces and a namespace label selector
+type Filter struct {
+	reader   client.Reader
+	excluded map[string]struct{}
+	selector labels.Selector
+}
+
+// NewFilter returns a namespace filter, reader is used to get namespaces labels when a selector is specified
+func NewFilter(reader client.Reader, excludeNamespaces []string, selector *metav1.LabelSelector) (*Filter, error) {
+	filter := Filter{
+		reader:   reader,
+		excluded: make(map[string]struct{}),
+	}
+	for _, namespace := range excludeNamespaces {
+		filter.excluded[namespace] = struct{}{}
+	}
+
+	if selector != nil && (len(selector.MatchLabels) > 0 || len(selector.MatchExpressions) > 0) {
+		labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+		filter.selector = labelSelector
+	}
+
+	return &filter, nil
+}
+
+// Excluded checks if resources of the namespace should be skipped, cluster scoped resources are never excluded
+func (f *Filter) Excluded(ctx context.Context, namespace string) (bool, error) {
+	if f == nil || namespace == "" {
+		return false, nil
+	}
+
+	if _, ok := f.excluded[namespace]; ok {
+		return true, nil
+	}
+
+	if f.selector == nil {
+		return false, nil
+	}
+
+	var ns v1.Namespace
+	err := f.reader.Get(ctx, client.ObjectKey{Name: namespace}, &ns)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return !f.selector.Matches(labels.Set{}), nil
+		}
+		return false, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+
+	return !f.selector.Matches(labels.Set(ns.GetLabels())), nil
+}
@@ -0,0 +1,104 @@
+package namespace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestFilter_Excluded(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.Nil(t, v1.AddToScheme(scheme))
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"policy": "enabled"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	).Build()
+
+	tests := []struct {
+		name      string
+		exclude   []string
+		selector  *metav1.LabelSelector
+		namespace string
+		want      bool
+		wantErr   bool
+	}{
+		{
+			name:      "cluster scoped resource",
+			exclude:   []string{"kube-system"},
+			namespace: "",
+			want:      false,
+		},
+		{
+			name:      "excluded namespace",
+			exclude:   []string{"kube-system"},
+			namespace: "kube-system",
+			want:      true,
+		},
+		{
+			name:      "not excluded namespace",
+			exclude:   []string{"kube-system"},
+			namespace: "team-a",
+			want:      false,
+		},
+		{
+			name:      "namespace matches selector",
+			selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"policy": "enabled"}},
+			namespace: "team-a",
+			want:      false,
+		},
+		{
+			name:      "namespace doesn't match selector",
+			selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"policy": "enabled"}},
+			namespace: "team-b",
+			want:      true,
+		},
+		{
+			name: "namespace matches selector expression",
+			selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "policy", Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
+			},
+			namespace: "team-b",
+			want:      false,
+		},
+		{
+			name:      "missing namespace doesn't match selector",
+			selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"policy": "enabled"}},
+			namespace: "team-c",
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			filter, err := NewFilter(reader, tt.exclude, tt.selector)
+			assert.Nil(err)
+			got, err := filter.Excluded(context.Background(), tt.namespace)
+			assert.Equal(tt.wantErr, err != nil, "unexpected error result")
+			assert.Equal(tt.want, got, "unexpected excluded result")
+		})
+	}
+}
+
+func TestNewFilter_InvalidSelector(t *testing.T) {
+	_, err := NewFilter(nil, nil, &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "policy", Operator: "invalid"},
+		},
+	})
+	require.NotNil(t, err)
+}
+
+func TestFilter_Nil(t *testing.T) {
+	var filter *Filter
+	excluded, err := filter.Excluded(context.Background(), "kube-system")
+	require.Nil(t, err)
+	require.False(t, excluded)
+}
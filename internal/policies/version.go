@@ -0,0 +1,122 @@
+package crd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pacv2 "github.com/weaveworks/policy-agent/api/v2beta3"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrlCache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ChangeListener is called with the previous and the current state of a changed policy or
+// policy config, oldObj is nil on creation and newObj is nil on deletion
+type ChangeListener func(oldObj, newObj client.Object)
+
+// VersionTracker tracks changes of policies and policy configs, the versions change whenever
+// any of them is created, updated or deleted
+type VersionTracker struct {
+	policiesVersion      atomic.Uint64
+	policyConfigsVersion atomic.Uint64
+	started              time.Time
+	lock                 sync.RWMutex
+	listeners            []ChangeListener
+}
+
+// NewVersionTracker returns a tracker that watches policies and policy configs using the cache informers
+func NewVersionTracker(ctx context.Context, cache ctrlCache.Cache) (*VersionTracker, error) {
+	tracker := &VersionTracker{started: time.Now()}
+
+	err := tracker.watch(ctx, cache, &pacv2.Policy{}, &tracker.policiesVersion)
+	if err != nil {
+		return nil, err
+	}
+	err = tracker.watch(ctx, cache, &pacv2.PolicyConfig{}, &tracker.policyConfigsVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return tracker, nil
+}
+
+func (t *VersionTracker) watch(ctx context.Context, cache ctrlCache.Cache, obj client.Object, version *atomic.Uint64) error {
+	informer, err := cache.GetInformer(ctx, obj)
+	if err != nil {
+		return fmt.Errorf("failed to get %T informer: %w", obj, err)
+	}
+
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			version.Add(1)
+			newClientObj, ok := obj.(client.Object)
+			if !ok {
+				return
+			}
+			// objects created before the tracker started are listed by the informer on start
+			if newClientObj.GetCreationTimestamp().Time.Before(t.started.Truncate(time.Second)) {
+				return
+			}
+			t.notify(nil, newClientObj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldClientObj, ok := oldObj.(client.Object)
+			if !ok {
+				return
+			}
+			newClientObj, ok := newObj.(client.Object)
+			if !ok {
+				return
+			}
+			// skip informer resyncs
+			if oldClientObj.GetResourceVersion() != newClientObj.GetResourceVersion() {
+				version.Add(1)
+				t.notify(oldClientObj, newClientObj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			version.Add(1)
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			oldClientObj, ok := obj.(client.Object)
+			if !ok {
+				return
+			}
+			t.notify(oldClientObj, nil)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %T event handler: %w", obj, err)
+	}
+	return nil
+}
+
+func (t *VersionTracker) notify(oldObj, newObj client.Object) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	for _, listener := range t.listeners {
+		listener(oldObj, newObj)
+	}
+}
+
+// OnChange registers a listener that is called whenever a policy or a policy config changes,
+// policies and policy configs existing when the tracker started are not reported
+func (t *VersionTracker) OnChange(listener ChangeListener) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.listeners = append(t.listeners, listener)
+}
+
+// PoliciesVersion returns the current version of policies
+func (t *VersionTracker) PoliciesVersion() uint64 {
+	return t.policiesVersion.Load()
+}
+
+// PolicyConfigsVersion returns the current version of policy configs
+func (t *VersionTracker) PolicyConfigsVersion() uint64 {
+	return t.policyConfigsVersion.Load()
+}
@@ -0,0 +1,13 @@
+// Package all registers the factories of all the sink types
+package all
+
+import (
+	_ "github.com/weaveworks/policy-agent/internal/sink/cloud-events"
+	_ "github.com/weaveworks/policy-agent/internal/sink/database"
+	_ "github.com/weaveworks/policy-agent/internal/sink/elastic"
+	_ "github.com/weaveworks/policy-agent/internal/sink/filesystem"
+	_ "github.com/weaveworks/policy-agent/internal/sink/flux-notification"
+	_ "github.com/weaveworks/policy-agent/internal/sink/k8s-event"
+	_ "github.com/weaveworks/policy-agent/internal/sink/policy-report"
+	_ "github.com/weaveworks/policy-agent/internal/sink/webhook"
+)
@@ -0,0 +1,319 @@
+package buffered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+const (
+	// OverflowBlock blocks the writes until the buffer has room or the write context is done
+	OverflowBlock = "block"
+	// OverflowDropOldest evicts the oldest buffered result to buffer the new result
+	OverflowDropOldest = "drop-oldest"
+	// OverflowDropNewest rejects the new results while the buffer is full
+	OverflowDropNewest = "drop-newest"
+
+	DefaultSize           = 1000
+	DefaultReplayInterval = time.Minute
+
+	writeBatchSize = 50
+)
+
+// DeadLetterConfig configures the file the undeliverable results are spilled to
+type DeadLetterConfig struct {
+	// Directory of the dead letter files, the overflowed results are dropped if not set
+	Directory string
+	// MaxSize is the maximum size of the dead letter file in bytes, the size is not limited if not set
+	MaxSize int64
+	// ReplayInterval is the interval of replaying the spilled results to the sink
+	ReplayInterval time.Duration
+}
+
+// Config configures the buffer of a sink
+type Config struct {
+	Size       int
+	Overflow   string
+	DeadLetter DeadLetterConfig
+}
+
+// Deliverer is implemented by the sinks delivering the results synchronously, the buffered sink delivers the batches
+// through it so that the results the sink failed to deliver are spilled to the dead letter file and replayed, the
+// batches are written to the other sinks which handle their delivery failures themselves
+type Deliverer interface {
+	// Deliver delivers the results and returns the undelivered results with the delivery error
+	Deliver(ctx context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error)
+}
+
+// Closer is implemented by the sinks holding resources, like a database or a file, the buffered sink closes the
+// sink when its worker stops so that the resources are not released while the results are being delivered
+type Closer interface {
+	Close() error
+}
+
+// BufferedSink buffers the results written to a sink so that the writes don't block on a slow sink,
+// the results overflowing the buffer or failed to be written are spilled to a dead letter file and replayed later
+type BufferedSink struct {
+	mode           string
+	name           string
+	sink           domain.PolicyValidationSink
+	overflow       string
+	queue          chan domain.PolicyValidation
+	deadLetter     *deadLetter
+	replayInterval time.Duration
+	cancelWorker   context.CancelFunc
+}
+
+// NewBufferedSink returns a sink buffering the results of a mode written to the named sink
+func NewBufferedSink(mode, name string, sink domain.PolicyValidationSink, config Config) (*BufferedSink, error) {
+	size := config.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	overflow := config.Overflow
+	if overflow == "" {
+		overflow = OverflowBlock
+	}
+	if overflow != OverflowBlock && overflow != OverflowDropOldest && overflow != OverflowDropNewest {
+		return nil, fmt.Errorf(
+			"invalid buffer overflow policy %s, should be one of: %s, %s, %s",
+			overflow, OverflowBlock, OverflowDropOldest, OverflowDropNewest,
+		)
+	}
+	replayInterval := config.DeadLetter.ReplayInterval
+	if replayInterval <= 0 {
+		replayInterval = DefaultReplayInterval
+	}
+
+	s := &BufferedSink{
+		mode:           mode,
+		name:           name,
+		sink:           sink,
+		overflow:       overflow,
+		queue:          make(chan domain.PolicyValidation, size),
+		replayInterval: replayInterval,
+	}
+	if config.DeadLetter.Directory != "" {
+		path := filepath.Join(config.DeadLetter.Directory, fmt.Sprintf("%s-%s.jsonl", mode, name))
+		deadLetter, err := newDeadLetter(path, config.DeadLetter.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		s.deadLetter = deadLetter
+		metrics.SinkDeadLetterResults.WithLabelValues(mode, name).Set(float64(deadLetter.len()))
+	}
+	return s, nil
+}
+
+// Start starts the worker writing the buffered results to the sink
+func (s *BufferedSink) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancelWorker = cancel
+	return s.writeWorker(ctx)
+}
+
+// Stop stops worker
+func (s *BufferedSink) Stop() {
+	// the worker is not started when the agent fails to start
+	if s.cancelWorker == nil {
+		return
+	}
+	s.cancelWorker()
+}
+
+// Write adds results to the buffer, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (s *BufferedSink) Write(ctx context.Context, results []domain.PolicyValidation) error {
+	var overflowed []domain.PolicyValidation
+	for _, result := range results {
+		switch s.overflow {
+		case OverflowBlock:
+			// the result is queued when the buffer has room even if the write context, like the admission
+			// latency budget, is already done, the write only blocks while the context is not done
+			select {
+			case s.queue <- result:
+				continue
+			default:
+			}
+			select {
+			case s.queue <- result:
+			case <-ctx.Done():
+				overflowed = append(overflowed, result)
+			}
+		case OverflowDropNewest:
+			select {
+			case s.queue <- result:
+			default:
+				overflowed = append(overflowed, result)
+			}
+		case OverflowDropOldest:
+			for queued := false; !queued; {
+				select {
+				case s.queue <- result:
+					queued = true
+				default:
+					select {
+					case oldest := <-s.queue:
+						overflowed = append(overflowed, oldest)
+					default:
+					}
+				}
+			}
+		}
+	}
+	s.updateQueueDepth()
+	if len(overflowed) > 0 {
+		logger.Debugw("sink buffer is full", "mode", s.mode, "sink", s.name, "overflow", s.overflow, "count", len(overflowed))
+		s.spill(overflowed, metrics.DropReasonBufferFull)
+	}
+	return nil
+}
+
+func (s *BufferedSink) writeWorker(ctx context.Context) error {
+	s.replay()
+	ticker := time.NewTicker(s.replayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-s.queue:
+			s.write(ctx, s.batch(result))
+		case <-ticker.C:
+			s.replay()
+		case <-ctx.Done():
+			logger.Info("stopping write worker ...")
+			s.spillPending()
+			s.closeSink()
+			return nil
+		}
+	}
+}
+
+// spillPending spills the results still buffered when the worker stops, they are replayed after the restart
+func (s *BufferedSink) spillPending() {
+	var pending []domain.PolicyValidation
+	for len(s.queue) > 0 {
+		pending = append(pending, <-s.queue)
+	}
+	s.updateQueueDepth()
+	if len(pending) > 0 {
+		s.spill(pending, metrics.DropReasonShutdown)
+	}
+}
+
+// closeSink closes the sink once no more results are delivered to it
+func (s *BufferedSink) closeSink() {
+	closer, ok := s.sink.(Closer)
+	if !ok {
+		return
+	}
+	err := closer.Close()
+	if err != nil {
+		logger.Errorw("failed to close sink", "mode", s.mode, "sink", s.name, "error", err)
+	}
+}
+
+// batch returns the result with the next buffered results
+func (s *BufferedSink) batch(result domain.PolicyValidation) []domain.PolicyValidation {
+	batch := []domain.PolicyValidation{result}
+	for len(batch) < writeBatchSize {
+		select {
+		case result := <-s.queue:
+			batch = append(batch, result)
+		default:
+			s.updateQueueDepth()
+			return batch
+		}
+	}
+	s.updateQueueDepth()
+	return batch
+}
+
+func (s *BufferedSink) write(ctx context.Context, results []domain.PolicyValidation) {
+	undelivered, err := s.deliver(ctx, results)
+	if err != nil {
+		logger.Errorw("failed to write validation results to sink", "mode", s.mode, "sink", s.name, "count", len(undelivered), "error", err)
+		metrics.SinkWriteFailures.WithLabelValues(s.name).Inc()
+		if len(undelivered) > 0 {
+			s.spill(undelivered, metrics.DropReasonWriteError)
+		}
+	}
+}
+
+// deliver delivers the results to the sink and returns the undelivered results
+func (s *BufferedSink) deliver(ctx context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	if deliverer, ok := s.sink.(Deliverer); ok {
+		return deliverer.Deliver(ctx, results)
+	}
+	err := s.sink.Write(ctx, results)
+	if err != nil {
+		return results, err
+	}
+	return nil, nil
+}
+
+// spill writes the undeliverable results to the dead letter file, the results are dropped with the
+// given reason when there is no dead letter file
+func (s *BufferedSink) spill(results []domain.PolicyValidation, reason string) {
+	if s.deadLetter == nil {
+		s.drop(len(results), reason)
+		return
+	}
+	written, err := s.deadLetter.write(results)
+	metrics.SinkSpilledResults.WithLabelValues(s.mode, s.name).Add(float64(written))
+	metrics.SinkDeadLetterResults.WithLabelValues(s.mode, s.name).Set(float64(s.deadLetter.len()))
+	if err == nil {
+		return
+	}
+	if errors.Is(err, errDeadLetterFull) {
+		s.drop(len(results)-written, metrics.DropReasonDeadLetterFull)
+		return
+	}
+	logger.Errorw("failed to spill validation results", "mode", s.mode, "sink", s.name, "error", err)
+	s.drop(len(results)-written, metrics.DropReasonDeadLetterError)
+}
+
+func (s *BufferedSink) drop(count int, reason string) {
+	logger.Warnw("dropped validation results", "mode", s.mode, "sink", s.name, "reason", reason, "count", count)
+	metrics.SinkDroppedResults.WithLabelValues(s.mode, s.name, reason).Add(float64(count))
+}
+
+// replay moves the spilled results to the free space of the buffer
+func (s *BufferedSink) replay() {
+	if s.deadLetter == nil {
+		return
+	}
+	results, err := s.deadLetter.take(cap(s.queue) - len(s.queue))
+	if err != nil {
+		logger.Errorw("failed to replay validation results", "mode", s.mode, "sink", s.name, "error", err)
+		return
+	}
+	if len(results) == 0 {
+		return
+	}
+
+	var overflowed []domain.PolicyValidation
+	for _, result := range results {
+		select {
+		case s.queue <- result:
+		default:
+			overflowed = append(overflowed, result)
+		}
+	}
+	replayed := len(results) - len(overflowed)
+	logger.Infow("replaying spilled validation results", "mode", s.mode, "sink", s.name, "count", replayed)
+	metrics.SinkReplayedResults.WithLabelValues(s.mode, s.name).Add(float64(replayed))
+	metrics.SinkDeadLetterResults.WithLabelValues(s.mode, s.name).Set(float64(s.deadLetter.len()))
+	s.updateQueueDepth()
+	if len(overflowed) > 0 {
+		s.spill(overflowed, metrics.DropReasonBufferFull)
+	}
+}
+
+func (s *BufferedSink) updateQueueDepth() {
+	metrics.SinkQueueDepth.WithLabelValues(s.mode, s.name).Set(float64(len(s.queue)))
+}
@@ -0,0 +1,361 @@
+package buffered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// fakeSink records the written results, the writes block while the sink is paused
+type fakeSink struct {
+	lock    sync.Mutex
+	results []string
+	err     error
+	paused  chan struct{}
+}
+
+func (f *fakeSink) Write(_ context.Context, results []domain.PolicyValidation) error {
+	if f.paused != nil {
+		<-f.paused
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	for _, result := range results {
+		f.results = append(f.results, result.ID)
+	}
+	return nil
+}
+
+func (f *fakeSink) written() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.results...)
+}
+
+// fakeDeliverer delivers the results synchronously, the results with the failing ids are not delivered
+type fakeDeliverer struct {
+	fakeSink
+	failing map[string]bool
+	closed  bool
+}
+
+func (f *fakeDeliverer) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeDeliverer) Write(_ context.Context, _ []domain.PolicyValidation) error {
+	return errors.New("results are written through deliver")
+}
+
+func (f *fakeDeliverer) Deliver(_ context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var undelivered []domain.PolicyValidation
+	for _, result := range results {
+		if f.failing[result.ID] {
+			undelivered = append(undelivered, result)
+			continue
+		}
+		f.results = append(f.results, result.ID)
+	}
+	if len(undelivered) > 0 {
+		return undelivered, errors.New("failed to deliver results")
+	}
+	return nil, nil
+}
+
+func newResults(ids ...int) []domain.PolicyValidation {
+	var results []domain.PolicyValidation
+	for _, id := range ids {
+		results = append(results, domain.PolicyValidation{ID: fmt.Sprint(id)})
+	}
+	return results
+}
+
+func queued(s *BufferedSink) []string {
+	var ids []string
+	for len(s.queue) > 0 {
+		ids = append(ids, (<-s.queue).ID)
+	}
+	return ids
+}
+
+func TestBufferedSink_Write(t *testing.T) {
+	tests := []struct {
+		name        string
+		overflow    string
+		deadLetter  bool
+		expired     bool
+		wantQueued  []string
+		wantSpilled []string
+		wantDropped float64
+	}{
+		{
+			name:        "drop oldest",
+			overflow:    OverflowDropOldest,
+			wantQueued:  []string{"3", "4", "5"},
+			wantDropped: 2,
+		},
+		{
+			name:        "drop newest",
+			overflow:    OverflowDropNewest,
+			wantQueued:  []string{"1", "2", "3"},
+			wantDropped: 2,
+		},
+		{
+			name:        "block until the context is done",
+			overflow:    OverflowBlock,
+			wantQueued:  []string{"1", "2", "3"},
+			wantDropped: 2,
+		},
+		{
+			name:        "queue while the buffer has room after the context is done",
+			overflow:    OverflowBlock,
+			expired:     true,
+			wantQueued:  []string{"1", "2", "3"},
+			wantDropped: 2,
+		},
+		{
+			name:        "spill overflowed results",
+			overflow:    OverflowDropOldest,
+			deadLetter:  true,
+			wantQueued:  []string{"3", "4", "5"},
+			wantSpilled: []string{"1", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			config := Config{Size: 3, Overflow: tt.overflow}
+			if tt.deadLetter {
+				config.DeadLetter.Directory = t.TempDir()
+			}
+			name := fmt.Sprintf("sink-%s-%t", tt.overflow, tt.expired)
+			s, err := NewBufferedSink("audit", name, &fakeSink{}, config)
+			assert.Nil(err)
+			dropped := testutil.ToFloat64(metrics.SinkDroppedResults.WithLabelValues("audit", name, metrics.DropReasonBufferFull))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if tt.expired {
+				cancel()
+			}
+			assert.Nil(s.Write(ctx, newResults(1, 2, 3, 4, 5)))
+
+			assert.Equal(3.0, testutil.ToFloat64(metrics.SinkQueueDepth.WithLabelValues("audit", name)))
+			assert.Equal(tt.wantQueued, queued(s))
+			assert.Equal(tt.wantDropped, testutil.ToFloat64(metrics.SinkDroppedResults.WithLabelValues("audit", name, metrics.DropReasonBufferFull))-dropped)
+			if tt.deadLetter {
+				spilled, err := s.deadLetter.take(10)
+				assert.Nil(err)
+				var ids []string
+				for _, result := range spilled {
+					ids = append(ids, result.ID)
+				}
+				assert.Equal(tt.wantSpilled, ids)
+			}
+		})
+	}
+}
+
+func TestBufferedSink_nonBlocking(t *testing.T) {
+	assert := require.New(t)
+	sink := &fakeSink{paused: make(chan struct{})}
+	s, err := NewBufferedSink("admission", "slow", sink, Config{Size: 10, Overflow: OverflowDropNewest})
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+
+	// writes return while the sink is blocked
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.Write(ctx, newResults(i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked on a slow sink")
+	}
+
+	close(sink.paused)
+	assert.Eventually(func() bool { return len(sink.written()) > 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestBufferedSink_dropReasons(t *testing.T) {
+	assert := require.New(t)
+	dropped := func(name, reason string) float64 {
+		return testutil.ToFloat64(metrics.SinkDroppedResults.WithLabelValues("audit", name, reason))
+	}
+
+	// the results the sink failed to write are dropped without a dead letter file
+	sink := &fakeSink{err: errors.New("sink is unavailable")}
+	s, err := NewBufferedSink("audit", "failing", sink, Config{Size: 5})
+	assert.Nil(err)
+	failed := dropped("failing", metrics.DropReasonWriteError)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+	assert.Nil(s.Write(ctx, newResults(1, 2)))
+	assert.Eventually(func() bool {
+		return dropped("failing", metrics.DropReasonWriteError)-failed == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the results still buffered when the sink stops are dropped without a dead letter file
+	s, err = NewBufferedSink("audit", "stopped", &fakeSink{}, Config{Size: 5})
+	assert.Nil(err)
+	stopped, full := dropped("stopped", metrics.DropReasonShutdown), dropped("stopped", metrics.DropReasonBufferFull)
+	assert.Nil(s.Write(context.Background(), newResults(1, 2, 3)))
+	s.spillPending()
+	assert.Equal(3.0, dropped("stopped", metrics.DropReasonShutdown)-stopped)
+	assert.Equal(0.0, dropped("stopped", metrics.DropReasonBufferFull)-full)
+}
+
+func TestBufferedSink_deliver(t *testing.T) {
+	assert := require.New(t)
+	config := Config{
+		Size: 10,
+		DeadLetter: DeadLetterConfig{
+			Directory:      t.TempDir(),
+			ReplayInterval: time.Hour,
+		},
+	}
+	sink := &fakeDeliverer{failing: map[string]bool{"2": true, "4": true}}
+	s, err := NewBufferedSink("audit", "deliverer", sink, config)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(done)
+	}()
+	assert.Nil(s.Write(ctx, newResults(1, 2, 3, 4, 5)))
+
+	// only the undelivered results are spilled
+	assert.Eventually(func() bool { return len(sink.written()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal([]string{"1", "3", "5"}, sink.written())
+	assert.Eventually(func() bool { return s.deadLetter.len() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// the sink is closed when the worker stops
+	cancel()
+	<-done
+	assert.True(sink.closed)
+}
+
+func TestBufferedSink_replay(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+	config := Config{
+		Size:     5,
+		Overflow: OverflowDropNewest,
+		DeadLetter: DeadLetterConfig{
+			Directory:      dir,
+			ReplayInterval: 10 * time.Millisecond,
+		},
+	}
+	sink := &fakeSink{err: errors.New("sink is unavailable")}
+	s, err := NewBufferedSink("audit", "replay", sink, config)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Start(ctx)
+	assert.Nil(s.Write(ctx, newResults(1, 2, 3)))
+
+	// the failed writes are spilled and replayed until the sink is available
+	assert.Eventually(func() bool {
+		return testutil.ToFloat64(metrics.SinkReplayedResults.WithLabelValues("audit", "replay")) >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+	sink.lock.Lock()
+	sink.err = nil
+	sink.lock.Unlock()
+	assert.Eventually(func() bool { return len(sink.written()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.ElementsMatch([]string{"1", "2", "3"}, sink.written())
+	cancel()
+
+	// the results not written when the sink stops are replayed after the restart
+	sink.lock.Lock()
+	sink.err = errors.New("sink is unavailable")
+	sink.lock.Unlock()
+	s, err = NewBufferedSink("audit", "replay", sink, config)
+	assert.Nil(err)
+	assert.Nil(s.Write(context.Background(), newResults(4, 5)))
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(s.Start(ctx))
+	assert.Equal(2, s.deadLetter.len())
+
+	sink.lock.Lock()
+	sink.err = nil
+	sink.lock.Unlock()
+	s, err = NewBufferedSink("audit", "replay", sink, config)
+	assert.Nil(err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+	assert.Eventually(func() bool { return len(sink.written()) == 5 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(filepath.Join(dir, "audit-replay.jsonl"), s.deadLetter.path)
+}
+
+func TestDeadLetter(t *testing.T) {
+	assert := require.New(t)
+	path := filepath.Join(t.TempDir(), "dead-letter", "audit-sink.jsonl")
+	d, err := newDeadLetter(path, 0)
+	assert.Nil(err)
+
+	written, err := d.write(newResults(1, 2, 3))
+	assert.Nil(err)
+	assert.Equal(3, written)
+
+	taken, err := d.take(2)
+	assert.Nil(err)
+	assert.Len(taken, 2)
+	assert.Equal("1", taken[0].ID)
+	assert.Equal(1, d.len())
+
+	// the results are kept across restarts
+	d, err = newDeadLetter(path, d.size)
+	assert.Nil(err)
+	assert.Equal(1, d.len())
+	written, err = d.write(newResults(4))
+	assert.ErrorIs(err, errDeadLetterFull)
+	assert.Equal(0, written)
+
+	taken, err = d.take(10)
+	assert.Nil(err)
+	assert.Len(taken, 1)
+	assert.Equal("3", taken[0].ID)
+	assert.Equal(0, d.len())
+	assert.NoFileExists(path)
+}
+
+func TestNewBufferedSink(t *testing.T) {
+	_, err := NewBufferedSink("audit", "sink", &fakeSink{}, Config{Overflow: "drop-all"})
+	require.Error(t, err)
+
+	s, err := NewBufferedSink("audit", "sink", &fakeSink{}, Config{})
+	require.Nil(t, err)
+	require.Equal(t, DefaultSize, cap(s.queue))
+	require.Equal(t, OverflowBlock, s.overflow)
+	require.Nil(t, s.deadLetter)
+}
@@ -0,0 +1,187 @@
+package buffered
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+var errDeadLetterFull = errors.New("dead letter file is full")
+
+// deadLetter stores the undeliverable results of a sink in a JSON lines file
+type deadLetter struct {
+	lock    sync.Mutex
+	path    string
+	maxSize int64
+	size    int64
+	count   int
+}
+
+// newDeadLetter opens the dead letter file of a sink, the results of a previous run are kept to be replayed
+func newDeadLetter(path string, maxSize int64) (*deadLetter, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead letter directory: %w", err)
+	}
+	d := &deadLetter{path: path, maxSize: maxSize}
+	results, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+	d.count = len(results)
+	if info, err := os.Stat(path); err == nil {
+		d.size = info.Size()
+	}
+	return d, nil
+}
+
+// write appends the results to the file, the results exceeding the max size are not written
+func (d *deadLetter) write(results []domain.PolicyValidation) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	written := 0
+	var full error
+	for i := range results {
+		line, err := json.Marshal(results[i])
+		if err != nil {
+			return written, fmt.Errorf("failed to encode result: %w", err)
+		}
+		line = append(line, '\n')
+		if d.maxSize > 0 && d.size+int64(len(line)) > d.maxSize {
+			full = errDeadLetterFull
+			break
+		}
+		_, err = w.Write(line)
+		if err != nil {
+			return written, fmt.Errorf("failed to write dead letter file: %w", err)
+		}
+		d.size += int64(len(line))
+		d.count++
+		written++
+	}
+	flushErr := w.Flush()
+	if flushErr != nil {
+		return written, fmt.Errorf("failed to write dead letter file: %w", flushErr)
+	}
+	return written, full
+}
+
+// take removes up to n of the oldest results from the file and returns them
+func (d *deadLetter) take(n int) ([]domain.PolicyValidation, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.count == 0 || n <= 0 {
+		return nil, nil
+	}
+	results, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+	if n > len(results) {
+		n = len(results)
+	}
+	taken, remaining := results[:n], results[n:]
+	err = d.rewrite(remaining)
+	if err != nil {
+		return nil, err
+	}
+	return taken, nil
+}
+
+func (d *deadLetter) read() ([]domain.PolicyValidation, error) {
+	f, err := os.Open(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	var results []domain.PolicyValidation
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var result domain.PolicyValidation
+		// a line partially written before a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+	return results, nil
+}
+
+// rewrite replaces the file content by the results
+func (d *deadLetter) rewrite(results []domain.PolicyValidation) error {
+	if len(results) == 0 {
+		err := os.Remove(d.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove dead letter file: %w", err)
+		}
+		d.size, d.count = 0, 0
+		return nil
+	}
+
+	tmp := d.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create dead letter file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i := range results {
+		err := encoder.Encode(results[i])
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to encode result: %w", err)
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	err = os.Rename(tmp, d.path)
+	if err != nil {
+		return fmt.Errorf("failed to replace dead letter file: %w", err)
+	}
+
+	info, err := os.Stat(d.path)
+	if err == nil {
+		d.size = info.Size()
+	}
+	d.count = len(results)
+	return nil
+}
+
+// len returns the number of results in the file
+func (d *deadLetter) len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.count
+}
@@ -0,0 +1,175 @@
+package cloud_events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"github.com/weaveworks/policy-agent/pkg/uuid-go"
+)
+
+const (
+	resultChanSize int = 50
+	// ModeStructured sends the event attributes and data in a application/cloudevents+json body
+	ModeStructured = "structured"
+	// ModeBinary sends the event attributes in ce- headers and the data in the body
+	ModeBinary = "binary"
+
+	retries      = 5
+	retriesDelay = 500 * time.Millisecond
+
+	// defaultSource is the events source when the cluster id is not set, the source is a required attribute
+	defaultSource = "policy-agent"
+)
+
+// CloudEventsSink sends each result as a cloud event to an http target
+type CloudEventsSink struct {
+	name         string
+	client       cloudevents.Client
+	target       string
+	mode         string
+	clusterID    string
+	resultChan   chan domain.PolicyValidation
+	cancelWorker context.CancelFunc
+}
+
+// NewCloudEventsSink returns a sink that sends results as cloud events to the target url using
+// the structured or binary content mode, the events source is the cluster id or policy-agent if it's not set
+func NewCloudEventsSink(name, target, mode, clusterID string) (*CloudEventsSink, error) {
+	if target == "" {
+		return nil, fmt.Errorf("cloud events target is not set")
+	}
+	if mode == "" {
+		mode = ModeStructured
+	}
+	if mode != ModeStructured && mode != ModeBinary {
+		return nil, fmt.Errorf("invalid cloud events mode %s, should be one of: %s, %s", mode, ModeStructured, ModeBinary)
+	}
+	client, err := cloudevents.NewClientHTTP(cloudevents.WithTarget(target))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud events client: %w", err)
+	}
+	return &CloudEventsSink{
+		name:       name,
+		client:     client,
+		target:     target,
+		mode:       mode,
+		clusterID:  clusterID,
+		resultChan: make(chan domain.PolicyValidation, resultChanSize),
+	}, nil
+}
+
+// Start starts the writer worker
+func (c *CloudEventsSink) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancelWorker = cancel
+	return c.writeWorker(ctx)
+}
+
+// Stop stops worker
+func (c *CloudEventsSink) Stop() {
+	// the worker is not started when the agent fails to start
+	if c.cancelWorker == nil {
+		return
+	}
+	c.cancelWorker()
+}
+
+// Write adds results to buffer, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (c *CloudEventsSink) Write(_ context.Context, results []domain.PolicyValidation) error {
+	logger.Debugw("writing validation results", "sink", "cloud_events", "count", len(results))
+	for _, result := range results {
+		c.resultChan <- result
+	}
+	return nil
+}
+
+// Deliver sends the results and returns the results that failed to be sent,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Deliverer
+func (c *CloudEventsSink) Deliver(ctx context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	var undelivered []domain.PolicyValidation
+	var err error
+	for _, result := range results {
+		sendErr := c.send(ctx, result)
+		if sendErr != nil {
+			undelivered = append(undelivered, result)
+			err = sendErr
+		}
+	}
+	return undelivered, err
+}
+
+func (c *CloudEventsSink) writeWorker(ctx context.Context) error {
+	for {
+		select {
+		case result := <-c.resultChan:
+			c.write(ctx, result)
+		case <-ctx.Done():
+			logger.Info("stopping write worker ...")
+			return nil
+		}
+	}
+}
+
+func (c *CloudEventsSink) write(ctx context.Context, result domain.PolicyValidation) {
+	err := c.send(ctx, result)
+	if err != nil {
+		logger.Errorw("failed to send cloud event", "target", c.target, "policy", result.Policy.ID, "error", err)
+		metrics.SinkWriteFailures.WithLabelValues(c.name).Inc()
+	}
+}
+
+// send sends the cloud event of a result, the results that can't be converted to a valid event are discarded
+// as they would never be sent
+func (c *CloudEventsSink) send(ctx context.Context, result domain.PolicyValidation) error {
+	event, err := c.newEvent(result)
+	if err != nil {
+		logger.Errorw("failed to create cloud event", "policy", result.Policy.ID, "error", err)
+		return nil
+	}
+
+	ctx = cloudevents.ContextWithRetriesExponentialBackoff(ctx, retriesDelay, retries)
+	if c.mode == ModeBinary {
+		ctx = cloudevents.WithEncodingBinary(ctx)
+	} else {
+		ctx = cloudevents.WithEncodingStructured(ctx)
+	}
+	res := c.client.Send(ctx, event)
+	if !cloudevents.IsACK(res) {
+		return fmt.Errorf("failed to send cloud event %s of %s: %w", event.Type(), event.Subject(), res)
+	}
+	logger.Debugw("sent cloud event", "type", event.Type(), "subject", event.Subject())
+	return nil
+}
+
+// newEvent returns the cloud event of a result
+func (c *CloudEventsSink) newEvent(result domain.PolicyValidation) (cloudevents.Event, error) {
+	event := cloudevents.NewEvent()
+	id := result.ID
+	if id == "" {
+		id = uuid.NewV4().String()
+	}
+	event.SetID(id)
+	event.SetType(eventType(result.Status))
+	source := c.clusterID
+	if source == "" {
+		source = defaultSource
+	}
+	event.SetSource(source)
+	event.SetSubject(subject(result.Entity))
+	event.SetDataSchema(DataSchema)
+	createdAt := result.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	event.SetTime(createdAt)
+	err := event.SetData(cloudevents.ApplicationJSON, newValidationData(result))
+	if err != nil {
+		return event, err
+	}
+	return event, event.Validate()
+}
@@ -0,0 +1,230 @@
+package cloud_events
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+//go:embed schema.v1.json
+var schema []byte
+
+type request struct {
+	header http.Header
+	body   map[string]interface{}
+}
+
+func newServer(t *testing.T) (*httptest.Server, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		var body map[string]interface{}
+		require.Nil(t, json.Unmarshal(data, &body))
+		requests <- request{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func receive(t *testing.T, requests chan request) request {
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for cloud event")
+	}
+	return request{}
+}
+
+func newResult(status string, occurrences ...domain.Occurrence) domain.PolicyValidation {
+	return domain.PolicyValidation{
+		ID:        "result-id",
+		ClusterID: "cluster-id",
+		Status:    status,
+		Type:      "Admission",
+		Trigger:   "admission",
+		CreatedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Policy: domain.Policy{
+			ID:       "weave.policies.containers-minimum-replica-count",
+			Name:     "Containers Minimum Replica Count",
+			Severity: "medium",
+			Category: "weave.categories.reliability",
+		},
+		Entity: domain.Entity{
+			ID:         "entity-uid",
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Namespace:  "default",
+			Name:       "app",
+		},
+		Occurrences: occurrences,
+	}
+}
+
+func TestCloudEventsSink(t *testing.T) {
+	violatingKey := "spec.replicas"
+	tests := []struct {
+		name        string
+		mode        string
+		clusterID   string
+		result      domain.PolicyValidation
+		wantType    string
+		wantSource  string
+		wantSubject string
+	}{
+		{
+			name:        "structured violation",
+			mode:        ModeStructured,
+			clusterID:   "cluster-id",
+			result:      newResult(domain.PolicyValidationStatusViolating, domain.Occurrence{Message: "replicas is 1", ViolatingKey: &violatingKey}),
+			wantType:    EventTypeViolation,
+			wantSource:  "cluster-id",
+			wantSubject: "apps/v1/Deployment/default/app",
+		},
+		{
+			name:        "binary compliance",
+			mode:        ModeBinary,
+			clusterID:   "cluster-id",
+			result:      newResult(domain.PolicyValidationStatusCompliant),
+			wantType:    EventTypeCompliance,
+			wantSource:  "cluster-id",
+			wantSubject: "apps/v1/Deployment/default/app",
+		},
+		{
+			name:        "default source",
+			mode:        ModeStructured,
+			result:      newResult(domain.PolicyValidationStatusViolating),
+			wantType:    EventTypeViolation,
+			wantSource:  "policy-agent",
+			wantSubject: "apps/v1/Deployment/default/app",
+		},
+		{
+			name:        "mutation",
+			mode:        ModeStructured,
+			clusterID:   "cluster-id",
+			result:      newResult(domain.PolicyValidationStatusMutated, domain.Occurrence{Message: "replicas is 1", ViolatingKey: &violatingKey, Mutated: true}),
+			wantType:    EventTypeMutation,
+			wantSource:  "cluster-id",
+			wantSubject: "apps/v1/Deployment/default/app",
+		},
+		{
+			name:        "resolved",
+			mode:        ModeBinary,
+			clusterID:   "cluster-id",
+			result:      newResult(domain.PolicyValidationStatusResolved),
+			wantType:    EventTypeResolved,
+			wantSource:  "cluster-id",
+			wantSubject: "apps/v1/Deployment/default/app",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			server, requests := newServer(t)
+			sink, err := NewCloudEventsSink(SinkType, server.URL, tt.mode, tt.clusterID)
+			assert.Nil(err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go sink.Start(ctx)
+			assert.Nil(sink.Write(ctx, []domain.PolicyValidation{tt.result}))
+
+			req := receive(t, requests)
+			data := req.body
+			if tt.mode == ModeBinary {
+				assert.Equal("application/json", req.header.Get("Content-Type"))
+				assert.Equal("1.0", req.header.Get("Ce-Specversion"))
+				assert.Equal(tt.wantType, req.header.Get("Ce-Type"))
+				assert.Equal(tt.wantSource, req.header.Get("Ce-Source"))
+				assert.Equal(tt.wantSubject, req.header.Get("Ce-Subject"))
+				assert.Equal(DataSchema, req.header.Get("Ce-Dataschema"))
+				assert.Equal("result-id", req.header.Get("Ce-Id"))
+			} else {
+				assert.Equal("application/cloudevents+json", req.header.Get("Content-Type"))
+				assert.Equal("1.0", req.body["specversion"])
+				assert.Equal(tt.wantType, req.body["type"])
+				assert.Equal(tt.wantSource, req.body["source"])
+				assert.Equal(tt.wantSubject, req.body["subject"])
+				assert.Equal(DataSchema, req.body["dataschema"])
+				assert.Equal("result-id", req.body["id"])
+				data = req.body["data"].(map[string]interface{})
+			}
+
+			assert.Equal(tt.result.Policy.ID, data["policy"].(map[string]interface{})["id"])
+			assert.Equal("app", data["entity"].(map[string]interface{})["name"])
+		})
+	}
+}
+
+func TestCloudEventsSink_Deliver(t *testing.T) {
+	assert := require.New(t)
+	rejected := map[string]bool{"rejected-id": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejected[r.Header.Get("Ce-Id")] {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	sink, err := NewCloudEventsSink(SinkType, server.URL, ModeBinary, "cluster-id")
+	assert.Nil(err)
+
+	accepted := newResult(domain.PolicyValidationStatusViolating)
+	rejectedResult := newResult(domain.PolicyValidationStatusViolating)
+	rejectedResult.ID = "rejected-id"
+	undelivered, err := sink.Deliver(context.Background(), []domain.PolicyValidation{accepted, rejectedResult})
+	assert.Error(err)
+	assert.Equal([]domain.PolicyValidation{rejectedResult}, undelivered)
+
+	undelivered, err = sink.Deliver(context.Background(), []domain.PolicyValidation{accepted})
+	assert.Nil(err)
+	assert.Empty(undelivered)
+}
+
+func TestValidationDataSchema(t *testing.T) {
+	assert := require.New(t)
+	var dataSchema struct {
+		ID         string                            `json:"$id"`
+		Required   []string                          `json:"required"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	assert.Nil(json.Unmarshal(schema, &dataSchema))
+	assert.Equal(DataSchema, dataSchema.ID)
+
+	violatingKey := "spec.replicas"
+	result := newResult(domain.PolicyValidationStatusViolating, domain.Occurrence{Message: "replicas is 1", ViolatingKey: &violatingKey})
+	result.AccountID = "account-id"
+	result.Message = "message"
+	result.Policy.Tags = []string{"tag"}
+	result.Entity.ResourceVersion = "1"
+	encoded, err := json.Marshal(newValidationData(result))
+	assert.Nil(err)
+	var data map[string]interface{}
+	assert.Nil(json.Unmarshal(encoded, &data))
+
+	for _, property := range dataSchema.Required {
+		assert.Contains(data, property)
+	}
+	// the data fields are documented in the schema
+	for property := range data {
+		assert.Contains(dataSchema.Properties, property)
+	}
+}
+
+func TestNewCloudEventsSink(t *testing.T) {
+	_, err := NewCloudEventsSink(SinkType, "", ModeBinary, "cluster-id")
+	require.Error(t, err)
+	_, err = NewCloudEventsSink(SinkType, "http://localhost", "batch", "cluster-id")
+	require.Error(t, err)
+	sink, err := NewCloudEventsSink(SinkType, "http://localhost", "", "cluster-id")
+	require.Nil(t, err)
+	require.Equal(t, ModeStructured, sink.mode)
+}
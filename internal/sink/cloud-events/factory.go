@@ -0,0 +1,31 @@
+package cloud_events
+
+import (
+	"context"
+
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// SinkType is the type of the cloud events sink in the sinks configuration
+const SinkType = "cloud_events"
+
+// Config configures the cloud events sink
+type Config struct {
+	Target string
+	// Mode is the http content mode, structured or binary (default: structured)
+	Mode string
+}
+
+func init() {
+	registry.Register(SinkType, newSink)
+}
+
+func newSink(_ context.Context, deps registry.Dependencies, config registry.Config) (domain.PolicyValidationSink, error) {
+	var sinkConfig Config
+	err := config.Decode(&sinkConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewCloudEventsSink(deps.Name, sinkConfig.Target, sinkConfig.Mode, deps.ClusterID)
+}
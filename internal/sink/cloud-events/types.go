@@ -0,0 +1,119 @@
+package cloud_events
+
+import (
+	"time"
+
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+const (
+	// DataSchema identifies the schema of the events data, see schema.v1.json. Changes that are not
+	// backward compatible are released in a new schema version and new event types
+	DataSchema = "urn:weave:policy-agent:policy-validation:v1"
+
+	EventTypeViolation  = "works.weave.policy.validation.violation.v1"
+	EventTypeCompliance = "works.weave.policy.validation.compliance.v1"
+	EventTypeMutation   = "works.weave.policy.validation.mutation.v1"
+	EventTypeResolved   = "works.weave.policy.validation.resolved.v1"
+)
+
+// ValidationData is the data of a policy validation event
+type ValidationData struct {
+	ID          string           `json:"id"`
+	AccountID   string           `json:"account_id,omitempty"`
+	ClusterID   string           `json:"cluster_id,omitempty"`
+	Status      string           `json:"status"`
+	Type        string           `json:"type"`
+	Trigger     string           `json:"trigger"`
+	Message     string           `json:"message,omitempty"`
+	Enforced    bool             `json:"enforced"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Policy      PolicyData       `json:"policy"`
+	Entity      EntityData       `json:"entity"`
+	Occurrences []OccurrenceData `json:"occurrences,omitempty"`
+}
+
+// PolicyData is the validated policy of a policy validation event
+type PolicyData struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Category string   `json:"category,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// EntityData is the validated entity of a policy validation event
+type EntityData struct {
+	UID             string `json:"uid,omitempty"`
+	APIVersion      string `json:"api_version"`
+	Kind            string `json:"kind"`
+	Namespace       string `json:"namespace,omitempty"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resource_version,omitempty"`
+}
+
+// OccurrenceData is an occurrence of a policy validation event
+type OccurrenceData struct {
+	Message          string      `json:"message"`
+	ViolatingKey     *string     `json:"violating_key,omitempty"`
+	RecommendedValue interface{} `json:"recommended_value,omitempty"`
+}
+
+// eventType returns the stable event type of a result status
+func eventType(status string) string {
+	switch status {
+	case domain.PolicyValidationStatusViolating:
+		return EventTypeViolation
+	case domain.PolicyValidationStatusMutated:
+		return EventTypeMutation
+	case domain.PolicyValidationStatusResolved:
+		return EventTypeResolved
+	default:
+		return EventTypeCompliance
+	}
+}
+
+// subject returns the reference of the validated entity, e.g. apps/v1/Deployment/default/app
+func subject(entity domain.Entity) string {
+	if entity.Namespace == "" {
+		return entity.APIVersion + "/" + entity.Kind + "/" + entity.Name
+	}
+	return entity.APIVersion + "/" + entity.Kind + "/" + entity.Namespace + "/" + entity.Name
+}
+
+func newValidationData(result domain.PolicyValidation) ValidationData {
+	data := ValidationData{
+		ID:        result.ID,
+		AccountID: result.AccountID,
+		ClusterID: result.ClusterID,
+		Status:    result.Status,
+		Type:      result.Type,
+		Trigger:   result.Trigger,
+		Message:   result.Message,
+		Enforced:  result.Enforced,
+		CreatedAt: result.CreatedAt,
+		Policy: PolicyData{
+			ID:       result.Policy.ID,
+			Name:     result.Policy.Name,
+			Category: result.Policy.Category,
+			Severity: result.Policy.Severity,
+			Tags:     result.Policy.Tags,
+		},
+		Entity: EntityData{
+			UID:             result.Entity.ID,
+			APIVersion:      result.Entity.APIVersion,
+			Kind:            result.Entity.Kind,
+			Namespace:       result.Entity.Namespace,
+			Name:            result.Entity.Name,
+			ResourceVersion: result.Entity.ResourceVersion,
+		},
+	}
+	for _, occurrence := range result.Occurrences {
+		data.Occurrences = append(data.Occurrences, OccurrenceData{
+			Message:          occurrence.Message,
+			ViolatingKey:     occurrence.ViolatingKey,
+			RecommendedValue: occurrence.RecommendedValue,
+		})
+	}
+	return data
+}
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// database drivers
+	_ "github.com/lib/pq"
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"github.com/weaveworks/policy-agent/pkg/uuid-go"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+
+	// InsertionModeInsert keeps all the validation results
+	InsertionModeInsert = "insert"
+	// InsertionModeUpsert keeps only the latest validation result of each policy and entity
+	InsertionModeUpsert = "upsert"
+
+	resultChanSize  int           = 50
+	batchSize       int           = 100
+	batchExpiry     time.Duration = 10 * time.Second
+	retriesInterval time.Duration = 500 * time.Millisecond
+	retries         int           = 5
+)
+
+// DatabaseSink writes the validation results to a PostgreSQL or SQLite database
+type DatabaseSink struct {
+	name          string
+	db            *sql.DB
+	dialect       dialect
+	insertionMode string
+	resultChan    chan domain.PolicyValidation
+	cancelWorker  context.CancelFunc
+	batch         []domain.PolicyValidation
+}
+
+// NewDatabaseSink returns a sink that writes results to the database of the given driver and data source name,
+// the database schema is migrated to the latest version
+func NewDatabaseSink(ctx context.Context, name, driver, dsn, insertionMode string) (*DatabaseSink, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %s, should be one of: %s, %s", driver, DriverPostgres, DriverSQLite)
+	}
+	if insertionMode == "" {
+		insertionMode = InsertionModeInsert
+	}
+	if insertionMode != InsertionModeInsert && insertionMode != InsertionModeUpsert {
+		return nil, fmt.Errorf("invalid insertion mode %s, should be one of: %s, %s", insertionMode, InsertionModeInsert, InsertionModeUpsert)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if driver == DriverSQLite {
+		// sqlite supports a single writer
+		db.SetMaxOpenConns(1)
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	err = migrate(ctx, db, d)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DatabaseSink{
+		name:          name,
+		db:            db,
+		dialect:       d,
+		insertionMode: insertionMode,
+		resultChan:    make(chan domain.PolicyValidation, resultChanSize),
+		batch:         make([]domain.PolicyValidation, 0, batchSize),
+	}, nil
+}
+
+// Start starts the writer worker
+func (s *DatabaseSink) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancelWorker = cancel
+	return s.writeWorker(ctx)
+}
+
+// Stop stops worker
+func (s *DatabaseSink) Stop() {
+	// the worker is not started when the agent fails to start
+	if s.cancelWorker == nil {
+		return
+	}
+	s.cancelWorker()
+}
+
+// Close closes the database once the results are written,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Closer
+func (s *DatabaseSink) Close() error {
+	return s.db.Close()
+}
+
+// Write adds results to buffer, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (s *DatabaseSink) Write(_ context.Context, results []domain.PolicyValidation) error {
+	logger.Debugw("writing validation results", "sink", "database", "count", len(results))
+	for _, result := range results {
+		s.resultChan <- result
+	}
+	return nil
+}
+
+// writeWorker writes the results when the batch size is met or an interval has passed
+func (s *DatabaseSink) writeWorker(ctx context.Context) error {
+	ticker := time.NewTicker(batchExpiry)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-s.resultChan:
+			s.batch = append(s.batch, result)
+			if len(s.batch) == cap(s.batch) {
+				s.flush(ctx)
+				ticker.Reset(batchExpiry)
+			}
+		case <-ticker.C:
+			s.flush(ctx)
+		case <-ctx.Done():
+			logger.Info("stopping write worker ...")
+			flushCtx, cancel := context.WithTimeout(context.Background(), batchExpiry)
+			defer cancel()
+			s.flush(flushCtx)
+			return nil
+		}
+	}
+}
+
+// Deliver writes the results in a transaction, the results are not delivered when the transaction fails,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Deliverer
+func (s *DatabaseSink) Deliver(ctx context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	err := s.write(ctx, results)
+	if err != nil {
+		return results, err
+	}
+	return nil, nil
+}
+
+func (s *DatabaseSink) flush(ctx context.Context) {
+	if len(s.batch) == 0 {
+		return
+	}
+	err := s.write(ctx, s.batch)
+	if err != nil {
+		logger.Errorw("failed to write policy validations to database", "count", len(s.batch), "error", err)
+		metrics.SinkWriteFailures.WithLabelValues(s.name).Inc()
+	}
+	s.batch = s.batch[:0]
+}
+
+// write writes a batch of results, the failed transactions are retried
+func (s *DatabaseSink) write(ctx context.Context, results []domain.PolicyValidation) error {
+	var err error
+	for i := 0; i < retries; i++ {
+		err = s.writeBatch(ctx, results)
+		if err == nil {
+			return nil
+		}
+		logger.Warnw("failed to write policy validations to database", "retry", i+1, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retriesInterval):
+		}
+	}
+	return err
+}
+
+// writeBatch writes a batch of results in a transaction
+func (s *DatabaseSink) writeBatch(ctx context.Context, results []domain.PolicyValidation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for i := range results {
+		err := s.writeResult(ctx, tx, results[i], now)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	logger.Debugw("wrote policy validations to database", "count", len(results))
+	return nil
+}
+
+func (s *DatabaseSink) writeResult(ctx context.Context, tx *sql.Tx, result domain.PolicyValidation, now time.Time) error {
+	_, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO policies (id, name, category, severity, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name, category = excluded.category, severity = excluded.severity, updated_at = excluded.updated_at`),
+		result.Policy.ID, result.Policy.Name, result.Policy.Category, result.Policy.Severity, now)
+	if err != nil {
+		return fmt.Errorf("failed to write policy %s: %w", result.Policy.ID, err)
+	}
+
+	var entityUID uuid.NullUUID
+	if uid, err := uuid.FromString(result.Entity.ID); err == nil {
+		entityUID = uuid.NullUUID{UUID: uid, Valid: true}
+	}
+	var entityID int64
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(`
+		INSERT INTO entities (uid, api_version, kind, namespace, name, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (api_version, kind, namespace, name) DO UPDATE SET
+			uid = excluded.uid, updated_at = excluded.updated_at
+		RETURNING id`),
+		entityUID, result.Entity.APIVersion, result.Entity.Kind, result.Entity.Namespace, result.Entity.Name, now).Scan(&entityID)
+	if err != nil {
+		return fmt.Errorf("failed to write entity %s/%s: %w", result.Entity.Kind, result.Entity.Name, err)
+	}
+
+	if s.insertionMode == InsertionModeUpsert {
+		err := s.deleteValidations(ctx, tx, result.Policy.ID, entityID)
+		if err != nil {
+			return err
+		}
+	}
+
+	id, err := uuid.FromString(result.ID)
+	if err != nil {
+		id = uuid.NewV4()
+	}
+	createdAt := result.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO validations (id, policy_id, entity_id, account_id, cluster_id, status, type, trigger, message, enforced, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`),
+		id, result.Policy.ID, entityID, result.AccountID, result.ClusterID, result.Status, result.Type,
+		result.Trigger, result.Message, result.Enforced, createdAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to write validation %s: %w", id, err)
+	}
+	// the result was already written
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return nil
+	}
+
+	for _, occurrence := range result.Occurrences {
+		var recommendedValue sql.NullString
+		if occurrence.RecommendedValue != nil {
+			value, err := json.Marshal(occurrence.RecommendedValue)
+			if err != nil {
+				return fmt.Errorf("failed to encode occurrence recommended value: %w", err)
+			}
+			recommendedValue = sql.NullString{String: string(value), Valid: true}
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO occurrences (validation_id, message, violating_key, recommended_value) VALUES (?, ?, ?, ?)`),
+			id, occurrence.Message, occurrence.ViolatingKey, recommendedValue)
+		if err != nil {
+			return fmt.Errorf("failed to write validation %s occurrence: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// deleteValidations deletes the previous validations of a policy and entity with their occurrences
+func (s *DatabaseSink) deleteValidations(ctx context.Context, tx *sql.Tx, policyID string, entityID int64) error {
+	_, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		DELETE FROM occurrences WHERE validation_id IN (
+			SELECT id FROM validations WHERE policy_id = ? AND entity_id = ?
+		)`), policyID, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to delete previous occurrences of policy %s: %w", policyID, err)
+	}
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM validations WHERE policy_id = ? AND entity_id = ?`), policyID, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to delete previous validations of policy %s: %w", policyID, err)
+	}
+	return nil
+}
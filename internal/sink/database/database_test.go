@@ -0,0 +1,247 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"github.com/weaveworks/policy-agent/pkg/uuid-go"
+)
+
+func newResult(policyID, entityName, status string, createdAt time.Time, occurrences ...domain.Occurrence) domain.PolicyValidation {
+	return domain.PolicyValidation{
+		ID:        uuid.NewV4().String(),
+		ClusterID: "cluster",
+		Policy: domain.Policy{
+			ID:       policyID,
+			Name:     policyID,
+			Category: "category",
+			Severity: "high",
+		},
+		Entity: domain.Entity{
+			ID:         uuid.NewV4().String(),
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Namespace:  "default",
+			Name:       entityName,
+		},
+		Status:      status,
+		Type:        "Audit",
+		Trigger:     "periodic-audit",
+		CreatedAt:   createdAt,
+		Occurrences: occurrences,
+	}
+}
+
+type validationRow struct {
+	id       uuid.UUID
+	policyID string
+	entity   string
+	status   string
+}
+
+func queryValidations(t *testing.T, sink *DatabaseSink) []validationRow {
+	rows, err := sink.db.Query(`
+		SELECT v.id, v.policy_id, e.name, v.status
+		FROM validations v JOIN entities e ON e.id = v.entity_id
+		ORDER BY v.policy_id, e.name, v.created_at`)
+	require.Nil(t, err)
+	defer rows.Close()
+
+	var validations []validationRow
+	for rows.Next() {
+		var row validationRow
+		require.Nil(t, rows.Scan(&row.id, &row.policyID, &row.entity, &row.status))
+		validations = append(validations, row)
+	}
+	require.Nil(t, rows.Err())
+	return validations
+}
+
+func count(t *testing.T, sink *DatabaseSink, table string) int {
+	var n int
+	require.Nil(t, sink.db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&n))
+	return n
+}
+
+func TestDatabaseSink_writeBatch(t *testing.T) {
+	violatingKey := "spec.replicas"
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := []domain.PolicyValidation{
+		newResult("policy-1", "app", domain.PolicyValidationStatusViolating, start,
+			domain.Occurrence{Message: "replicas is 1", ViolatingKey: &violatingKey, RecommendedValue: 3},
+			domain.Occurrence{Message: "other"},
+		),
+		newResult("policy-2", "app", domain.PolicyValidationStatusCompliant, start),
+	}
+	second := []domain.PolicyValidation{
+		newResult("policy-1", "app", domain.PolicyValidationStatusCompliant, start.Add(time.Hour)),
+		newResult("policy-1", "worker", domain.PolicyValidationStatusViolating, start.Add(time.Hour),
+			domain.Occurrence{Message: "replicas is 1"},
+		),
+	}
+
+	tests := []struct {
+		name            string
+		insertionMode   string
+		wantStatuses    []string
+		wantOccurrences int
+	}{
+		{
+			name:          "insert",
+			insertionMode: InsertionModeInsert,
+			wantStatuses: []string{
+				"policy-1/app/Violation",
+				"policy-1/app/Compliance",
+				"policy-1/worker/Violation",
+				"policy-2/app/Compliance",
+			},
+			wantOccurrences: 3,
+		},
+		{
+			name:          "upsert",
+			insertionMode: InsertionModeUpsert,
+			wantStatuses: []string{
+				"policy-1/app/Compliance",
+				"policy-1/worker/Violation",
+				"policy-2/app/Compliance",
+			},
+			wantOccurrences: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			ctx := context.Background()
+			sink, err := NewDatabaseSink(ctx, SinkType, DriverSQLite, filepath.Join(t.TempDir(), "results.db"), tt.insertionMode)
+			assert.Nil(err)
+			defer sink.db.Close()
+
+			assert.Nil(sink.writeBatch(ctx, first))
+			assert.Nil(sink.writeBatch(ctx, second))
+			// results written again are skipped
+			assert.Nil(sink.writeBatch(ctx, second))
+
+			var statuses []string
+			for _, row := range queryValidations(t, sink) {
+				assert.False(row.id.IsNil())
+				statuses = append(statuses, row.policyID+"/"+row.entity+"/"+row.status)
+			}
+			assert.Equal(tt.wantStatuses, statuses)
+			assert.Equal(2, count(t, sink, "policies"))
+			assert.Equal(2, count(t, sink, "entities"))
+			assert.Equal(tt.wantOccurrences, count(t, sink, "occurrences"))
+		})
+	}
+}
+
+func TestDatabaseSink_occurrences(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	sink, err := NewDatabaseSink(ctx, SinkType, DriverSQLite, filepath.Join(t.TempDir(), "results.db"), "")
+	assert.Nil(err)
+	defer sink.db.Close()
+
+	violatingKey := "spec.replicas"
+	result := newResult("policy", "app", domain.PolicyValidationStatusViolating, time.Now(),
+		domain.Occurrence{Message: "replicas is 1", ViolatingKey: &violatingKey, RecommendedValue: map[string]int{"replicas": 3}},
+	)
+	assert.Nil(sink.writeBatch(ctx, []domain.PolicyValidation{result}))
+
+	var validationID uuid.UUID
+	var message, key, value string
+	err = sink.db.QueryRow(`SELECT validation_id, message, violating_key, recommended_value FROM occurrences`).
+		Scan(&validationID, &message, &key, &value)
+	assert.Nil(err)
+	assert.Equal(result.ID, validationID.String())
+	assert.Equal("replicas is 1", message)
+	assert.Equal(violatingKey, key)
+	assert.JSONEq(`{"replicas": 3}`, value)
+}
+
+func TestDatabaseSink_migrations(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "results.db")
+
+	for i := 0; i < 2; i++ {
+		sink, err := NewDatabaseSink(ctx, SinkType, DriverSQLite, dsn, InsertionModeInsert)
+		assert.Nil(err)
+		var version int
+		assert.Nil(sink.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+		assert.Equal(migrations[len(migrations)-1].version, version)
+		assert.Equal(len(migrations), count(t, sink, "schema_migrations"))
+		sink.db.Close()
+	}
+
+	_, err := NewDatabaseSink(ctx, SinkType, "mysql", dsn, InsertionModeInsert)
+	assert.Error(err)
+	_, err = NewDatabaseSink(ctx, SinkType, DriverSQLite, dsn, "replace")
+	assert.Error(err)
+}
+
+func TestDatabaseSink(t *testing.T) {
+	assert := require.New(t)
+	dsn := filepath.Join(t.TempDir(), "results.db")
+	sink, err := NewDatabaseSink(context.Background(), SinkType, DriverSQLite, dsn, InsertionModeInsert)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sink.Start(ctx)
+		close(done)
+	}()
+
+	var results []domain.PolicyValidation
+	for i := 0; i < batchSize+1; i++ {
+		results = append(results, newResult("policy", "app", domain.PolicyValidationStatusViolating, time.Now()))
+	}
+	assert.Nil(sink.Write(ctx, results))
+	assert.Eventually(func() bool { return count(t, sink, "validations") == batchSize }, 5*time.Second, 10*time.Millisecond)
+
+	// the pending results are written when the sink stops
+	cancel()
+	<-done
+	assert.Nil(sink.db.Ping(), "database should not be closed by the worker")
+	assert.Nil(sink.Close())
+	assert.Error(sink.db.Ping(), "database should be closed")
+
+	sink, err = NewDatabaseSink(context.Background(), SinkType, DriverSQLite, dsn, InsertionModeInsert)
+	assert.Nil(err)
+	defer sink.db.Close()
+	assert.Equal(batchSize+1, count(t, sink, "validations"))
+}
+
+func TestDatabaseSink_Deliver(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	sink, err := NewDatabaseSink(ctx, SinkType, DriverSQLite, filepath.Join(t.TempDir(), "results.db"), InsertionModeInsert)
+	assert.Nil(err)
+
+	results := []domain.PolicyValidation{
+		newResult("policy-1", "app", domain.PolicyValidationStatusViolating, time.Now()),
+		newResult("policy-2", "app", domain.PolicyValidationStatusViolating, time.Now()),
+	}
+	undelivered, err := sink.Deliver(ctx, results)
+	assert.Nil(err)
+	assert.Empty(undelivered)
+	assert.Equal(2, count(t, sink, "validations"))
+
+	// the results are not delivered when the transaction fails
+	sink.db.Close()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	undelivered, err = sink.Deliver(cancelled, results)
+	assert.Error(err)
+	assert.Equal(results, undelivered)
+}
+
+func TestDialect_rebind(t *testing.T) {
+	query := `SELECT * FROM validations WHERE policy_id = ? AND entity_id = ?`
+	require.Equal(t, query, dialects[DriverSQLite].rebind(query))
+	require.Equal(t, `SELECT * FROM validations WHERE policy_id = $1 AND entity_id = $2`, dialects[DriverPostgres].rebind(query))
+}
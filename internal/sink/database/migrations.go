@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/policy-agent/pkg/logger"
+)
+
+// dialect holds the differences between the supported databases
+type dialect struct {
+	// autoIncrement is the type of an auto incremented primary key
+	autoIncrement string
+	uuid          string
+	// numbered placeholders are used instead of ?
+	numbered bool
+}
+
+var dialects = map[string]dialect{
+	DriverPostgres: {autoIncrement: "BIGSERIAL PRIMARY KEY", uuid: "UUID", numbered: true},
+	DriverSQLite:   {autoIncrement: "INTEGER PRIMARY KEY AUTOINCREMENT", uuid: "TEXT"},
+}
+
+// rebind replaces the ? placeholders of a query with the dialect placeholders
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// migration is a versioned schema change, migrations are applied once in order and never changed
+type migration struct {
+	version    int
+	statements func(d dialect) []string
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		statements: func(d dialect) []string {
+			return []string{
+				`CREATE TABLE policies (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					category TEXT NOT NULL DEFAULT '',
+					severity TEXT NOT NULL DEFAULT '',
+					updated_at TIMESTAMP NOT NULL
+				)`,
+				fmt.Sprintf(`CREATE TABLE entities (
+					id %s,
+					uid %s,
+					api_version TEXT NOT NULL,
+					kind TEXT NOT NULL,
+					namespace TEXT NOT NULL DEFAULT '',
+					name TEXT NOT NULL,
+					updated_at TIMESTAMP NOT NULL,
+					UNIQUE (api_version, kind, namespace, name)
+				)`, d.autoIncrement, d.uuid),
+				fmt.Sprintf(`CREATE TABLE validations (
+					id %s PRIMARY KEY,
+					policy_id TEXT NOT NULL REFERENCES policies (id),
+					entity_id BIGINT NOT NULL REFERENCES entities (id),
+					account_id TEXT NOT NULL DEFAULT '',
+					cluster_id TEXT NOT NULL DEFAULT '',
+					status TEXT NOT NULL,
+					type TEXT NOT NULL DEFAULT '',
+					trigger TEXT NOT NULL DEFAULT '',
+					message TEXT NOT NULL DEFAULT '',
+					enforced BOOLEAN NOT NULL DEFAULT FALSE,
+					created_at TIMESTAMP NOT NULL
+				)`, d.uuid),
+				`CREATE INDEX validations_policy_entity ON validations (policy_id, entity_id, created_at)`,
+				`CREATE INDEX validations_created_at ON validations (created_at)`,
+				fmt.Sprintf(`CREATE TABLE occurrences (
+					id %s,
+					validation_id %s NOT NULL REFERENCES validations (id) ON DELETE CASCADE,
+					message TEXT NOT NULL,
+					violating_key TEXT,
+					recommended_value TEXT
+				)`, d.autoIncrement, d.uuid),
+				`CREATE INDEX occurrences_validation ON occurrences (validation_id)`,
+			}
+		},
+	},
+}
+
+// migrate applies the migrations newer than the database schema version
+func migrate(ctx context.Context, db *sql.DB, d dialect) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema migrations table: %w", err)
+	}
+
+	var current sql.NullInt64
+	err = db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if int64(m.version) <= current.Int64 {
+			continue
+		}
+		err := applyMigration(ctx, db, d, m)
+		if err != nil {
+			return fmt.Errorf("failed to apply schema migration %d: %w", m.version, err)
+		}
+		logger.Infow("applied database schema migration", "version", m.version)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, d dialect, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statements(d) {
+		_, err := tx.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, d.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), m.version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,162 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+const DefaultTTL = 24 * time.Hour
+
+// Config configures the deduplication of the results written to a sink
+type Config struct {
+	// ReminderInterval is the interval of writing again an unchanged result, unchanged results are not written again if not set
+	ReminderInterval time.Duration
+	// WriteCompliance writes the new compliances, otherwise only the compliances resolving a violation are written
+	WriteCompliance bool
+	// TTL is the time the state of a policy and entity is kept after its last validation
+	TTL time.Duration
+}
+
+// state is the last written result of a policy and entity
+type state struct {
+	status    string
+	hash      string
+	writtenAt time.Time
+	seenAt    time.Time
+}
+
+// DedupSink writes to the sinks only the results changing the state of a policy and entity: new violations,
+// violations with changed occurrences and resolved violations, which are written as Resolved results
+type DedupSink struct {
+	lock    sync.Mutex
+	mode    string
+	name    string
+	sink    domain.PolicyValidationSink
+	config  Config
+	states  map[string]*state
+	evictAt time.Time
+	getTime func() time.Time
+}
+
+// NewDedupSink returns a sink deduplicating the results of a mode written to the named sink
+func NewDedupSink(mode, name string, config Config, sink domain.PolicyValidationSink) *DedupSink {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+	return &DedupSink{
+		mode:    mode,
+		name:    name,
+		sink:    sink,
+		config:  config,
+		states:  make(map[string]*state),
+		getTime: time.Now,
+	}
+}
+
+// Write writes the results changing the state to the sink, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (d *DedupSink) Write(ctx context.Context, results []domain.PolicyValidation) error {
+	changes := d.changes(results)
+	if suppressed := len(results) - len(changes); suppressed > 0 {
+		logger.Debugw("suppressed repeated validation results", "mode", d.mode, "sink", d.name, "count", suppressed)
+		metrics.SinkSuppressedResults.WithLabelValues(d.mode, d.name).Add(float64(suppressed))
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return d.sink.Write(ctx, changes)
+}
+
+// changes updates the states by the results and returns the results to write
+func (d *DedupSink) changes(results []domain.PolicyValidation) []domain.PolicyValidation {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := d.getTime()
+	d.evict(now)
+
+	var changes []domain.PolicyValidation
+	for _, result := range results {
+		key := stateKey(result)
+		previous, found := d.states[key]
+
+		switch result.Status {
+		case domain.PolicyValidationStatusViolating:
+			hash := occurrencesHash(result.Occurrences)
+			if found && previous.status == domain.PolicyValidationStatusViolating && previous.hash == hash && !d.remind(previous, now) {
+				previous.seenAt = now
+				continue
+			}
+			d.states[key] = &state{status: result.Status, hash: hash, writtenAt: now, seenAt: now}
+			changes = append(changes, result)
+		case domain.PolicyValidationStatusCompliant:
+			if found && previous.status == domain.PolicyValidationStatusViolating {
+				resolved := result
+				resolved.Status = domain.PolicyValidationStatusResolved
+				changes = append(changes, resolved)
+			} else if !d.config.WriteCompliance {
+				continue
+			} else if found && !d.remind(previous, now) {
+				previous.seenAt = now
+				continue
+			} else {
+				changes = append(changes, result)
+			}
+			// the compliances are only tracked when they are written
+			if d.config.WriteCompliance {
+				d.states[key] = &state{status: result.Status, writtenAt: now, seenAt: now}
+			} else {
+				delete(d.states, key)
+			}
+		default:
+			changes = append(changes, result)
+		}
+	}
+	return changes
+}
+
+// remind checks if an unchanged result is written again
+func (d *DedupSink) remind(previous *state, now time.Time) bool {
+	return d.config.ReminderInterval > 0 && now.Sub(previous.writtenAt) >= d.config.ReminderInterval
+}
+
+// evict removes the states of the policies and entities that were not validated for the TTL,
+// e.g. deleted resources, the states are checked at most once every TTL
+func (d *DedupSink) evict(now time.Time) {
+	if now.Before(d.evictAt) {
+		return
+	}
+	for key, state := range d.states {
+		if now.Sub(state.seenAt) >= d.config.TTL {
+			delete(d.states, key)
+		}
+	}
+	d.evictAt = now.Add(d.config.TTL)
+}
+
+// stateKey returns the key of the policy and entity of a result, the entities without uid, e.g. terraform plans
+// and manifests files, are identified by their reference
+func stateKey(result domain.PolicyValidation) string {
+	entity := result.Entity.ID
+	if entity == "" {
+		entity = strings.Join([]string{result.Entity.APIVersion, result.Entity.Kind, result.Entity.Namespace, result.Entity.Name}, "/")
+	}
+	return result.Policy.ID + "/" + entity
+}
+
+func occurrencesHash(occurrences []domain.Occurrence) string {
+	data, err := json.Marshal(occurrences)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
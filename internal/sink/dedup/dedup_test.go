@@ -0,0 +1,134 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+type fakeSink struct {
+	results []domain.PolicyValidation
+}
+
+func (f *fakeSink) Write(_ context.Context, results []domain.PolicyValidation) error {
+	f.results = append(f.results, results...)
+	return nil
+}
+
+func (f *fakeSink) statuses() []string {
+	var statuses []string
+	for _, result := range f.results {
+		statuses = append(statuses, result.Status)
+	}
+	f.results = nil
+	return statuses
+}
+
+func newResult(status string, messages ...string) domain.PolicyValidation {
+	result := domain.PolicyValidation{
+		Status: status,
+		Policy: domain.Policy{ID: "weave.policies.containers-minimum-replica-count"},
+		Entity: domain.Entity{ID: "3c5e1f2a-8d4b-4f4e-9a51-1b1d4f0c7e21", Name: "app"},
+	}
+	for _, message := range messages {
+		result.Occurrences = append(result.Occurrences, domain.Occurrence{Message: message})
+	}
+	return result
+}
+
+func TestDedupSink_Write(t *testing.T) {
+	violation := newResult(domain.PolicyValidationStatusViolating, "replicas is 1")
+	changedViolation := newResult(domain.PolicyValidationStatusViolating, "replicas is 0")
+	compliance := newResult(domain.PolicyValidationStatusCompliant)
+
+	tests := []struct {
+		name    string
+		config  Config
+		writes  [][]domain.PolicyValidation
+		elapsed time.Duration
+		want    [][]string
+	}{
+		{
+			name:   "repeated violation",
+			writes: [][]domain.PolicyValidation{{violation}, {violation}, {violation}},
+			want:   [][]string{{domain.PolicyValidationStatusViolating}, nil, nil},
+		},
+		{
+			name:   "changed occurrences",
+			writes: [][]domain.PolicyValidation{{violation}, {changedViolation}, {changedViolation}},
+			want:   [][]string{{domain.PolicyValidationStatusViolating}, {domain.PolicyValidationStatusViolating}, nil},
+		},
+		{
+			name:   "resolved violation",
+			writes: [][]domain.PolicyValidation{{violation}, {compliance}, {compliance}, {violation}},
+			want: [][]string{
+				{domain.PolicyValidationStatusViolating},
+				{domain.PolicyValidationStatusResolved},
+				nil,
+				{domain.PolicyValidationStatusViolating},
+			},
+		},
+		{
+			name:   "repeated compliance",
+			config: Config{WriteCompliance: true},
+			writes: [][]domain.PolicyValidation{{compliance}, {compliance}, {violation}, {compliance}},
+			want: [][]string{
+				{domain.PolicyValidationStatusCompliant},
+				nil,
+				{domain.PolicyValidationStatusViolating},
+				{domain.PolicyValidationStatusResolved},
+			},
+		},
+		{
+			name:    "reminder",
+			config:  Config{ReminderInterval: time.Hour},
+			writes:  [][]domain.PolicyValidation{{violation}, {violation}, {violation}},
+			elapsed: 40 * time.Minute,
+			want:    [][]string{{domain.PolicyValidationStatusViolating}, nil, {domain.PolicyValidationStatusViolating}},
+		},
+		{
+			name:    "evicted state",
+			config:  Config{TTL: time.Hour},
+			writes:  [][]domain.PolicyValidation{{violation}, {violation}},
+			elapsed: 2 * time.Hour,
+			want:    [][]string{{domain.PolicyValidationStatusViolating}, {domain.PolicyValidationStatusViolating}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			sink := &fakeSink{}
+			d := NewDedupSink("audit", "fake", tt.config, sink)
+			now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+			d.getTime = func() time.Time { return now }
+
+			for i, results := range tt.writes {
+				assert.Nil(d.Write(context.Background(), results))
+				assert.Equal(tt.want[i], sink.statuses(), "write %d", i)
+				now = now.Add(tt.elapsed)
+			}
+		})
+	}
+}
+
+func TestDedupSink_suppressedMetric(t *testing.T) {
+	assert := require.New(t)
+	sink := &fakeSink{}
+	d := NewDedupSink("dedup-metric", "fake", Config{}, sink)
+
+	violation := newResult(domain.PolicyValidationStatusViolating, "replicas is 1")
+	other := violation
+	other.Entity.ID = ""
+	other.Entity.Name = "other-app"
+
+	assert.Nil(d.Write(context.Background(), []domain.PolicyValidation{violation, other}))
+	assert.Len(sink.results, 2)
+	assert.Nil(d.Write(context.Background(), []domain.PolicyValidation{violation, other}))
+	assert.Len(sink.results, 2)
+	assert.Equal(2.0, testutil.ToFloat64(metrics.SinkSuppressedResults.WithLabelValues("dedup-metric", "fake")))
+}
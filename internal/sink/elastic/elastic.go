@@ -11,6 +11,7 @@ import (
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/pkg/errors"
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 )
@@ -36,6 +37,7 @@ type Index struct {
 }
 
 type ElasticSearchSink struct {
+	name                   string
 	policyValidationChan   chan domain.PolicyValidation
 	elasticClient          *elasticsearch.Client
 	indexName              string
@@ -44,7 +46,7 @@ type ElasticSearchSink struct {
 }
 
 // NewElasticSearchSink returns a sink that sends results to elasticsearch index
-func NewElasticSearchSink(address, username, password, index, insertionMode string) (*ElasticSearchSink, error) {
+func NewElasticSearchSink(name, address, username, password, index, insertionMode string) (*ElasticSearchSink, error) {
 	client, err := elasticsearch.NewClient(
 		elasticsearch.Config{
 			Addresses: []string{address},
@@ -62,6 +64,7 @@ func NewElasticSearchSink(address, username, password, index, insertionMode stri
 	}
 
 	return &ElasticSearchSink{
+		name:                   name,
 		policyValidationChan:   make(chan domain.PolicyValidation, resultChanSize),
 		policyValidationsBatch: make([]domain.PolicyValidation, 0, batchSize),
 		elasticClient:          client,
@@ -105,26 +108,47 @@ func (es *ElasticSearchSink) Start(ctx context.Context) error {
 	}
 }
 
+// Deliver writes the results in a bulk request, the results are not delivered when the request fails,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Deliverer
+func (es *ElasticSearchSink) Deliver(_ context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	err := es.write(results)
+	if err != nil {
+		return results, err
+	}
+	return nil, nil
+}
+
 func (es *ElasticSearchSink) writeBatch(items []domain.PolicyValidation) {
-	var err error
-	logger.Infow("writing policy validations", "size", len(items), "index", es.indexName)
+	err := es.write(items)
+	if err != nil {
+		logger.Errorw("failed to write policy validations", "index", es.indexName, "error", err)
+		metrics.SinkWriteFailures.WithLabelValues(es.name).Inc()
+	}
+}
 
+// write writes a batch of results in a bulk request, the failed requests are retried
+func (es *ElasticSearchSink) write(items []domain.PolicyValidation) error {
+	logger.Infow("writing policy validations", "size", len(items), "index", es.indexName)
+	body, err := createIndexBody(items, es.indexName, es.InsertionMode)
+	if err != nil {
+		return errors.WithMessage(err, "failed to create policy validation elastic search body")
+	}
 	for i := 0; i < retries; i++ {
-		body, err := createIndexBody(items, es.indexName, es.InsertionMode)
-		if err != nil {
-			logger.Errorw("failed to create policy validation elastic search body", "error", err)
-			continue
+		if i > 0 {
+			time.Sleep(retriesInterval)
 		}
-		res, err := es.elasticClient.Bulk(bytes.NewReader(body))
-		if err != nil || res.StatusCode != 200 {
-			logger.Warnw("failed to write policy validations", "index", es.indexName, "retry", i+1, "error", err)
-			continue
+		res, bulkErr := es.elasticClient.Bulk(bytes.NewReader(body))
+		if bulkErr == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return nil
+			}
+			bulkErr = fmt.Errorf("elasticsearch responded with status %d", res.StatusCode)
 		}
-		defer res.Body.Close()
-		return
+		err = bulkErr
+		logger.Warnw("failed to write policy validations", "index", es.indexName, "retry", i+1, "error", err)
 	}
-	time.Sleep(retriesInterval)
-	logger.Errorw("failed to write policy validations", "index", es.indexName, "error", err)
+	return err
 }
 
 func createIndexSchema(client *elasticsearch.Client, index string) error {
@@ -22,7 +22,7 @@ func TestWriteElasticsearchSink(t *testing.T) {
 		auditEvents = append(auditEvents, GeneratePolicyValidationObject())
 	}
 
-	sink, err := NewElasticSearchSink(
+	sink, err := NewElasticSearchSink(SinkType,
 		address, "", "", indexName, "insert",
 	)
 	if err != nil {
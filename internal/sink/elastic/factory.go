@@ -0,0 +1,38 @@
+package elastic
+
+import (
+	"context"
+	"errors"
+
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// SinkType is the type of the elasticsearch sink in the sinks configuration
+const SinkType = "elasticsearch"
+
+// Config configures the elasticsearch sink
+type Config struct {
+	Address   string
+	Username  string
+	Password  string
+	IndexName string
+	// InsertionMode is insert to keep all the results or upsert to keep the latest result of each policy and entity per day
+	InsertionMode string
+}
+
+func init() {
+	registry.Register(SinkType, newSink)
+}
+
+func newSink(_ context.Context, deps registry.Dependencies, config registry.Config) (domain.PolicyValidationSink, error) {
+	var sinkConfig Config
+	err := config.Decode(&sinkConfig)
+	if err != nil {
+		return nil, err
+	}
+	if sinkConfig.InsertionMode != "insert" && sinkConfig.InsertionMode != "upsert" {
+		return nil, errors.New("insertion mode should be one of two options: insert or upsert")
+	}
+	return NewElasticSearchSink(deps.Name, sinkConfig.Address, sinkConfig.Username, sinkConfig.Password, sinkConfig.IndexName, sinkConfig.InsertionMode)
+}
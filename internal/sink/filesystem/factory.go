@@ -0,0 +1,26 @@
+package filesystem
+
+import (
+	"context"
+
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// SinkType is the type of the file system sink in the sinks configuration
+const SinkType = "filesystem"
+
+func init() {
+	registry.Register(SinkType, newSink)
+}
+
+func newSink(_ context.Context, deps registry.Dependencies, config registry.Config) (domain.PolicyValidationSink, error) {
+	var sinkConfig Config
+	err := config.Decode(&sinkConfig)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infow("initializing filesystem sink ...", "directory", sinkConfig.Directory, "file", sinkConfig.FileName, "format", sinkConfig.Format)
+	return NewFileSystemSink(deps.Name, sinkConfig)
+}
@@ -1,92 +1,384 @@
 package filesystem
 
 import (
+	"compress/gzip"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 )
 
 const (
 	kubernetespProvider = "Kubernetes"
+	terraformProvider   = "Terraform"
+	tfAdmissionType     = "TFAdmission"
+
+	DefaultDirectory = "/logs"
+
+	// rotatedTimeLayout is the layout of the rotation time in the rotated files names, the names are sorted by time
+	rotatedTimeLayout = "20060102T150405.000"
+	resultChanSize    = 50
 )
 
+// RotationConfig configures the rotation of the results file
+type RotationConfig struct {
+	// MaxSizeMB rotates the file when its size reaches the max size in megabytes, the file is not rotated by size if not set
+	MaxSizeMB int64
+	// Interval rotates the file when it was opened for the interval, the file is not rotated periodically if not set
+	Interval time.Duration
+	// Compress compresses the rotated files using gzip
+	Compress bool
+	// MaxBackups is the number of rotated files kept, all the rotated files are kept if not set
+	MaxBackups int
+	// MaxAge is the time the rotated files are kept, the rotated files are not removed by age if not set
+	MaxAge time.Duration
+}
+
+// Config configures the file system sink
+type Config struct {
+	// Directory of the results file (default: /logs)
+	Directory string
+	FileName  string
+	// Format of the results, json, result or csv (default: json)
+	Format   string
+	Rotation RotationConfig
+}
+
+// FileSystemSink writes the results to a file, the file is rotated by size and time
 type FileSystemSink struct {
-	File                 *os.File
-	PolicyValidationChan chan domain.PolicyValidation
-	cancelWorker         context.CancelFunc
+	name         string
+	lock         sync.Mutex
+	path         string
+	format       string
+	rotation     RotationConfig
+	file         *os.File
+	size         int64
+	openedAt     time.Time
+	resultChan   chan domain.PolicyValidation
+	cancelWorker context.CancelFunc
+	done         chan struct{}
+	getTime      func() time.Time
 }
 
 // NewFileSystemSink returns a sink that writes results to the file system
-func NewFileSystemSink(filePath string) (*FileSystemSink, error) {
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+func NewFileSystemSink(name string, config Config) (*FileSystemSink, error) {
+	if config.FileName == "" {
+		return nil, errors.New("file name is not set")
+	}
+	directory := config.Directory
+	if directory == "" {
+		directory = DefaultDirectory
+	}
+	format := config.Format
+	if format == "" {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatResult && format != FormatCSV {
+		return nil, fmt.Errorf("invalid format %s, should be one of: %s, %s, %s", format, FormatJSON, FormatResult, FormatCSV)
+	}
+	err := os.MkdirAll(directory, 0755)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s to write validation results: %w", filePath, err)
+		return nil, fmt.Errorf("failed to create directory %s: %w", directory, err)
 	}
-	return &FileSystemSink{
-		File:                 file,
-		PolicyValidationChan: make(chan domain.PolicyValidation, 50),
-	}, nil
+
+	f := &FileSystemSink{
+		name:       name,
+		path:       filepath.Join(directory, config.FileName),
+		format:     format,
+		rotation:   config.Rotation,
+		resultChan: make(chan domain.PolicyValidation, resultChanSize),
+		done:       make(chan struct{}),
+		getTime:    time.Now,
+	}
+	err = f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 // Start starts the writer worker
 func (f *FileSystemSink) Start(ctx context.Context) error {
 	cancelCtx, cancel := context.WithCancel(ctx)
 	f.cancelWorker = cancel
-	return f.WritePolicyValidationWorker(cancelCtx)
+	return f.writeWorker(cancelCtx)
 }
 
-func (f *FileSystemSink) writeValidationResutl(policyValidation domain.PolicyValidation) error {
-	err := json.NewEncoder(f.File).Encode(policyValidation)
-	if err != nil {
-		return fmt.Errorf("failed to write result to file: %w", err)
+// Stop stops the writer worker and waits for the pending results to be written to the file
+func (f *FileSystemSink) Stop() {
+	// the worker is not started when the agent fails to start, the file opened by the sink is closed
+	if f.cancelWorker == nil {
+		err := f.close()
+		if err != nil {
+			logger.Errorw("failed to close validation results file", "file", f.path, "error", err)
+		}
+		return
+	}
+	f.cancelWorker()
+	<-f.done
+}
+
+// Close commits the results to disk and closes the file,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Closer
+func (f *FileSystemSink) Close() error {
+	return f.close()
+}
+
+// Write adds results to buffer, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (f *FileSystemSink) Write(_ context.Context, policyValidations []domain.PolicyValidation) error {
+	for i := range policyValidations {
+		f.resultChan <- policyValidations[i]
 	}
 	return nil
 }
 
-// WritePolicyValidationWorker worker that listens on results and admits them to a file
-func (f *FileSystemSink) WritePolicyValidationWorker(_ context.Context) error {
+// Deliver writes the results to the file and returns the results that failed to be written,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Deliverer
+func (f *FileSystemSink) Deliver(_ context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	var undelivered []domain.PolicyValidation
+	var err error
+	for _, result := range results {
+		writeErr := f.writeResult(result)
+		if writeErr != nil {
+			undelivered = append(undelivered, result)
+			err = writeErr
+		}
+	}
+	return undelivered, err
+}
+
+func (f *FileSystemSink) writeWorker(ctx context.Context) error {
+	defer close(f.done)
 	for {
 		select {
-		case result := <-f.PolicyValidationChan:
-			err := f.writeValidationResutl(result)
-			if err != nil {
-				logger.Errorw(
-					fmt.Sprintf("error while writing %s results", result.Type),
-					"error", err,
-					"policy-id", result.Policy.ID,
-					"entity-name", result.Entity.Name,
-					"entity-type", result.Entity.Kind,
-					"status", result.Status,
-				)
+		case result := <-f.resultChan:
+			f.write(result)
+		case <-ctx.Done():
+			logger.Info("stopping write worker ...")
+			for len(f.resultChan) > 0 {
+				f.write(<-f.resultChan)
 			}
+			return f.close()
 		}
 	}
 }
 
-// Write adds results to buffer, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
-func (f *FileSystemSink) Write(_ context.Context, policyValidations []domain.PolicyValidation) error {
-	for i := range policyValidations {
-		PolicyValidation := policyValidations[i]
-		f.PolicyValidationChan <- PolicyValidation
+func (f *FileSystemSink) write(result domain.PolicyValidation) {
+	err := f.writeResult(result)
+	if err != nil {
+		logger.Errorw(
+			fmt.Sprintf("error while writing %s results", result.Type),
+			"error", err,
+			"policy-id", result.Policy.ID,
+			"entity-name", result.Entity.Name,
+			"entity-type", result.Entity.Kind,
+			"status", result.Status,
+		)
+		metrics.SinkWriteFailures.WithLabelValues(f.name).Inc()
+	}
+}
+
+// writeResult writes a result to the file, the file is rotated before the result is written when needed
+func (f *FileSystemSink) writeResult(result domain.PolicyValidation) error {
+	line, err := encode(f.format, result)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return fmt.Errorf("failed to write result to file %s: file is closed", f.path)
+	}
+	if f.shouldRotate(len(line)) {
+		rotateErr := f.rotate()
+		if rotateErr != nil {
+			logger.Errorw("failed to rotate validation results file", "file", f.path, "error", rotateErr)
+		}
+	}
+	return f.writeLine(line)
+}
+
+func (f *FileSystemSink) writeLine(line []byte) error {
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write result to file: %w", err)
+	}
+	return nil
+}
+
+// open opens the results file, the csv header is written to the empty files
+func (f *FileSystemSink) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s to write validation results: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open file %s to write validation results: %w", f.path, err)
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), f.getTime()
+	if f.size == 0 && f.format == FormatCSV {
+		return f.writeLine(csvHeader())
 	}
+	return nil
+}
 
+// close commits the results to disk and closes the file
+func (f *FileSystemSink) close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.closeFile()
+}
+
+// closeFile commits the results to disk and closes the file, the results are not written until the file is opened again
+func (f *FileSystemSink) closeFile() error {
+	file := f.file
+	f.file = nil
+	defer file.Close()
+	err := file.Sync()
+	if err != nil {
+		logger.Errorw("failed to write all validations results to file", "file", f.path, "error", err)
+		return fmt.Errorf("failed to write all validations results to file: %w", err)
+	}
 	return nil
 }
 
-// Stop stops file writer worker and commits all results to disk
-func (f *FileSystemSink) Stop() error {
-	defer f.File.Close()
+// shouldRotate checks if the file is rotated before writing a line of the given size, a file is not rotated
+// before its first result is written
+func (f *FileSystemSink) shouldRotate(lineSize int) bool {
+	if f.size == 0 || (f.format == FormatCSV && f.size == int64(len(csvHeader()))) {
+		return false
+	}
+	if f.rotation.MaxSizeMB > 0 && f.size+int64(lineSize) > f.rotation.MaxSizeMB*1024*1024 {
+		return true
+	}
+	return f.rotation.Interval > 0 && f.getTime().Sub(f.openedAt) >= f.rotation.Interval
+}
 
-	f.cancelWorker()
-	err := f.File.Sync()
+// rotate renames the file with the rotation time, compresses it and removes the expired rotated files
+func (f *FileSystemSink) rotate() error {
+	now := f.getTime()
+	err := f.closeFile()
+	if err != nil {
+		return err
+	}
+	rotatedPath := f.rotatedPath(now)
+	err = os.Rename(f.path, rotatedPath)
+	// the results are written to the file even if it was not renamed
+	openErr := f.open()
+	if err != nil {
+		return fmt.Errorf("failed to rename file %s: %w", f.path, err)
+	}
+	if openErr != nil {
+		return openErr
+	}
+	logger.Infow("rotated validation results file", "file", f.path, "rotated", rotatedPath)
+
+	if f.rotation.Compress {
+		err = compress(rotatedPath)
+		if err != nil {
+			return err
+		}
+	}
+	return f.removeExpired(now)
+}
+
+// rotatedPath returns the path of the file rotated at the given time, e.g. /logs/audit-20230901T120000.000.txt
+func (f *FileSystemSink) rotatedPath(rotatedAt time.Time) string {
+	ext := filepath.Ext(f.path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), rotatedAt.UTC().Format(rotatedTimeLayout), ext)
+}
+
+type rotatedFile struct {
+	path      string
+	rotatedAt time.Time
+}
+
+// rotatedFiles returns the rotated files of the results file sorted from the newest
+func (f *FileSystemSink) rotatedFiles() ([]rotatedFile, error) {
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(f.path, ext) + "-"
+	paths, err := filepath.Glob(prefix + "*")
 	if err != nil {
-		msg := fmt.Sprintf("failed to write all validations results to file, %s", err)
-		logger.Error(msg)
-		return fmt.Errorf(msg)
+		return nil, err
+	}
+	var files []rotatedFile
+	for _, path := range paths {
+		timestamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(path, prefix), ".gz"), ext)
+		rotatedAt, err := time.Parse(rotatedTimeLayout, timestamp)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path: path, rotatedAt: rotatedAt})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].rotatedAt.After(files[j].rotatedAt)
+	})
+	return files, nil
+}
+
+// removeExpired removes the rotated files exceeding the max backups or older than the max age
+func (f *FileSystemSink) removeExpired(now time.Time) error {
+	if f.rotation.MaxBackups <= 0 && f.rotation.MaxAge <= 0 {
+		return nil
+	}
+	files, err := f.rotatedFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list rotated files: %w", err)
+	}
+	for i, file := range files {
+		tooMany := f.rotation.MaxBackups > 0 && i >= f.rotation.MaxBackups
+		tooOld := f.rotation.MaxAge > 0 && now.Sub(file.rotatedAt) > f.rotation.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		err := os.Remove(file.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove rotated file %s: %w", file.path, err)
+		}
 	}
 	return nil
 }
+
+// compress replaces the file by its gzip compressed file
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open rotated file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed file: %w", err)
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return fmt.Errorf("failed to compress rotated file: %w", err)
+	}
+	return os.Remove(path)
+}
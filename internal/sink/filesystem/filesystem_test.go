@@ -0,0 +1,203 @@
+package filesystem
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+func newResult(id string) domain.PolicyValidation {
+	return domain.PolicyValidation{
+		ID:        id,
+		AccountID: "account-id",
+		ClusterID: "cluster-id",
+		Policy: domain.Policy{
+			ID:       "weave.policies.containers-minimum-replica-count",
+			Name:     "Containers Minimum Replica Count",
+			Category: "weave.categories.reliability",
+			Severity: "medium",
+		},
+		Entity: domain.Entity{
+			Name:      "app",
+			Kind:      "Deployment",
+			Namespace: "default",
+		},
+		Status:    domain.PolicyValidationStatusViolating,
+		Message:   "replicas, is 1",
+		Type:      "Audit",
+		CreatedAt: time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	require.Nil(t, err)
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func runSink(t *testing.T, config Config, results ...domain.PolicyValidation) {
+	sink, err := NewFileSystemSink(SinkType, config)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go sink.Start(ctx)
+	require.Nil(t, sink.Write(ctx, results))
+	cancel()
+	<-sink.done
+}
+
+func TestFileSystemSink_formats(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+
+	runSink(t, Config{Directory: dir, FileName: "results.jsonl"}, newResult("1"))
+	var result domain.PolicyValidation
+	lines := readLines(t, filepath.Join(dir, "results.jsonl"))
+	assert.Len(lines, 1)
+	assert.Nil(json.Unmarshal([]byte(lines[0]), &result))
+	assert.Equal("1", result.ID)
+	assert.Equal("Containers Minimum Replica Count", result.Policy.Name)
+
+	runSink(t, Config{Directory: dir, FileName: "results.json", Format: FormatResult}, newResult("1"))
+	var flattened Result
+	lines = readLines(t, filepath.Join(dir, "results.json"))
+	assert.Len(lines, 1)
+	assert.Nil(json.Unmarshal([]byte(lines[0]), &flattened))
+	assert.Equal("weave.policies.containers-minimum-replica-count", flattened.PolicyID)
+	assert.Equal(kubernetespProvider, flattened.Provider)
+	assert.Equal("Deployment", flattened.EntityType)
+	assert.Equal("medium", flattened.Severity)
+
+	// the header is only written to the new file
+	runSink(t, Config{Directory: dir, FileName: "results.csv", Format: FormatCSV}, newResult("1"))
+	runSink(t, Config{Directory: dir, FileName: "results.csv", Format: FormatCSV}, newResult("2"))
+	file, err := os.Open(filepath.Join(dir, "results.csv"))
+	assert.Nil(err)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	assert.Nil(err)
+	assert.Len(records, 3)
+	assert.Equal(csvColumns, records[0])
+	assert.Equal("1", records[1][0])
+	assert.Equal("replicas, is 1", records[1][11])
+	assert.Equal("2023-09-01T12:00:00Z", records[2][10])
+}
+
+func TestFileSystemSink_rotation(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+	sink, err := NewFileSystemSink(SinkType, Config{
+		Directory: dir,
+		FileName:  "audit.txt",
+		Rotation: RotationConfig{
+			Interval:   time.Hour,
+			Compress:   true,
+			MaxBackups: 2,
+		},
+	})
+	assert.Nil(err)
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	sink.getTime = func() time.Time { return now }
+	sink.openedAt = now
+
+	for i := 0; i < 4; i++ {
+		sink.write(newResult("result"))
+		now = now.Add(time.Hour)
+	}
+	assert.Nil(sink.close())
+
+	files, err := sink.rotatedFiles()
+	assert.Nil(err)
+	assert.Len(files, 2)
+	assert.Equal(filepath.Join(dir, "audit-20230901T030000.000.txt.gz"), files[0].path)
+	assert.Equal(filepath.Join(dir, "audit-20230901T020000.000.txt.gz"), files[1].path)
+	assert.Len(readLines(t, filepath.Join(dir, "audit.txt")), 1)
+
+	file, err := os.Open(files[0].path)
+	assert.Nil(err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	assert.Nil(err)
+	var result domain.PolicyValidation
+	assert.Nil(json.NewDecoder(gz).Decode(&result))
+	assert.Equal("result", result.ID)
+}
+
+func TestFileSystemSink_shouldRotate(t *testing.T) {
+	sink, err := NewFileSystemSink(SinkType, Config{
+		Directory: t.TempDir(),
+		FileName:  "audit.csv",
+		Format:    FormatCSV,
+		Rotation:  RotationConfig{MaxSizeMB: 1},
+	})
+	require.Nil(t, err)
+
+	// a file with only the header is not rotated
+	require.False(t, sink.shouldRotate(2*1024*1024))
+	sink.size = 1024 * 1024
+	require.True(t, sink.shouldRotate(1))
+	sink.size = 1024
+	require.False(t, sink.shouldRotate(1))
+}
+
+func TestFileSystemSink_stop(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+	sink, err := NewFileSystemSink(SinkType, Config{Directory: dir, FileName: "audit.txt"})
+	assert.Nil(err)
+
+	// the results written before the worker starts are written when it stops
+	assert.Nil(sink.Write(context.Background(), []domain.PolicyValidation{newResult("1"), newResult("2")}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(sink.Start(ctx))
+	assert.Len(readLines(t, filepath.Join(dir, "audit.txt")), 2)
+
+	// stopping a sink that was never started doesn't wait for the worker
+	sink, err = NewFileSystemSink(SinkType, Config{Directory: dir, FileName: "other.txt"})
+	assert.Nil(err)
+	sink.Stop()
+	assert.Nil(sink.file, "file should be closed")
+}
+
+func TestNewFileSystemSink(t *testing.T) {
+	_, err := NewFileSystemSink(SinkType, Config{Directory: t.TempDir()})
+	require.Error(t, err)
+
+	_, err = NewFileSystemSink(SinkType, Config{Directory: t.TempDir(), FileName: "audit.txt", Format: "xml"})
+	require.Error(t, err)
+}
+
+func TestFileSystemSink_Deliver(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+	sink, err := NewFileSystemSink(SinkType, Config{Directory: dir, FileName: "results.jsonl"})
+	assert.Nil(err)
+
+	results := []domain.PolicyValidation{newResult("1"), newResult("2")}
+	undelivered, err := sink.Deliver(context.Background(), results)
+	assert.Nil(err)
+	assert.Empty(undelivered)
+	assert.Len(readLines(t, filepath.Join(dir, "results.jsonl")), 2)
+
+	// the results are not written once the file is closed
+	assert.Nil(sink.close())
+	undelivered, err = sink.Deliver(context.Background(), results)
+	assert.Error(err)
+	assert.Equal(results, undelivered)
+	assert.Nil(sink.close())
+}
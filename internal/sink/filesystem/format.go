@@ -0,0 +1,95 @@
+package filesystem
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"time"
+
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+const (
+	// FormatJSON writes the results as JSON lines of github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidation
+	FormatJSON = "json"
+	// FormatResult writes the results as JSON lines of the flattened Result
+	FormatResult = "result"
+	// FormatCSV writes the results as CSV rows of the flattened Result fields, except the info
+	FormatCSV = "csv"
+)
+
+var csvColumns = []string{
+	"id", "account_id", "cluster_id", "policy_id", "status", "type", "provider", "entity_name",
+	"entity_type", "entity_namespace", "created_at", "message", "category_id", "severity",
+}
+
+// NewResult flattens a validation result
+func NewResult(result domain.PolicyValidation) Result {
+	provider := kubernetespProvider
+	if result.Type == tfAdmissionType {
+		provider = terraformProvider
+	}
+	return Result{
+		ID:              result.ID,
+		AccountID:       result.AccountID,
+		ClusterID:       result.ClusterID,
+		PolicyID:        result.Policy.ID,
+		Status:          result.Status,
+		Type:            result.Type,
+		Provider:        provider,
+		EntityName:      result.Entity.Name,
+		EntityType:      result.Entity.Kind,
+		EntityNamespace: result.Entity.Namespace,
+		CreatedAt:       result.CreatedAt,
+		Message:         result.Message,
+		Info: map[string]interface{}{
+			"policy_name": result.Policy.Name,
+			"trigger":     result.Trigger,
+			"enforced":    result.Enforced,
+			"occurrences": result.Occurrences,
+		},
+		CategoryID: result.Policy.Category,
+		Severity:   result.Policy.Severity,
+	}
+}
+
+// encode returns the line of a result in the given format
+func encode(format string, result domain.PolicyValidation) ([]byte, error) {
+	switch format {
+	case FormatResult:
+		return jsonLine(NewResult(result))
+	case FormatCSV:
+		r := NewResult(result)
+		return csvLine([]string{
+			r.ID, r.AccountID, r.ClusterID, r.PolicyID, r.Status, r.Type, r.Provider, r.EntityName,
+			r.EntityType, r.EntityNamespace, r.CreatedAt.Format(time.RFC3339), r.Message, r.CategoryID, r.Severity,
+		})
+	default:
+		return jsonLine(result)
+	}
+}
+
+func csvHeader() []byte {
+	header, _ := csvLine(csvColumns)
+	return header
+}
+
+func jsonLine(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func csvLine(record []string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	err := w.Write(record)
+	if err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
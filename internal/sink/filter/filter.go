@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// severities are the policy severities ordered by their impact
+var severities = map[string]int{
+	"low":    1,
+	"medium": 2,
+	"high":   3,
+}
+
+// Namespaces are the namespaces of the written results, the values are glob patterns, e.g. kube-*
+type Namespaces struct {
+	// Include are the namespaces of the written results, all namespaces are included if not set
+	Include []string
+	// Exclude are the namespaces of the results that are not written, takes precedence over Include
+	Exclude []string
+}
+
+// Config is the filter of the results written to a sink, a result is written when it matches
+// all the set fields, and a field matches when the result matches any of its values
+type Config struct {
+	// Statuses are the written result statuses, Violation, Compliance or Resolved
+	Statuses []string
+	// MinSeverity is the minimum severity of the policies of the written results, low, medium or high
+	MinSeverity string
+	Categories  []string
+	// Tags are the written policy tags, a result is written if its policy has any of the tags
+	Tags       []string
+	PolicyIDs  []string
+	Namespaces Namespaces
+}
+
+// FilteredSink writes to a sink only the results matching its filter
+type FilteredSink struct {
+	sink        domain.PolicyValidationSink
+	config      Config
+	minSeverity int
+}
+
+// NewFilteredSink returns a sink writing the results matching the filter to the given sink
+func NewFilteredSink(sink domain.PolicyValidationSink, config Config) (*FilteredSink, error) {
+	for _, status := range config.Statuses {
+		if status != domain.PolicyValidationStatusViolating &&
+			status != domain.PolicyValidationStatusCompliant &&
+			status != domain.PolicyValidationStatusResolved &&
+			status != domain.PolicyValidationStatusMutated {
+			return nil, fmt.Errorf(
+				"invalid filter status %s, should be one of: %s, %s, %s, %s", status,
+				domain.PolicyValidationStatusViolating, domain.PolicyValidationStatusCompliant,
+				domain.PolicyValidationStatusResolved, domain.PolicyValidationStatusMutated,
+			)
+		}
+	}
+	var minSeverity int
+	if config.MinSeverity != "" {
+		var ok bool
+		minSeverity, ok = severities[strings.ToLower(config.MinSeverity)]
+		if !ok {
+			return nil, fmt.Errorf("invalid filter severity %s, should be one of: low, medium, high", config.MinSeverity)
+		}
+	}
+	for _, pattern := range append(config.Namespaces.Include, config.Namespaces.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter namespace pattern %s: %w", pattern, err)
+		}
+	}
+	return &FilteredSink{
+		sink:        sink,
+		config:      config,
+		minSeverity: minSeverity,
+	}, nil
+}
+
+// Sink returns the filtered sink
+func (f *FilteredSink) Sink() domain.PolicyValidationSink {
+	return f.sink
+}
+
+// Config returns the filter of the sink
+func (f *FilteredSink) Config() Config {
+	return f.config
+}
+
+// Write writes the matching results to the sink, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (f *FilteredSink) Write(ctx context.Context, results []domain.PolicyValidation) error {
+	var matching []domain.PolicyValidation
+	for _, result := range results {
+		if f.Match(result) {
+			matching = append(matching, result)
+		}
+	}
+	if len(matching) == 0 {
+		return nil
+	}
+	return f.sink.Write(ctx, matching)
+}
+
+// Match checks if the result matches the filter
+func (f *FilteredSink) Match(result domain.PolicyValidation) bool {
+	if len(f.config.Statuses) > 0 && !contains(f.config.Statuses, result.Status) {
+		return false
+	}
+	if f.minSeverity > 0 && severities[strings.ToLower(result.Policy.Severity)] < f.minSeverity {
+		return false
+	}
+	if len(f.config.Categories) > 0 && !contains(f.config.Categories, result.Policy.Category) {
+		return false
+	}
+	if len(f.config.Tags) > 0 && !containsAny(f.config.Tags, result.Policy.Tags) {
+		return false
+	}
+	if len(f.config.PolicyIDs) > 0 && !contains(f.config.PolicyIDs, result.Policy.ID) {
+		return false
+	}
+	namespace := result.Entity.Namespace
+	if len(f.config.Namespaces.Include) > 0 && !matchAny(f.config.Namespaces.Include, namespace) {
+		return false
+	}
+	if matchAny(f.config.Namespaces.Exclude, namespace) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, others []string) bool {
+	for _, other := range others {
+		if contains(values, other) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+type fakeSink struct {
+	results []domain.PolicyValidation
+}
+
+func (f *fakeSink) Write(_ context.Context, results []domain.PolicyValidation) error {
+	f.results = append(f.results, results...)
+	return nil
+}
+
+func newResult(status, severity, namespace string) domain.PolicyValidation {
+	return domain.PolicyValidation{
+		Status: status,
+		Policy: domain.Policy{
+			ID:       "weave.policies.containers-minimum-replica-count",
+			Category: "weave.categories.reliability",
+			Tags:     []string{"soc2", "pci-dss"},
+			Severity: severity,
+		},
+		Entity: domain.Entity{Namespace: namespace},
+	}
+}
+
+func TestFilteredSink_Match(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		result domain.PolicyValidation
+		match  bool
+	}{
+		{
+			name:   "empty filter",
+			result: newResult(domain.PolicyValidationStatusCompliant, "low", "default"),
+			match:  true,
+		},
+		{
+			name:   "status",
+			config: Config{Statuses: []string{domain.PolicyValidationStatusViolating}},
+			result: newResult(domain.PolicyValidationStatusCompliant, "high", "default"),
+			match:  false,
+		},
+		{
+			name:   "severity above minimum",
+			config: Config{MinSeverity: "medium"},
+			result: newResult(domain.PolicyValidationStatusViolating, "high", "default"),
+			match:  true,
+		},
+		{
+			name:   "severity below minimum",
+			config: Config{MinSeverity: "High"},
+			result: newResult(domain.PolicyValidationStatusViolating, "medium", "default"),
+			match:  false,
+		},
+		{
+			name:   "category",
+			config: Config{Categories: []string{"weave.categories.security"}},
+			result: newResult(domain.PolicyValidationStatusViolating, "high", "default"),
+			match:  false,
+		},
+		{
+			name:   "any tag",
+			config: Config{Tags: []string{"mitre-attack", "soc2"}},
+			result: newResult(domain.PolicyValidationStatusViolating, "high", "default"),
+			match:  true,
+		},
+		{
+			name:   "policy id",
+			config: Config{PolicyIDs: []string{"weave.policies.containers-running-in-privileged-mode"}},
+			result: newResult(domain.PolicyValidationStatusViolating, "high", "default"),
+			match:  false,
+		},
+		{
+			name:   "included namespace pattern",
+			config: Config{Namespaces: Namespaces{Include: []string{"team-*"}}},
+			result: newResult(domain.PolicyValidationStatusViolating, "high", "team-a"),
+			match:  true,
+		},
+		{
+			name:   "namespace not included",
+			config: Config{Namespaces: Namespaces{Include: []string{"team-*"}}},
+			result: newResult(domain.PolicyValidationStatusViolating, "high", "default"),
+			match:  false,
+		},
+		{
+			name:   "excluded namespace",
+			config: Config{Namespaces: Namespaces{Include: []string{"team-*"}, Exclude: []string{"team-a"}}},
+			result: newResult(domain.PolicyValidationStatusViolating, "high", "team-a"),
+			match:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilteredSink(&fakeSink{}, tt.config)
+			require.Nil(t, err)
+			require.Equal(t, tt.match, f.Match(tt.result))
+		})
+	}
+}
+
+func TestFilteredSink_Write(t *testing.T) {
+	assert := require.New(t)
+	sink := &fakeSink{}
+	f, err := NewFilteredSink(sink, Config{
+		Statuses:    []string{domain.PolicyValidationStatusViolating},
+		MinSeverity: "high",
+	})
+	assert.Nil(err)
+
+	err = f.Write(context.Background(), []domain.PolicyValidation{
+		newResult(domain.PolicyValidationStatusViolating, "high", "default"),
+		newResult(domain.PolicyValidationStatusViolating, "low", "default"),
+		newResult(domain.PolicyValidationStatusCompliant, "high", "default"),
+	})
+	assert.Nil(err)
+	assert.Len(sink.results, 1)
+	assert.Equal("high", sink.results[0].Policy.Severity)
+	assert.Equal(domain.PolicyValidationStatusViolating, sink.results[0].Status)
+	assert.Equal(sink, f.Sink())
+}
+
+func TestNewFilteredSink(t *testing.T) {
+	_, err := NewFilteredSink(&fakeSink{}, Config{Statuses: []string{"Failed"}})
+	require.Error(t, err)
+
+	_, err = NewFilteredSink(&fakeSink{}, Config{MinSeverity: "critical"})
+	require.Error(t, err)
+
+	_, err = NewFilteredSink(&fakeSink{}, Config{Namespaces: Namespaces{Exclude: []string{"team-["}}})
+	require.Error(t, err)
+}
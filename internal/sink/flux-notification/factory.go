@@ -0,0 +1,31 @@
+package flux_notification
+
+import (
+	"context"
+
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// SinkType is the type of the flux notification sink in the sinks configuration
+const SinkType = "flux_notification"
+
+// Config configures the flux notification sink
+type Config struct {
+	// Address is the address of the flux notification controller
+	Address string
+}
+
+func init() {
+	registry.Register(SinkType, newSink)
+}
+
+func newSink(_ context.Context, deps registry.Dependencies, config registry.Config) (domain.PolicyValidationSink, error) {
+	var sinkConfig Config
+	err := config.Decode(&sinkConfig)
+	if err != nil {
+		return nil, err
+	}
+	recorder := deps.Manager.GetEventRecorderFor(deps.ReportingController)
+	return NewFluxNotificationSink(deps.Name, recorder, sinkConfig.Address, deps.ReportingController, deps.AccountID, deps.ClusterID)
+}
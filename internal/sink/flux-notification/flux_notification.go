@@ -1,34 +1,73 @@
 package flux_notification
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
 
+	eventv1 "github.com/fluxcd/pkg/apis/event/v1beta1"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/internal/utils"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/tools/record"
 )
 
 const (
 	resultChanSize int = 50
+	postTimeout        = 5 * time.Second
 )
 
 type FluxNotificationSink struct {
-	recorder     record.EventRecorder
-	resultChan   chan domain.PolicyValidation
-	cancelWorker context.CancelFunc
-	accountID    string
-	clusterID    string
+	name                string
+	recorder            record.EventRecorder
+	webhook             string
+	client              *retryablehttp.Client
+	reportingController string
+	reportingInstance   string
+	resultChan          chan domain.PolicyValidation
+	cancelWorker        context.CancelFunc
+	accountID           string
+	clusterID           string
 }
 
-// NewFluxNotificationSink returns a sink that sends results to flux notification controller
-func NewFluxNotificationSink(recorder record.EventRecorder, webhook, accountID, clusterID string) (*FluxNotificationSink, error) {
+// NewFluxNotificationSink returns a sink that records results as kubernetes events of their flux objects and posts
+// them to the flux notification controller webhook, the events are only recorded if the webhook is not set
+func NewFluxNotificationSink(name string, recorder record.EventRecorder, webhook, reportingController, accountID, clusterID string) (*FluxNotificationSink, error) {
+	if webhook != "" {
+		_, err := url.Parse(webhook)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flux notification controller address: %w", err)
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	client := retryablehttp.NewClient()
+	client.HTTPClient.Timeout = postTimeout
+	client.CheckRetry = checkRetry
+	client.Logger = nil
+
 	return &FluxNotificationSink{
-		recorder:   recorder,
-		resultChan: make(chan domain.PolicyValidation, resultChanSize),
-		accountID:  accountID,
-		clusterID:  clusterID,
+		name:                name,
+		recorder:            recorder,
+		webhook:             webhook,
+		client:              client,
+		reportingController: reportingController,
+		reportingInstance:   hostname,
+		resultChan:          make(chan domain.PolicyValidation, resultChanSize),
+		accountID:           accountID,
+		clusterID:           clusterID,
 	}, nil
 }
 
@@ -41,6 +80,10 @@ func (f *FluxNotificationSink) Start(ctx context.Context) error {
 
 // Stop stops worker
 func (f *FluxNotificationSink) Stop() {
+	// the worker is not started when the agent fails to start
+	if f.cancelWorker == nil {
+		return
+	}
 	f.cancelWorker()
 }
 
@@ -53,11 +96,26 @@ func (f *FluxNotificationSink) Write(_ context.Context, results []domain.PolicyV
 	return nil
 }
 
+// Deliver sends the results and returns the results that failed to be posted to the notification controller,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Deliverer
+func (f *FluxNotificationSink) Deliver(ctx context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	var undelivered []domain.PolicyValidation
+	var err error
+	for _, result := range results {
+		sendErr := f.send(ctx, result)
+		if sendErr != nil {
+			undelivered = append(undelivered, result)
+			err = sendErr
+		}
+	}
+	return undelivered, err
+}
+
 func (f *FluxNotificationSink) writeWorker(ctx context.Context) error {
 	for {
 		select {
 		case result := <-f.resultChan:
-			f.write(result)
+			f.write(ctx, result)
 		case <-ctx.Done():
 			logger.Info("stopping write worker ...")
 			return nil
@@ -65,7 +123,17 @@ func (f *FluxNotificationSink) writeWorker(ctx context.Context) error {
 	}
 }
 
-func (f *FluxNotificationSink) write(result domain.PolicyValidation) {
+func (f *FluxNotificationSink) write(ctx context.Context, result domain.PolicyValidation) {
+	err := f.send(ctx, result)
+	if err != nil {
+		logger.Errorw("failed to send flux notification", "webhook", f.webhook, "policy", result.Policy.ID, "error", err)
+		metrics.SinkWriteFailures.WithLabelValues(f.name).Inc()
+	}
+}
+
+// send records the event of a result and posts it to the notification controller, the results of entities not
+// managed by flux and the results that can't be converted to an event are discarded
+func (f *FluxNotificationSink) send(ctx context.Context, result domain.PolicyValidation) error {
 	fluxObject := utils.GetFluxObject(result.Entity.Labels)
 	if fluxObject == nil {
 		logger.Debugw(
@@ -74,7 +142,7 @@ func (f *FluxNotificationSink) write(result domain.PolicyValidation) {
 			"name", result.Entity.Name,
 			"namespace", result.Entity.Namespace,
 		)
-		return
+		return nil
 	}
 
 	event, err := domain.NewK8sEventFromPolicyValidation(result)
@@ -88,7 +156,7 @@ func (f *FluxNotificationSink) write(result domain.PolicyValidation) {
 			"entity_namespace", result.Entity.Namespace,
 			"policy", result.Policy.ID,
 		)
-		return
+		return nil
 	}
 
 	logger.Debugw(
@@ -107,4 +175,59 @@ func (f *FluxNotificationSink) write(result domain.PolicyValidation) {
 		event.Reason,
 		event.Message,
 	)
+	return f.post(ctx, fluxObject, event)
+}
+
+// checkRetry retries the requests failed with a connection error or a 500-range response like the flux event recorder,
+// the rate limited events are not retried
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return false, nil
+	}
+	return retryablehttp.ErrorPropagatedRetryPolicy(ctx, resp, err)
+}
+
+// post posts the event of a flux object to the notification controller webhook
+func (f *FluxNotificationSink) post(ctx context.Context, fluxObject *unstructured.Unstructured, event *v1.Event) error {
+	if f.webhook == "" {
+		return nil
+	}
+	severity := eventv1.EventSeverityInfo
+	if event.Type == v1.EventTypeWarning {
+		severity = eventv1.EventSeverityError
+	}
+	body, err := json.Marshal(eventv1.Event{
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: fluxObject.GetAPIVersion(),
+			Kind:       fluxObject.GetKind(),
+			Name:       fluxObject.GetName(),
+			Namespace:  fluxObject.GetNamespace(),
+		},
+		Severity:            severity,
+		Timestamp:           metav1.Now(),
+		Message:             event.Message,
+		Reason:              event.Reason,
+		Metadata:            event.Annotations,
+		ReportingController: f.reportingController,
+		ReportingInstance:   f.reportingInstance,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode flux notification event: %w", err)
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPost, f.webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create flux notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post flux notification event: %w", err)
+	}
+	defer resp.Body.Close()
+	// the notification controller rate limits the duplicate events, they are not failures
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("flux notification controller responded with status %d", resp.StatusCode)
+	}
+	return nil
 }
@@ -2,10 +2,16 @@ package flux_notification
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	eventv1 "github.com/fluxcd/pkg/apis/event/v1beta1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/uuid-go"
 	v1 "k8s.io/api/core/v1"
@@ -82,7 +88,7 @@ func TestFluxNotificationSink(t *testing.T) {
 	}
 
 	recorder := record.NewFakeRecorder(10)
-	sink, err := NewFluxNotificationSink(recorder, "", "", "")
+	sink, err := NewFluxNotificationSink(SinkType, recorder, "", "policy-agent", "", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -99,3 +105,61 @@ func TestFluxNotificationSink(t *testing.T) {
 
 	assert.Equal(t, len(recorder.Events), 2)
 }
+
+func TestFluxNotificationSink_Deliver(t *testing.T) {
+	var events []eventv1.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event eventv1.Event
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&event))
+		if event.InvolvedObject.Name == "rejected" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	newResult := func(helmRelease string) domain.PolicyValidation {
+		return domain.PolicyValidation{
+			ID:     uuid.NewV4().String(),
+			Policy: domain.Policy{ID: "policy", Name: "policy"},
+			Entity: domain.Entity{
+				ID:         uuid.NewV4().String(),
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       "app",
+				Namespace:  "default",
+				Labels: map[string]string{
+					"helm.toolkit.fluxcd.io/name":      helmRelease,
+					"helm.toolkit.fluxcd.io/namespace": "flux-system",
+				},
+			},
+			Status:    domain.PolicyValidationStatusViolating,
+			Type:      "Audit",
+			CreatedAt: time.Now(),
+		}
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	sink, err := NewFluxNotificationSink(SinkType, recorder, server.URL, "policy-agent", "", "")
+	assert.Nil(t, err)
+
+	delivered, rejected := newResult("app"), newResult("rejected")
+	undelivered, err := sink.Deliver(context.Background(), []domain.PolicyValidation{delivered, rejected})
+	assert.Error(t, err)
+	assert.Equal(t, []domain.PolicyValidation{rejected}, undelivered)
+	assert.Len(t, recorder.Events, 2)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "app", events[0].InvolvedObject.Name)
+	assert.Equal(t, eventv1.EventSeverityError, events[0].Severity)
+	assert.Equal(t, "policy-agent", events[0].ReportingController)
+
+	// the failed writes are counted by the configured sink name
+	failures := metrics.SinkWriteFailures.WithLabelValues("flux-alerts")
+	before := testutil.ToFloat64(failures)
+	sink, err = NewFluxNotificationSink("flux-alerts", recorder, server.URL, "policy-agent", "", "")
+	assert.Nil(t, err)
+	sink.write(context.Background(), delivered)
+	sink.write(context.Background(), rejected)
+	assert.Equal(t, before+1, testutil.ToFloat64(failures))
+}
@@ -0,0 +1,30 @@
+package k8s_event
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SinkType is the type of the kubernetes events sink in the sinks configuration
+const SinkType = "k8s_events"
+
+func init() {
+	registry.Register(SinkType, newSink)
+}
+
+func newSink(_ context.Context, deps registry.Dependencies, config registry.Config) (domain.PolicyValidationSink, error) {
+	// the sink has no configuration
+	err := config.Decode(&struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(deps.Manager.GetConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize kubernetes clientset: %w", err)
+	}
+	return NewK8sEventSink(deps.Name, clientset, deps.AccountID, deps.ClusterID, deps.ReportingController)
+}
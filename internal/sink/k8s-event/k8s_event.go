@@ -2,8 +2,10 @@ package k8s_event
 
 import (
 	"context"
+	"fmt"
 	"os"
 
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/internal/utils"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
@@ -18,6 +20,7 @@ const (
 )
 
 type K8sEventSink struct {
+	name                string
 	kubeClient          kubernetes.Interface
 	resultChan          chan domain.PolicyValidation
 	cancelWorker        context.CancelFunc
@@ -28,13 +31,14 @@ type K8sEventSink struct {
 }
 
 // NewK8sEventSink returns a sink that sends results to kubernetes events queue
-func NewK8sEventSink(kubeClient kubernetes.Interface, accountID, clusterID, reportingController string) (*K8sEventSink, error) {
+func NewK8sEventSink(name string, kubeClient kubernetes.Interface, accountID, clusterID, reportingController string) (*K8sEventSink, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, err
 	}
 
 	return &K8sEventSink{
+		name:                name,
 		kubeClient:          kubeClient,
 		resultChan:          make(chan domain.PolicyValidation, resultChanSize),
 		accountID:           accountID,
@@ -53,6 +57,10 @@ func (k *K8sEventSink) Start(ctx context.Context) error {
 
 // Stop stops worker
 func (k *K8sEventSink) Stop() {
+	// the worker is not started when the agent fails to start
+	if k.cancelWorker == nil {
+		return
+	}
 	k.cancelWorker()
 }
 
@@ -65,6 +73,21 @@ func (k *K8sEventSink) Write(_ context.Context, results []domain.PolicyValidatio
 	return nil
 }
 
+// Deliver creates the events of the results and returns the results whose events failed to be created,
+// implements github.com/weaveworks/policy-agent/internal/sink/buffered.Deliverer
+func (k *K8sEventSink) Deliver(ctx context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	var undelivered []domain.PolicyValidation
+	var err error
+	for _, result := range results {
+		sendErr := k.send(ctx, result)
+		if sendErr != nil {
+			undelivered = append(undelivered, result)
+			err = sendErr
+		}
+	}
+	return undelivered, err
+}
+
 func (f *K8sEventSink) writeWorker(ctx context.Context) error {
 	for {
 		select {
@@ -78,6 +101,16 @@ func (f *K8sEventSink) writeWorker(ctx context.Context) error {
 }
 
 func (k *K8sEventSink) write(ctx context.Context, result domain.PolicyValidation) {
+	err := k.send(ctx, result)
+	if err != nil {
+		logger.Errorw("failed to send event", "error", err)
+		metrics.SinkWriteFailures.WithLabelValues(k.name).Inc()
+	}
+}
+
+// send creates the event of a result, the results that can't be converted to an event are discarded
+// as their event would never be created
+func (k *K8sEventSink) send(ctx context.Context, result domain.PolicyValidation) error {
 	event, err := domain.NewK8sEventFromPolicyValidation(result)
 	if err != nil {
 		logger.Errorw(
@@ -89,7 +122,7 @@ func (k *K8sEventSink) write(ctx context.Context, result domain.PolicyValidation
 			"entity_namespace", result.Entity.Namespace,
 			"policy", result.Policy.ID,
 		)
-		return
+		return nil
 	}
 
 	fluxObject := utils.GetFluxObject(result.Entity.Labels)
@@ -121,6 +154,7 @@ func (k *K8sEventSink) write(ctx context.Context, result domain.PolicyValidation
 
 	_, err = k.kubeClient.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{})
 	if err != nil {
-		logger.Errorw("failed to send event", "error", err)
+		return fmt.Errorf("failed to create event in namespace %s: %w", event.Namespace, err)
 	}
+	return nil
 }
@@ -2,6 +2,7 @@ package k8s_event
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -11,9 +12,11 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestK8sEventSink(t *testing.T) {
@@ -128,7 +131,7 @@ func TestK8sEventSink(t *testing.T) {
 		},
 	}
 
-	sink, err := NewK8sEventSink(fake.NewSimpleClientset(), "", "", "policy-agent")
+	sink, err := NewK8sEventSink(SinkType, fake.NewSimpleClientset(), "", "", "policy-agent")
 	if err != nil {
 		t.Error(err)
 	}
@@ -193,3 +196,40 @@ func TestK8sEventSink(t *testing.T) {
 		assert.Equal(t, event.Related.Name, policyRef.Name)
 	}
 }
+
+func TestK8sEventSink_Deliver(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "unavailable" {
+			return true, nil, errors.New("api server is unavailable")
+		}
+		return false, nil, nil
+	})
+	sink, err := NewK8sEventSink(SinkType, kubeClient, "", "", "policy-agent")
+	assert.Nil(t, err)
+
+	newResult := func(namespace string) domain.PolicyValidation {
+		return domain.PolicyValidation{
+			ID:     uuid.NewV4().String(),
+			Policy: domain.Policy{ID: "policy", Name: "policy"},
+			Entity: domain.Entity{
+				ID:         uuid.NewV4().String(),
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       "app",
+				Namespace:  namespace,
+			},
+			Status:    domain.PolicyValidationStatusViolating,
+			Type:      "Audit",
+			CreatedAt: time.Now(),
+		}
+	}
+	delivered, failed := newResult("default"), newResult("unavailable")
+	undelivered, err := sink.Deliver(context.Background(), []domain.PolicyValidation{delivered, failed})
+	assert.Error(t, err)
+	assert.Equal(t, []domain.PolicyValidation{failed}, undelivered)
+
+	events, err := kubeClient.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, events.Items, 1)
+}
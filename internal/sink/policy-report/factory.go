@@ -0,0 +1,32 @@
+package policy_report
+
+import (
+	"context"
+	"time"
+
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// SinkType is the type of the policy report sink in the sinks configuration
+const SinkType = "policy_report"
+
+// Config configures the policy report sink
+type Config struct {
+	// Name of the PolicyReport and ClusterPolicyReport resources
+	Name          string
+	FlushInterval time.Duration
+}
+
+func init() {
+	registry.Register(SinkType, newSink)
+}
+
+func newSink(_ context.Context, deps registry.Dependencies, config registry.Config) (domain.PolicyValidationSink, error) {
+	var sinkConfig Config
+	err := config.Decode(&sinkConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewPolicyReportSink(deps.Name, deps.DynamicClient, sinkConfig.Name, sinkConfig.FlushInterval), nil
+}
@@ -0,0 +1,439 @@
+package policy_report
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	resultChanSize       int = 50
+	resultSource             = "weave-policy-agent"
+	managedByLabel           = "app.kubernetes.io/managed-by"
+	DefaultReportName        = "policy-agent"
+	DefaultFlushInterval     = 10 * time.Second
+	stopFlushTimeout         = 10 * time.Second
+)
+
+// pruneRequest prunes the results of the kinds audited by a complete audit that are older than the audit
+type pruneRequest struct {
+	kinds         []string
+	excludedKinds []string
+	before        time.Time
+}
+
+// report holds the results of a namespace report, the cluster report has no namespace
+type report struct {
+	results map[string]PolicyReportResult
+	// loaded is set once the results of the existing report are merged
+	loaded bool
+	dirty  bool
+}
+
+// PolicyReportSink keeps a wg-policy PolicyReport per namespace and a ClusterPolicyReport
+// for the cluster scoped entities updated with the latest result of each policy and entity
+type PolicyReportSink struct {
+	// sinkName is the configured name of the sink, name is the name of the reports
+	sinkName      string
+	client        dynamic.Interface
+	name          string
+	flushInterval time.Duration
+	resultChan    chan domain.PolicyValidation
+	pruneChan     chan pruneRequest
+	cancelWorker  context.CancelFunc
+	reports       map[string]*report
+}
+
+// NewPolicyReportSink returns a sink that writes results to policy reports with the given report name
+func NewPolicyReportSink(sinkName string, client dynamic.Interface, name string, flushInterval time.Duration) *PolicyReportSink {
+	if name == "" {
+		name = DefaultReportName
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	return &PolicyReportSink{
+		sinkName:      sinkName,
+		client:        client,
+		name:          name,
+		flushInterval: flushInterval,
+		resultChan:    make(chan domain.PolicyValidation, resultChanSize),
+		pruneChan:     make(chan pruneRequest),
+		reports:       make(map[string]*report),
+	}
+}
+
+// Start starts the writer worker
+func (p *PolicyReportSink) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancelWorker = cancel
+	return p.writeWorker(ctx)
+}
+
+// Stop stops worker
+func (p *PolicyReportSink) Stop() {
+	// the worker is not started when the agent fails to start
+	if p.cancelWorker == nil {
+		return
+	}
+	p.cancelWorker()
+}
+
+// Write adds results to buffer, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (p *PolicyReportSink) Write(_ context.Context, results []domain.PolicyValidation) error {
+	logger.Debugw("writing validation results", "sink", "policy_report", "count", len(results))
+	for _, result := range results {
+		p.resultChan <- result
+	}
+	return nil
+}
+
+// PruneResults removes the results of the audited kinds that were not validated since the start of a complete audit,
+// their entities or policies were deleted, their entities are no longer audited or their violations were fixed,
+// implements github.com/weaveworks/policy-agent/internal/auditor.ResultsPruner
+func (p *PolicyReportSink) PruneResults(ctx context.Context, kinds, excludedKinds []string, before time.Time) error {
+	select {
+	case p.pruneChan <- pruneRequest{kinds: kinds, excludedKinds: excludedKinds, before: before}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *PolicyReportSink) writeWorker(ctx context.Context) error {
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-p.resultChan:
+			p.add(result)
+		case request := <-p.pruneChan:
+			p.prune(ctx, request)
+		case <-ticker.C:
+			p.flush(ctx)
+		case <-ctx.Done():
+			logger.Info("stopping write worker ...")
+			p.flushPending()
+			return nil
+		}
+	}
+}
+
+// flushPending writes the received results when the worker stops
+func (p *PolicyReportSink) flushPending() {
+	for len(p.resultChan) > 0 {
+		p.add(<-p.resultChan)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stopFlushTimeout)
+	defer cancel()
+	p.flush(ctx)
+}
+
+// add replaces the result of the same policy and entity in the entity namespace report
+func (p *PolicyReportSink) add(result domain.PolicyValidation) {
+	namespace := result.Entity.Namespace
+	r, ok := p.reports[namespace]
+	if !ok {
+		r = &report{results: make(map[string]PolicyReportResult)}
+		p.reports[namespace] = r
+	}
+	r.results[resultKey(result.Policy.ID, result.Entity.APIVersion, result.Entity.Kind, result.Entity.Name)] = newReportResult(result)
+	r.dirty = true
+}
+
+// prune removes the results of the audited kinds older than the audit from all the reports, the existing reports
+// are loaded first so that the results of the previous agent runs are pruned as well
+func (p *PolicyReportSink) prune(ctx context.Context, request pruneRequest) {
+	namespaces, err := p.reportNamespaces(ctx)
+	if err != nil {
+		logger.Errorw("failed to list policy reports", "name", p.name, "error", err)
+	}
+	for _, namespace := range namespaces {
+		if _, ok := p.reports[namespace]; !ok {
+			p.reports[namespace] = &report{results: make(map[string]PolicyReportResult)}
+		}
+	}
+
+	var pruned int
+	for namespace, r := range p.reports {
+		if !r.loaded {
+			err := p.load(ctx, namespace, r)
+			if err != nil {
+				// the report is pruned by the next audit
+				logger.Errorw("failed to load policy report", "namespace", namespace, "name", p.name, "error", err)
+				continue
+			}
+		}
+		for key, result := range r.results {
+			if request.stale(result) {
+				delete(r.results, key)
+				r.dirty = true
+				pruned++
+			}
+		}
+	}
+	logger.Debugw("pruned policy reports results", "name", p.name, "count", pruned)
+}
+
+// reportNamespaces returns the namespaces of the existing reports, the cluster report has no namespace
+func (p *PolicyReportSink) reportNamespaces(ctx context.Context) ([]string, error) {
+	namespaces := []string{""}
+	reports, err := p.client.Resource(PolicyReportGroupVersionResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return namespaces, err
+	}
+	for _, item := range reports.Items {
+		if item.GetName() == p.name {
+			namespaces = append(namespaces, item.GetNamespace())
+		}
+	}
+	return namespaces, nil
+}
+
+// load merges the results of the existing report
+func (p *PolicyReportSink) load(ctx context.Context, namespace string, r *report) error {
+	existing, err := p.reportClient(namespace).Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		r.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get policy report: %w", err)
+	}
+	var current PolicyReport
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(existing.Object, &current)
+	if err != nil {
+		return fmt.Errorf("failed to decode policy report: %w", err)
+	}
+	r.merge(current.Results)
+	return nil
+}
+
+// flush writes the changed reports
+func (p *PolicyReportSink) flush(ctx context.Context) {
+	for namespace, r := range p.reports {
+		if !r.dirty {
+			continue
+		}
+		err := p.writeReport(ctx, namespace, r)
+		if err != nil {
+			logger.Errorw("failed to write policy report", "namespace", namespace, "name", p.name, "error", err)
+			metrics.SinkWriteFailures.WithLabelValues(p.sinkName).Inc()
+			continue
+		}
+		r.dirty = false
+	}
+}
+
+func (p *PolicyReportSink) writeReport(ctx context.Context, namespace string, r *report) error {
+	client := p.reportClient(namespace)
+	existing, err := client.Get(ctx, p.name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get policy report: %w", err)
+	}
+
+	var current PolicyReport
+	if err == nil {
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(existing.Object, &current)
+		if err != nil {
+			return fmt.Errorf("failed to decode policy report: %w", err)
+		}
+	}
+	if !r.loaded {
+		r.merge(current.Results)
+	}
+
+	kind := PolicyReportKind
+	if namespace == "" {
+		kind = ClusterPolicyReportKind
+	}
+	current.TypeMeta = metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: kind}
+	current.Name = p.name
+	current.Namespace = namespace
+	if current.Labels == nil {
+		current.Labels = map[string]string{}
+	}
+	current.Labels[managedByLabel] = DefaultReportName
+	current.Results, current.Summary = r.list()
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&current)
+	if err != nil {
+		return fmt.Errorf("failed to encode policy report: %w", err)
+	}
+	if current.ResourceVersion == "" {
+		_, err = client.Create(ctx, &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create policy report: %w", err)
+		}
+		logger.Debugw("created policy report", "namespace", namespace, "name", p.name, "results", len(current.Results))
+		return nil
+	}
+	_, err = client.Update(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update policy report: %w", err)
+	}
+	logger.Debugw("updated policy report", "namespace", namespace, "name", p.name, "results", len(current.Results))
+	return nil
+}
+
+func (p *PolicyReportSink) reportClient(namespace string) dynamic.ResourceInterface {
+	if namespace == "" {
+		return p.client.Resource(ClusterPolicyReportGroupVersionResource)
+	}
+	return p.client.Resource(PolicyReportGroupVersionResource).Namespace(namespace)
+}
+
+// merge adds the results of the existing report, the results of the previous agent runs are kept until replaced
+// by new results or pruned by a complete audit
+func (r *report) merge(results []PolicyReportResult) {
+	for _, result := range results {
+		key := reportResultKey(result)
+		if _, ok := r.results[key]; !ok && key != "" {
+			r.results[key] = result
+		}
+	}
+	r.loaded = true
+}
+
+// stale returns true if the result is of an audited kind and older than the audit
+func (r pruneRequest) stale(result PolicyReportResult) bool {
+	timestamp := time.Unix(result.Timestamp.Seconds, int64(result.Timestamp.Nanos))
+	if !timestamp.Before(r.before) || len(result.Subjects) == 0 {
+		return false
+	}
+	kind := result.Subjects[0].Kind
+	if len(r.kinds) > 0 {
+		return contains(r.kinds, kind)
+	}
+	return !contains(r.excludedKinds, kind)
+}
+
+func contains(items []string, item string) bool {
+	for i := range items {
+		if items[i] == item {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the report results sorted by policy and resource, and their summary
+func (r *report) list() ([]PolicyReportResult, PolicyReportSummary) {
+	keys := make([]string, 0, len(r.results))
+	for key := range r.results {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var summary PolicyReportSummary
+	results := make([]PolicyReportResult, 0, len(keys))
+	for _, key := range keys {
+		result := r.results[key]
+		switch result.Result {
+		case ResultPass:
+			summary.Pass++
+		case ResultFail:
+			summary.Fail++
+		case ResultWarn:
+			summary.Warn++
+		case ResultError:
+			summary.Error++
+		case ResultSkip:
+			summary.Skip++
+		}
+		results = append(results, result)
+	}
+	return results, summary
+}
+
+// newReportResult maps a policy validation to a policy report result
+func newReportResult(result domain.PolicyValidation) PolicyReportResult {
+	status := ResultPass
+	if result.Status == domain.PolicyValidationStatusViolating {
+		status = ResultFail
+	}
+
+	severity := strings.ToLower(result.Policy.Severity)
+	if !severities[severity] {
+		severity = ""
+	}
+
+	properties := map[string]string{
+		"name":        result.Policy.Name,
+		"type":        result.Type,
+		"trigger":     result.Trigger,
+		"occurrences": strconv.Itoa(len(result.Occurrences)),
+	}
+	if result.ID != "" {
+		properties["validation-id"] = result.ID
+	}
+	if len(result.Policy.Tags) > 0 {
+		properties["tags"] = strings.Join(result.Policy.Tags, ",")
+	}
+
+	var messages, keys []string
+	for _, occurrence := range result.Occurrences {
+		messages = append(messages, occurrence.Message)
+		if occurrence.ViolatingKey != nil {
+			keys = append(keys, *occurrence.ViolatingKey)
+		}
+	}
+	if len(keys) > 0 {
+		properties["violating-keys"] = strings.Join(keys, ",")
+	}
+	message := strings.Join(messages, "; ")
+	if message == "" {
+		message = result.Message
+	}
+
+	createdAt := result.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	return PolicyReportResult{
+		Source:    resultSource,
+		Policy:    result.Policy.ID,
+		Category:  result.Policy.Category,
+		Severity:  severity,
+		Timestamp: metav1.Timestamp{Seconds: createdAt.Unix(), Nanos: int32(createdAt.Nanosecond())},
+		Result:    status,
+		Scored:    true,
+		Subjects: []v1.ObjectReference{{
+			APIVersion: result.Entity.APIVersion,
+			Kind:       result.Entity.Kind,
+			Name:       result.Entity.Name,
+			Namespace:  result.Entity.Namespace,
+			UID:        types.UID(result.Entity.ID),
+		}},
+		Message:    message,
+		Properties: properties,
+	}
+}
+
+func resultKey(policyID, apiVersion, kind, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", policyID, apiVersion, kind, name)
+}
+
+// reportResultKey returns the key of a result of an existing report, results of other sources are not keyed
+func reportResultKey(result PolicyReportResult) string {
+	if result.Source != resultSource || len(result.Subjects) == 0 {
+		return ""
+	}
+	subject := result.Subjects[0]
+	return resultKey(result.Policy, subject.APIVersion, subject.Kind, subject.Name)
+}
@@ -0,0 +1,192 @@
+package policy_report
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newValidation(policyID, status string, entity domain.Entity) domain.PolicyValidation {
+	key := "spec.replicas"
+	return domain.PolicyValidation{
+		ID: "validation-id",
+		Policy: domain.Policy{
+			ID:       policyID,
+			Name:     "Policy " + policyID,
+			Category: "weave.categories.reliability",
+			Severity: "High",
+			Tags:     []string{"soc2"},
+		},
+		Entity: entity,
+		Status: status,
+		Occurrences: []domain.Occurrence{
+			{Message: "replicas must be at least 2", ViolatingKey: &key},
+		},
+		Type:      "Audit",
+		Trigger:   "periodic-audit",
+		CreatedAt: time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func getReport(t *testing.T, client *dynamicfake.FakeDynamicClient, gvr schema.GroupVersionResource, namespace, name string) PolicyReport {
+	obj, err := client.Resource(gvr).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.Nil(t, err)
+	var report PolicyReport
+	require.Nil(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &report))
+	return report
+}
+
+func TestPolicyReportSink(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	// a report of a previous run with a result that is not replaced by new results
+	previous := PolicyReport{
+		TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: PolicyReportKind},
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultReportName, Namespace: "default", ResourceVersion: "1"},
+		Results: []PolicyReportResult{
+			newReportResult(newValidation("policy-2", domain.PolicyValidationStatusViolating,
+				domain.Entity{APIVersion: "apps/v1", Kind: "Deployment", Name: "old", Namespace: "default"})),
+		},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&previous)
+	assert.Nil(err)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			PolicyReportGroupVersionResource:        "PolicyReportList",
+			ClusterPolicyReportGroupVersionResource: "ClusterPolicyReportList",
+		},
+		&unstructured.Unstructured{Object: obj},
+	)
+
+	sink := NewPolicyReportSink(SinkType, client, "", 0)
+	deployment := domain.Entity{ID: "uid", APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "default"}
+	role := domain.Entity{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "admin"}
+	sink.add(newValidation("policy-1", domain.PolicyValidationStatusViolating, deployment))
+	sink.add(newValidation("policy-1", domain.PolicyValidationStatusViolating, role))
+	sink.flush(ctx)
+
+	report := getReport(t, client, PolicyReportGroupVersionResource, "default", DefaultReportName)
+	assert.Equal(PolicyReportSummary{Fail: 2}, report.Summary)
+	assert.Len(report.Results, 2, "results of the previous report should be kept")
+	result := report.Results[0]
+	assert.Equal("policy-1", result.Policy)
+	assert.Equal(ResultFail, result.Result)
+	assert.Equal("high", result.Severity)
+	assert.Equal("weave.categories.reliability", result.Category)
+	assert.Equal("replicas must be at least 2", result.Message)
+	assert.Equal("1", result.Properties["occurrences"])
+	assert.Equal("spec.replicas", result.Properties["violating-keys"])
+	assert.Equal("soc2", result.Properties["tags"])
+	assert.Equal("app", result.Subjects[0].Name)
+	assert.EqualValues("uid", result.Subjects[0].UID)
+	assert.Equal(int64(1672567200), result.Timestamp.Seconds)
+
+	clusterReport := getReport(t, client, ClusterPolicyReportGroupVersionResource, "", DefaultReportName)
+	assert.Equal(ClusterPolicyReportKind, clusterReport.Kind)
+	assert.Equal(PolicyReportSummary{Fail: 1}, clusterReport.Summary)
+	assert.Equal("admin", clusterReport.Results[0].Subjects[0].Name)
+
+	// compliance results replace the violations of the same policy and entity
+	sink.add(newValidation("policy-1", domain.PolicyValidationStatusCompliant, deployment))
+	sink.add(newValidation("policy-2", domain.PolicyValidationStatusCompliant,
+		domain.Entity{APIVersion: "apps/v1", Kind: "Deployment", Name: "old", Namespace: "default"}))
+	sink.flush(ctx)
+
+	report = getReport(t, client, PolicyReportGroupVersionResource, "default", DefaultReportName)
+	assert.Equal(PolicyReportSummary{Pass: 2}, report.Summary)
+	assert.Len(report.Results, 2)
+	for _, result := range report.Results {
+		assert.Equal(ResultPass, result.Result)
+	}
+}
+
+func TestPolicyReportSink_prune(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	newResult := func(kind, name, namespace string, createdAt time.Time) domain.PolicyValidation {
+		validation := newValidation("policy-1", domain.PolicyValidationStatusViolating,
+			domain.Entity{APIVersion: "v1", Kind: kind, Name: name, Namespace: namespace})
+		validation.CreatedAt = createdAt
+		return validation
+	}
+
+	// reports of a previous run, the report of the other namespace is not written again before the audit
+	var objects []runtime.Object
+	for namespace, results := range map[string][]PolicyReportResult{
+		"default": {
+			newReportResult(newResult("Service", "fixed", "default", start.Add(-time.Hour))),
+			newReportResult(newResult("Secret", "other-schedule", "default", start.Add(-time.Hour))),
+		},
+		"other": {
+			newReportResult(newResult("Service", "deleted", "other", start.Add(-time.Hour))),
+		},
+	} {
+		previous := PolicyReport{
+			TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: PolicyReportKind},
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultReportName, Namespace: namespace, ResourceVersion: "1"},
+			Results:    results,
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&previous)
+		assert.Nil(err)
+		objects = append(objects, &unstructured.Unstructured{Object: obj})
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			PolicyReportGroupVersionResource:        "PolicyReportList",
+			ClusterPolicyReportGroupVersionResource: "ClusterPolicyReportList",
+		},
+		objects...,
+	)
+
+	sink := NewPolicyReportSink(SinkType, client, "", 0)
+	sink.add(newResult("Service", "violating", "default", start.Add(time.Minute)))
+
+	sink.prune(ctx, pruneRequest{excludedKinds: []string{"Secret"}, before: start})
+	sink.flush(ctx)
+
+	report := getReport(t, client, PolicyReportGroupVersionResource, "default", DefaultReportName)
+	var names []string
+	for _, result := range report.Results {
+		names = append(names, result.Subjects[0].Name)
+	}
+	assert.ElementsMatch([]string{"violating", "other-schedule"}, names)
+
+	report = getReport(t, client, PolicyReportGroupVersionResource, "other", DefaultReportName)
+	assert.Empty(report.Results, "results of the previous runs should be pruned")
+}
+
+func TestPolicyReportSink_flushOnStop(t *testing.T) {
+	assert := require.New(t)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			PolicyReportGroupVersionResource:        "PolicyReportList",
+			ClusterPolicyReportGroupVersionResource: "ClusterPolicyReportList",
+		},
+	)
+
+	sink := NewPolicyReportSink(SinkType, client, "", time.Hour)
+	deployment := domain.Entity{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "default"}
+	assert.Nil(sink.Write(context.Background(), []domain.PolicyValidation{
+		newValidation("policy-1", domain.PolicyValidationStatusViolating, deployment),
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(sink.Start(ctx))
+
+	report := getReport(t, client, PolicyReportGroupVersionResource, "default", DefaultReportName)
+	assert.Len(report.Results, 1, "pending results should be flushed when the sink stops")
+}
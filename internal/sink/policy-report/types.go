@@ -0,0 +1,61 @@
+package policy_report
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// the subset of the wgpolicyk8s.io/v1alpha2 API written by the sink,
+// see https://github.com/kubernetes-sigs/wg-policy-prototypes/tree/master/policy-report
+
+const (
+	PolicyReportKind        = "PolicyReport"
+	ClusterPolicyReportKind = "ClusterPolicyReport"
+
+	ResultPass  = "pass"
+	ResultFail  = "fail"
+	ResultWarn  = "warn"
+	ResultError = "error"
+	ResultSkip  = "skip"
+)
+
+var (
+	GroupVersion                            = schema.GroupVersion{Group: "wgpolicyk8s.io", Version: "v1alpha2"}
+	PolicyReportGroupVersionResource        = GroupVersion.WithResource("policyreports")
+	ClusterPolicyReportGroupVersionResource = GroupVersion.WithResource("clusterpolicyreports")
+
+	severities = map[string]bool{"critical": true, "high": true, "medium": true, "low": true, "info": true}
+)
+
+// PolicyReportSummary provides a status count summary
+type PolicyReportSummary struct {
+	Pass  int `json:"pass"`
+	Fail  int `json:"fail"`
+	Warn  int `json:"warn"`
+	Error int `json:"error"`
+	Skip  int `json:"skip"`
+}
+
+// PolicyReportResult provides the result of a policy evaluation against a resource
+type PolicyReportResult struct {
+	Source     string               `json:"source,omitempty"`
+	Policy     string               `json:"policy"`
+	Rule       string               `json:"rule,omitempty"`
+	Category   string               `json:"category,omitempty"`
+	Severity   string               `json:"severity,omitempty"`
+	Timestamp  metav1.Timestamp     `json:"timestamp,omitempty"`
+	Result     string               `json:"result,omitempty"`
+	Scored     bool                 `json:"scored,omitempty"`
+	Subjects   []v1.ObjectReference `json:"resources,omitempty"`
+	Message    string               `json:"message,omitempty"`
+	Properties map[string]string    `json:"properties,omitempty"`
+}
+
+// PolicyReport is the PolicyReport and ClusterPolicyReport resource
+type PolicyReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Summary           PolicyReportSummary  `json:"summary,omitempty"`
+	Results           []PolicyReportResult `json:"results,omitempty"`
+}
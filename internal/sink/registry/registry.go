@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Dependencies are the agent components the sinks are created with
+type Dependencies struct {
+	// Name is the configured name of the sink, it labels the sink metrics
+	Name          string
+	Manager       manager.Manager
+	DynamicClient dynamic.Interface
+	AccountID     string
+	ClusterID     string
+	// ReportingController is the name of the controller reporting the kubernetes events
+	ReportingController string
+}
+
+// Config is the configuration of a sink, decoded by the factory of its type
+type Config map[string]interface{}
+
+// Decode decodes the configuration into the configuration struct of a sink type, the durations are parsed from strings,
+// e.g. 30s, and the unknown fields are rejected
+func (c Config) Decode(out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	err = decoder.Decode(map[string]interface{}(c))
+	if err != nil {
+		return fmt.Errorf("invalid sink configuration: %w", err)
+	}
+	return nil
+}
+
+// Factory creates a sink from its configuration
+type Factory func(ctx context.Context, deps Dependencies, config Config) (domain.PolicyValidationSink, error)
+
+var (
+	lock      sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register registers the factory of a sink type, it panics if the type is already registered
+func Register(sinkType string, factory Factory) {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, found := factories[sinkType]; found {
+		panic(fmt.Sprintf("sink type %s is already registered", sinkType))
+	}
+	factories[sinkType] = factory
+}
+
+// Types returns the registered sink types
+func Types() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	types := make([]string, 0, len(factories))
+	for sinkType := range factories {
+		types = append(types, sinkType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New creates a sink of the given type
+func New(ctx context.Context, deps Dependencies, sinkType string, config Config) (domain.PolicyValidationSink, error) {
+	lock.RLock()
+	factory, found := factories[sinkType]
+	lock.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown sink type %s, should be one of: %s", sinkType, strings.Join(Types(), ", "))
+	}
+	sink, err := factory(ctx, deps, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s sink: %w", sinkType, err)
+	}
+	return sink, nil
+}
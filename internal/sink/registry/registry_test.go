@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+type fakeSink struct {
+	config fakeConfig
+}
+
+func (f *fakeSink) Write(_ context.Context, _ []domain.PolicyValidation) error {
+	return nil
+}
+
+type fakeConfig struct {
+	Address  string
+	Interval time.Duration
+	Retries  int
+	Headers  map[string]string
+}
+
+func TestNew(t *testing.T) {
+	assert := require.New(t)
+	Register("fake", func(_ context.Context, deps Dependencies, config Config) (domain.PolicyValidationSink, error) {
+		sink := &fakeSink{config: fakeConfig{Retries: 5}}
+		err := config.Decode(&sink.config)
+		if err != nil {
+			return nil, err
+		}
+		return sink, nil
+	})
+	assert.Panics(func() { Register("fake", nil) })
+	assert.Contains(Types(), "fake")
+
+	sink, err := New(context.Background(), Dependencies{}, "fake", Config{
+		"address":  "http://localhost:8080",
+		"interval": "30s",
+		"headers":  map[string]interface{}{"Authorization": "Bearer token"},
+	})
+	assert.Nil(err)
+	assert.Equal(fakeConfig{
+		Address:  "http://localhost:8080",
+		Interval: 30 * time.Second,
+		Retries:  5,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+	}, sink.(*fakeSink).config)
+
+	_, err = New(context.Background(), Dependencies{}, "fake", Config{"adress": "http://localhost:8080"})
+	assert.ErrorContains(err, "adress")
+
+	_, err = New(context.Background(), Dependencies{}, "unknown", nil)
+	assert.ErrorContains(err, "unknown sink type unknown")
+}
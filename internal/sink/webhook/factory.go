@@ -0,0 +1,24 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+// SinkType is the type of the webhook sink in the sinks configuration
+const SinkType = "webhook"
+
+func init() {
+	registry.Register(SinkType, newSink)
+}
+
+func newSink(_ context.Context, deps registry.Dependencies, config registry.Config) (domain.PolicyValidationSink, error) {
+	sinkConfig := Config{Retries: DefaultRetries}
+	err := config.Decode(&sinkConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewWebhookSink(deps.Name, sinkConfig)
+}
@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/weaveworks/policy-agent/internal/metrics"
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+const (
+	resultChanSize int = 50
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body when a secret is set
+	SignatureHeader      = "X-Policy-Agent-Signature"
+	DefaultBatchSize     = 50
+	DefaultFlushInterval = 10 * time.Second
+	DefaultTimeout       = 10 * time.Second
+	DefaultRetries       = 5
+	minBackoff           = 500 * time.Millisecond
+	maxBackoff           = 30 * time.Second
+)
+
+// TLSConfig configures the TLS connections to the webhook
+type TLSConfig struct {
+	// CAFile is the CA bundle used to verify the webhook certificate, the system roots are used if not set
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// Config configures the webhook sink
+type Config struct {
+	URL string
+	// Template is a go template of the request body, it is executed with the batch .Results and their .Count.
+	// The results are sent as a JSON array if not set
+	Template string
+	Headers  map[string]string
+	// Secret signs the request body using HMAC-SHA256
+	Secret        string
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	// Retries is the number of retries of a failed request, the requests are retried with an exponential backoff
+	Retries int
+	TLS     TLSConfig
+}
+
+type templateData struct {
+	Results []domain.PolicyValidation
+	Count   int
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// WebhookSink posts batches of results to an http endpoint
+type WebhookSink struct {
+	name          string
+	client        *http.Client
+	url           string
+	template      *template.Template
+	headers       map[string]string
+	secret        []byte
+	batchSize     int
+	flushInterval time.Duration
+	timeout       time.Duration
+	retries       int
+	backoff       time.Duration
+	resultChan    chan domain.PolicyValidation
+	cancelWorker  context.CancelFunc
+	batch         []domain.PolicyValidation
+}
+
+// NewWebhookSink returns a sink that posts results to a webhook
+func NewWebhookSink(name string, config Config) (*WebhookSink, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook url is not set")
+	}
+	var tmpl *template.Template
+	if config.Template != "" {
+		var err error
+		tmpl, err = template.New("body").Funcs(templateFuncs).Parse(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse webhook template: %w", err)
+		}
+	}
+	tlsConfig, err := newTLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &WebhookSink{
+		name:          name,
+		client:        &http.Client{Transport: transport, Timeout: config.Timeout},
+		url:           config.URL,
+		template:      tmpl,
+		headers:       config.Headers,
+		secret:        []byte(config.Secret),
+		batchSize:     config.BatchSize,
+		flushInterval: config.FlushInterval,
+		timeout:       config.Timeout,
+		retries:       config.Retries,
+		backoff:       minBackoff,
+		resultChan:    make(chan domain.PolicyValidation, resultChanSize),
+		batch:         make([]domain.PolicyValidation, 0, config.BatchSize),
+	}, nil
+}
+
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CAFile != "" {
+		ca, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse webhook CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load webhook client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Start starts the writer worker
+func (w *WebhookSink) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	w.cancelWorker = cancel
+	return w.writeWorker(ctx)
+}
+
+// Stop stops worker
+func (w *WebhookSink) Stop() {
+	// the worker is not started when the agent fails to start
+	if w.cancelWorker == nil {
+		return
+	}
+	w.cancelWorker()
+}
+
+// Write adds results to buffer, implements github.com/weaveworks/policy-agent/pkg/policy-core/domain.PolicyValidationSink
+func (w *WebhookSink) Write(_ context.Context, results []domain.PolicyValidation) error {
+	logger.Debugw("writing validation results", "sink", "webhook", "count", len(results))
+	for _, result := range results {
+		w.resultChan <- result
+	}
+	return nil
+}
+
+// Deliver posts the results in batches and returns the results of the batch that failed to be posted with the
+// following batches, implements github.com/weaveworks/policy-agent/internal/sink/buffered.Deliverer
+func (w *WebhookSink) Deliver(ctx context.Context, results []domain.PolicyValidation) ([]domain.PolicyValidation, error) {
+	for start := 0; start < len(results); start += w.batchSize {
+		end := start + w.batchSize
+		if end > len(results) {
+			end = len(results)
+		}
+		err := w.post(ctx, results[start:end])
+		if err != nil {
+			return results[start:], err
+		}
+	}
+	return nil, nil
+}
+
+// writeWorker posts the results when the batch is full or the flush interval has passed
+func (w *WebhookSink) writeWorker(ctx context.Context) error {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-w.resultChan:
+			w.batch = append(w.batch, result)
+			if len(w.batch) >= w.batchSize {
+				w.flush(ctx)
+				ticker.Reset(w.flushInterval)
+			}
+		case <-ticker.C:
+			w.flush(ctx)
+		case <-ctx.Done():
+			logger.Info("stopping write worker ...")
+			// the pending results are sent, including their retries, within the request timeout
+			flushCtx, cancel := context.WithTimeout(context.Background(), w.timeout)
+			defer cancel()
+			w.flush(flushCtx)
+			return nil
+		}
+	}
+}
+
+func (w *WebhookSink) flush(ctx context.Context) {
+	if len(w.batch) == 0 {
+		return
+	}
+	err := w.post(ctx, w.batch)
+	if err != nil {
+		logger.Errorw("failed to post validation results to webhook", "url", w.url, "count", len(w.batch), "error", err)
+		metrics.SinkWriteFailures.WithLabelValues(w.name).Inc()
+	}
+	w.batch = w.batch[:0]
+}
+
+// post sends a batch of results, failed requests are retried with an exponential backoff
+func (w *WebhookSink) post(ctx context.Context, results []domain.PolicyValidation) error {
+	body, err := w.body(results)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := w.send(ctx, body)
+		if err == nil {
+			logger.Debugw("posted validation results to webhook", "url", w.url, "count", len(results))
+			return nil
+		}
+		if !retryable || attempt >= w.retries {
+			return err
+		}
+		logger.Warnw("failed to post validation results to webhook, retrying", "url", w.url, "retry", attempt+1, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// body renders the request body of a batch of results
+func (w *WebhookSink) body(results []domain.PolicyValidation) ([]byte, error) {
+	if w.template == nil {
+		body, err := json.Marshal(results)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode validation results: %w", err)
+		}
+		return body, nil
+	}
+	var body bytes.Buffer
+	err := w.template.Execute(&body, templateData{Results: results, Count: len(results)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute webhook template: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// send posts the body once, it returns whether the request can be retried when it fails
+func (w *WebhookSink) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+	if len(w.secret) != 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body, receivers compare it to the signature header
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// newServer returns a test server that records the requests and responds with the given statuses in order
+func newServer(t *testing.T, statuses ...int) (*httptest.Server, func() []request) {
+	var lock sync.Mutex
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, request{header: r.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []request {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]request(nil), requests...)
+	}
+}
+
+func newResult(policyID, entityName, status string) domain.PolicyValidation {
+	return domain.PolicyValidation{
+		Policy: domain.Policy{ID: policyID, Name: policyID, Severity: "high"},
+		Entity: domain.Entity{Name: entityName, Namespace: "default", Kind: "Deployment"},
+		Status: status,
+	}
+}
+
+func TestWebhookSink_post(t *testing.T) {
+	results := []domain.PolicyValidation{
+		newResult("policy-1", "app", domain.PolicyValidationStatusViolating),
+		newResult("policy-2", "worker", domain.PolicyValidationStatusViolating),
+	}
+
+	tests := []struct {
+		name         string
+		config       Config
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+		wantBody     string
+	}{
+		{
+			name:         "json results",
+			config:       Config{Headers: map[string]string{"Authorization": "Bearer token"}},
+			wantRequests: 1,
+		},
+		{
+			name: "template",
+			config: Config{
+				Template: `{"text": "{{ .Count }} violations: {{ range $i, $r := .Results }}{{ if $i }}, {{ end }}{{ upper $r.Policy.Severity }} {{ $r.Policy.ID }} {{ $r.Entity.Name }}{{ end }}"}`,
+			},
+			wantRequests: 1,
+			wantBody:     `{"text": "2 violations: HIGH policy-1 app, HIGH policy-2 worker"}`,
+		},
+		{
+			name:         "retry server errors",
+			config:       Config{Retries: 3},
+			statuses:     []int{http.StatusBadGateway, http.StatusTooManyRequests},
+			wantRequests: 3,
+		},
+		{
+			name:         "retries exhausted",
+			config:       Config{Retries: 1},
+			statuses:     []int{http.StatusInternalServerError, http.StatusInternalServerError},
+			wantErr:      true,
+			wantRequests: 2,
+		},
+		{
+			name:         "client errors are not retried",
+			config:       Config{Retries: 3},
+			statuses:     []int{http.StatusBadRequest},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+			server, requests := newServer(t, tt.statuses...)
+			tt.config.URL = server.URL
+			sink, err := NewWebhookSink(SinkType, tt.config)
+			assert.Nil(err)
+			sink.backoff = time.Millisecond
+
+			err = sink.post(context.Background(), results)
+			if tt.wantErr {
+				assert.Error(err)
+			} else {
+				assert.Nil(err)
+			}
+			assert.Len(requests(), tt.wantRequests)
+
+			req := requests()[0]
+			assert.Equal("application/json", req.header.Get("Content-Type"))
+			for key, value := range tt.config.Headers {
+				assert.Equal(value, req.header.Get(key))
+			}
+			if tt.wantBody != "" {
+				assert.Equal(tt.wantBody, string(req.body))
+				return
+			}
+			var got []domain.PolicyValidation
+			assert.Nil(json.Unmarshal(req.body, &got))
+			assert.Len(got, len(results))
+			assert.Equal("policy-1", got[0].Policy.ID)
+		})
+	}
+}
+
+func TestWebhookSink_signature(t *testing.T) {
+	assert := require.New(t)
+	server, requests := newServer(t)
+	sink, err := NewWebhookSink(SinkType, Config{URL: server.URL, Secret: "secret"})
+	assert.Nil(err)
+
+	err = sink.post(context.Background(), []domain.PolicyValidation{newResult("policy", "app", domain.PolicyValidationStatusCompliant)})
+	assert.Nil(err)
+
+	req := requests()[0]
+	assert.Equal("sha256="+Sign([]byte("secret"), req.body), req.header.Get(SignatureHeader))
+	assert.NotEqual(req.header.Get(SignatureHeader), "sha256="+Sign([]byte("other"), req.body))
+}
+
+func TestWebhookSink_TLS(t *testing.T) {
+	assert := require.New(t)
+	var received int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhookSink(SinkType, Config{URL: server.URL})
+	assert.Nil(err)
+	err = sink.post(context.Background(), []domain.PolicyValidation{newResult("policy", "app", domain.PolicyValidationStatusViolating)})
+	assert.Error(err, "unknown certificate authority should be rejected")
+
+	sink, err = NewWebhookSink(SinkType, Config{URL: server.URL, TLS: TLSConfig{InsecureSkipVerify: true}})
+	assert.Nil(err)
+	err = sink.post(context.Background(), []domain.PolicyValidation{newResult("policy", "app", domain.PolicyValidationStatusViolating)})
+	assert.Nil(err)
+	assert.Equal(1, received)
+
+	_, err = NewWebhookSink(SinkType, Config{URL: server.URL, TLS: TLSConfig{CAFile: "missing.pem"}})
+	assert.Error(err)
+}
+
+func TestWebhookSink_batches(t *testing.T) {
+	assert := require.New(t)
+	server, requests := newServer(t)
+	sink, err := NewWebhookSink(SinkType, Config{URL: server.URL, BatchSize: 2, FlushInterval: time.Hour})
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sink.Start(ctx)
+		close(done)
+	}()
+
+	err = sink.Write(ctx, []domain.PolicyValidation{
+		newResult("policy-1", "app", domain.PolicyValidationStatusViolating),
+		newResult("policy-2", "app", domain.PolicyValidationStatusViolating),
+		newResult("policy-3", "app", domain.PolicyValidationStatusViolating),
+	})
+	assert.Nil(err)
+	assert.Eventually(func() bool { return len(requests()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// the pending results are flushed when the sink stops
+	cancel()
+	<-done
+	assert.Len(requests(), 2)
+
+	var got []domain.PolicyValidation
+	assert.Nil(json.Unmarshal(requests()[1].body, &got))
+	assert.Len(got, 1)
+	assert.Equal("policy-3", got[0].Policy.ID)
+
+	_, err = NewWebhookSink(SinkType, Config{URL: server.URL, Template: "{{ .Results"})
+	assert.Error(err)
+	_, err = NewWebhookSink(SinkType, Config{})
+	assert.Error(err)
+}
+
+func TestWebhookSink_Deliver(t *testing.T) {
+	assert := require.New(t)
+	server, requests := newServer(t, http.StatusOK, http.StatusBadRequest)
+	sink, err := NewWebhookSink(SinkType, Config{URL: server.URL, BatchSize: 2})
+	assert.Nil(err)
+
+	results := []domain.PolicyValidation{
+		newResult("policy-1", "app", domain.PolicyValidationStatusViolating),
+		newResult("policy-2", "app", domain.PolicyValidationStatusViolating),
+		newResult("policy-3", "app", domain.PolicyValidationStatusViolating),
+		newResult("policy-4", "app", domain.PolicyValidationStatusViolating),
+		newResult("policy-5", "app", domain.PolicyValidationStatusViolating),
+	}
+	// the results of the rejected batch and the following batches are not delivered
+	undelivered, err := sink.Deliver(context.Background(), results)
+	assert.Error(err)
+	assert.Equal(results[2:], undelivered)
+	assert.Len(requests(), 2)
+
+	undelivered, err = sink.Deliver(context.Background(), undelivered)
+	assert.Nil(err)
+	assert.Empty(undelivered)
+	assert.Len(requests(), 4)
+}
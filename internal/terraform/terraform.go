@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/weaveworks/policy-agent/internal/tracing"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/validation"
@@ -50,7 +51,8 @@ func (a *TerraformHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	entity := domain.NewEntityFromSpec(entitySpec)
 	logger.Infow("received valid request", "namespace", entity.Namespace, "name", entity.Name)
 
-	result, err := a.validator.Validate(req.Context(), entity, "terraform")
+	ctx := tracing.ContextFromRequest(req.Context(), req)
+	result, err := a.validator.Validate(ctx, entity, "terraform")
 	if err != nil {
 		http.Error(
 			rw,
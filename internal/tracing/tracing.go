@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/weaveworks/policy-agent/pkg/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ProtocolGRPC exports the spans using OTLP over gRPC
+	ProtocolGRPC = "grpc"
+	// ProtocolHTTP exports the spans using OTLP over HTTP
+	ProtocolHTTP = "http"
+
+	DefaultServiceName = "policy-agent"
+)
+
+// tracer uses the global tracer provider, spans are not recorded when tracing is not set up
+var tracer = otel.Tracer("github.com/weaveworks/policy-agent")
+
+// Config is the OTLP exporter configuration
+type Config struct {
+	// Endpoint is the collector host and port, the OTEL_EXPORTER_OTLP_ENDPOINT environment variable is used if not set
+	Endpoint string
+	Protocol string
+	Insecure bool
+	Headers  map[string]string
+	// SampleRatio is the ratio of the sampled traces that are not started by a sampled parent
+	SampleRatio float64
+	ServiceName string
+	ClusterID   string
+}
+
+// Setup sets the global tracer provider exporting the spans to an OTLP collector and the W3C trace context
+// propagator, returns a function that flushes the pending spans and stops the provider
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	exporter, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp trace exporter: %w", err)
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			attribute.String("policy_agent.cluster_id", config.ClusterID),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warnw("failed to export traces", "error", err)
+	}))
+
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, config Config) (*otlptrace.Exporter, error) {
+	switch config.Protocol {
+	case ProtocolGRPC, "":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(config.Headers)}
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ProtocolHTTP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(config.Headers)}
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid protocol %s, should be one of: %s, %s", config.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+}
+
+// ContextFromRequest returns the context with the trace context propagated in the request headers
+func ContextFromRequest(ctx context.Context, r *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+}
+
+// Start starts a span of the agent tracer
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error of the traced operation if any and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
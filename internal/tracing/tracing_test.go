@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestContextFromRequest(t *testing.T) {
+	assert := require.New(t)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	req, err := http.NewRequest(http.MethodPost, "/admission", nil)
+	assert.Nil(err)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	spanContext := trace.SpanContextFromContext(ContextFromRequest(context.Background(), req))
+	assert.True(spanContext.IsRemote())
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID().String())
+	assert.Equal("00f067aa0ba902b7", spanContext.SpanID().String())
+
+	req.Header.Del("traceparent")
+	spanContext = trace.SpanContextFromContext(ContextFromRequest(context.Background(), req))
+	assert.False(spanContext.IsValid())
+}
+
+func TestStartEnd(t *testing.T) {
+	assert := require.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	End(child, errors.New("failed"))
+	End(parent, nil)
+
+	spans := recorder.Ended()
+	assert.Len(spans, 2)
+	assert.Equal("child", spans[0].Name())
+	assert.Equal(codes.Error, spans[0].Status().Code)
+	assert.Equal("failed", spans[0].Status().Description)
+	assert.Equal(parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(codes.Unset, spans[1].Status().Code)
+}
+
+func TestSetup(t *testing.T) {
+	_, err := Setup(context.Background(), Config{Protocol: "thrift"})
+	require.Error(t, err)
+
+	for _, protocol := range []string{ProtocolGRPC, ProtocolHTTP} {
+		shutdown, err := Setup(context.Background(), Config{Endpoint: "localhost:4317", Protocol: protocol, Insecure: true, SampleRatio: 1})
+		require.Nil(t, err)
+		require.Nil(t, shutdown(context.Background()))
+	}
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
 	"time"
 
-	"github.com/fluxcd/pkg/runtime/events"
 	"github.com/urfave/cli/v2"
 	pacv2 "github.com/weaveworks/policy-agent/api/v2beta3"
 	"github.com/weaveworks/policy-agent/configuration"
@@ -16,24 +15,29 @@ import (
 	"github.com/weaveworks/policy-agent/internal/admission"
 	"github.com/weaveworks/policy-agent/internal/auditor"
 	"github.com/weaveworks/policy-agent/internal/clients/kube"
+	"github.com/weaveworks/policy-agent/internal/entities/file"
 	"github.com/weaveworks/policy-agent/internal/entities/k8s"
+	"github.com/weaveworks/policy-agent/internal/metrics"
 	"github.com/weaveworks/policy-agent/internal/mutation"
+	"github.com/weaveworks/policy-agent/internal/namespace"
 	crd "github.com/weaveworks/policy-agent/internal/policies"
-	"github.com/weaveworks/policy-agent/internal/sink/elastic"
-	"github.com/weaveworks/policy-agent/internal/sink/filesystem"
-	flux_notification "github.com/weaveworks/policy-agent/internal/sink/flux-notification"
-	k8s_event "github.com/weaveworks/policy-agent/internal/sink/k8s-event"
+	_ "github.com/weaveworks/policy-agent/internal/sink/all"
+	"github.com/weaveworks/policy-agent/internal/sink/buffered"
+	"github.com/weaveworks/policy-agent/internal/sink/dedup"
+	"github.com/weaveworks/policy-agent/internal/sink/filter"
+	"github.com/weaveworks/policy-agent/internal/sink/registry"
 	"github.com/weaveworks/policy-agent/internal/terraform"
+	"github.com/weaveworks/policy-agent/internal/tracing"
 	"github.com/weaveworks/policy-agent/pkg/log"
 	"github.com/weaveworks/policy-agent/pkg/logger"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/validation"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
@@ -47,7 +51,8 @@ var (
 )
 
 const (
-	eventReportingController string = "policy-agent"
+	eventReportingController string        = "policy-agent"
+	tracingShutdownTimeout   time.Duration = 5 * time.Second
 )
 
 func main() {
@@ -91,7 +96,7 @@ func main() {
 
 	app.Action = func(contextCli *cli.Context) error {
 		logger.Infow("initializing Policy Agent", "build", build)
-		logger.Infof("config: %+v", config)
+		logger.Infof("config: %+v", config.Redacted())
 		var kubeConfig *rest.Config
 		var err error
 		if config.KubeConfigFile == "" {
@@ -113,6 +118,30 @@ func main() {
 			return fmt.Errorf("failed to add policy crd to scheme: %w", err)
 		}
 
+		if config.Tracing.Enabled {
+			logger.Infow("initializing tracing ...", "endpoint", config.Tracing.Endpoint, "protocol", config.Tracing.Protocol)
+			shutdownTracing, err := tracing.Setup(contextCli.Context, tracing.Config{
+				Endpoint:    config.Tracing.Endpoint,
+				Protocol:    config.Tracing.Protocol,
+				Insecure:    config.Tracing.Insecure,
+				Headers:     config.Tracing.Headers,
+				SampleRatio: config.Tracing.SampleRatio,
+				ServiceName: config.Tracing.ServiceName,
+				ClusterID:   config.ClusterID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to initialize tracing: %w", err)
+			}
+			defer func() {
+				// the pending spans are exported before exiting
+				ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+				defer cancel()
+				if err := shutdownTracing(ctx); err != nil {
+					logger.Errorw("failed to stop tracing", "error", err)
+				}
+			}()
+		}
+
 		lg := log.NewControllerLog(config.AccountID, config.ClusterID)
 
 		mgr, err := ctrl.NewManager(kubeConfig, ctrl.Options{
@@ -147,134 +176,64 @@ func main() {
 		if err != nil {
 			return fmt.Errorf("init client failed: %w", err)
 		}
-		entitiesSources, err := k8s.GetEntitiesSources(contextCli.Context, kubeClient)
+		namespaceFilter, err := namespace.NewFilter(mgr.GetCache(), config.ExcludeNamespaces, config.NamespaceSelector)
 		if err != nil {
-			return fmt.Errorf("initializing entities sources failed: %w", err)
+			return fmt.Errorf("failed to initialize namespace filter: %w", err)
 		}
 
-		auditSinks := []domain.PolicyValidationSink{}
-		admissionSinks := []domain.PolicyValidationSink{}
-		terraformSinks := []domain.PolicyValidationSink{}
+		entitiesScope := k8s.Scope{
+			AllowedGroups: config.Audit.Scope.AllowedGroups,
+			AllowedKinds:  config.Audit.Scope.AllowedKinds,
+			DeniedGroups:  config.Audit.Scope.DeniedGroups,
+			DeniedKinds:   config.Audit.Scope.DeniedKinds,
+			LabelSelector: config.Audit.Scope.LabelSelector,
+		}
+		entitiesSources, err := k8s.GetEntitiesSources(contextCli.Context, kubeClient, namespaceFilter, entitiesScope)
+		if err != nil {
+			return fmt.Errorf("initializing entities sources failed: %w", err)
+		}
 
+		sinkDeps := registry.Dependencies{
+			Manager:             mgr,
+			DynamicClient:       kubeClient.DynamicClient,
+			AccountID:           config.AccountID,
+			ClusterID:           config.ClusterID,
+			ReportingController: eventReportingController,
+		}
+		var auditSinks, admissionSinks, terraformSinks []domain.PolicyValidationSink
+		// createdAuditSinks are the audit sinks before being wrapped by the buffers, filters and deduplication
+		var createdAuditSinks []domain.PolicyValidationSink
 		if config.Audit.Enabled {
-			auditSinksConfig := config.Audit.Sinks
-			if auditSinksConfig.FilesystemSink != nil {
-				fileName := auditSinksConfig.FilesystemSink.FileName
-				fileSystemSink, err := initFileSystemSink(mgr, fileName)
-				if err != nil {
-					return err
-				}
-				defer fileSystemSink.Stop()
-				auditSinks = append(auditSinks, fileSystemSink)
-			}
-			if auditSinksConfig.K8sEventsSink != nil && auditSinksConfig.K8sEventsSink.Enabled {
-				logger.Info("initializing kubernetes events audit sink ...")
-				k8sEventSink, err := initK8sEventSink(mgr, config)
-				if err != nil {
-					return err
-				}
-				defer k8sEventSink.Stop()
-				auditSinks = append(auditSinks, k8sEventSink)
-			}
-			if auditSinksConfig.FluxNotificationSink != nil {
-				fluxControllerAddress := auditSinksConfig.FluxNotificationSink.Address
-				logger.Info("initializing flux notification controller audit sink ...", "address", fluxControllerAddress)
-				fluxNotificationSink, err := initFluxNotificationSink(mgr, config, fluxControllerAddress)
-				if err != nil {
-					return err
-				}
-				defer fluxNotificationSink.Stop()
-				auditSinks = append(auditSinks, fluxNotificationSink)
-			}
-			if auditSinksConfig.ElasticSink != nil {
-				elasticsearchSinkConfig := auditSinksConfig.ElasticSink
-				elasticsearchSink, err := initElasticSearchSink(mgr, *elasticsearchSinkConfig)
-				if err != nil {
-					return err
-				}
-				auditSinks = append(auditSinks, elasticsearchSink)
+			auditSinks, createdAuditSinks, err = initSinks(
+				contextCli.Context, sinkDeps, "audit", config.Audit.Sinks,
+				config.Audit.SinksBuffer, config.Audit.WriteCompliance,
+			)
+			if err != nil {
+				return err
 			}
+			defer stopSinks(createdAuditSinks)
 		}
-
 		if config.Admission.Enabled {
-			admissionSinksConfig := config.Admission.Sinks
-			if admissionSinksConfig.FilesystemSink != nil {
-				fileName := admissionSinksConfig.FilesystemSink.FileName
-				fileSystemSink, err := initFileSystemSink(mgr, fileName)
-				if err != nil {
-					return err
-				}
-				defer fileSystemSink.Stop()
-				admissionSinks = append(admissionSinks, fileSystemSink)
-			}
-			if admissionSinksConfig.K8sEventsSink != nil && admissionSinksConfig.K8sEventsSink.Enabled {
-				logger.Info("initializing kubernetes events admission sink ...")
-				k8sEventSink, err := initK8sEventSink(mgr, config)
-				if err != nil {
-					return err
-				}
-				defer k8sEventSink.Stop()
-				admissionSinks = append(admissionSinks, k8sEventSink)
-			}
-			if admissionSinksConfig.FluxNotificationSink != nil {
-				fluxControllerAddress := admissionSinksConfig.FluxNotificationSink.Address
-				logger.Info("initializing flux notification controller admission sink ...", "address", fluxControllerAddress)
-				fluxNotificationSink, err := initFluxNotificationSink(mgr, config, fluxControllerAddress)
-				if err != nil {
-					return err
-				}
-				defer fluxNotificationSink.Stop()
-				admissionSinks = append(admissionSinks, fluxNotificationSink)
-			}
-			if admissionSinksConfig.ElasticSink != nil {
-				elasticsearchSinkConfig := admissionSinksConfig.ElasticSink
-				elasticsearchSink, err := initElasticSearchSink(mgr, *elasticsearchSinkConfig)
-				if err != nil {
-					return err
-				}
-				admissionSinks = append(admissionSinks, elasticsearchSink)
+			var createdSinks []domain.PolicyValidationSink
+			admissionSinks, createdSinks, err = initSinks(
+				contextCli.Context, sinkDeps, "admission", config.Admission.Sinks,
+				config.Admission.SinksBuffer, false,
+			)
+			if err != nil {
+				return err
 			}
-
+			defer stopSinks(createdSinks)
 		}
-
 		if config.TFAdmission.Enabled {
-			terraformSinksConfig := config.TFAdmission.Sinks
-			if terraformSinksConfig.FilesystemSink != nil {
-				fileName := terraformSinksConfig.FilesystemSink.FileName
-				fileSystemSink, err := initFileSystemSink(mgr, fileName)
-				if err != nil {
-					return err
-				}
-				defer fileSystemSink.Stop()
-				terraformSinks = append(terraformSinks, fileSystemSink)
-			}
-			if terraformSinksConfig.K8sEventsSink != nil && terraformSinksConfig.K8sEventsSink.Enabled {
-				logger.Info("initializing kubernetes events terraform sink ...")
-				k8sEventSink, err := initK8sEventSink(mgr, config)
-				if err != nil {
-					return err
-				}
-				defer k8sEventSink.Stop()
-				terraformSinks = append(terraformSinks, k8sEventSink)
-			}
-			if terraformSinksConfig.FluxNotificationSink != nil {
-				fluxControllerAddress := terraformSinksConfig.FluxNotificationSink.Address
-				logger.Info("initializing flux notification controller terraform sink ...", "address", fluxControllerAddress)
-				fluxNotificationSink, err := initFluxNotificationSink(mgr, config, fluxControllerAddress)
-				if err != nil {
-					return err
-				}
-				defer fluxNotificationSink.Stop()
-				terraformSinks = append(terraformSinks, fluxNotificationSink)
-			}
-			if terraformSinksConfig.ElasticSink != nil {
-				elasticsearchSinkConfig := terraformSinksConfig.ElasticSink
-				elasticsearchSink, err := initElasticSearchSink(mgr, *elasticsearchSinkConfig)
-				if err != nil {
-					return err
-				}
-				terraformSinks = append(terraformSinks, elasticsearchSink)
+			var createdSinks []domain.PolicyValidationSink
+			terraformSinks, createdSinks, err = initSinks(
+				contextCli.Context, sinkDeps, "tfAdmission", config.TFAdmission.Sinks,
+				config.TFAdmission.SinksBuffer, false,
+			)
+			if err != nil {
+				return err
 			}
+			defer stopSinks(createdSinks)
 		}
 
 		if config.Audit.Enabled {
@@ -286,22 +245,116 @@ func main() {
 				return fmt.Errorf("failed to initialize CRD policies source: %w", err)
 			}
 
+			// the compliances resolve the deduplicated violations
 			validator := validation.NewOPAValidator(
 				policiesSource,
-				config.Audit.WriteCompliance,
+				config.Audit.WriteCompliance || configuration.DedupEnabled(config.Audit.Sinks),
 				auditor.TypeAudit,
 				config.AccountID,
 				config.ClusterID,
 				false,
 				auditSinks...,
 			)
-			auditControllerInterval := time.Duration(config.Audit.Interval) * time.Hour
-			if config.Audit.Interval < 1 {
-				logger.Fatal("audit interval can not be less than 1 hour, current interval: ", auditControllerInterval)
+			validator.RegisterEvaluationObserver(metrics.EvaluationObserver{})
+			auditSchedules, err := getAuditSchedules(config.Audit)
+			if err != nil {
+				return fmt.Errorf("failed to initialize audit schedules: %w", err)
+			}
+			auditSources := entitiesSources
+			for _, path := range config.Audit.ManifestsPaths {
+				logger.Infow("initializing manifests entities source ...", "path", path)
+				fileSource, err := file.NewFileEntitySource(path)
+				if err != nil {
+					return fmt.Errorf("failed to initialize manifests entities source: %w", err)
+				}
+				auditSources = append(auditSources, fileSource)
+			}
+			auditController := auditor.NewAuditController(
+				validator,
+				auditSchedules,
+				auditor.AuditWorkers{
+					Sources:     config.Audit.Workers.Sources,
+					Validations: config.Audit.Workers.Validations,
+					ListQPS:     config.Audit.Workers.ListQPS,
+					ListBurst:   config.Audit.Workers.ListBurst,
+				},
+				auditSources...,
+			)
+			auditController.AuditOwnedKinds(config.Audit.Scope.OwnedKinds)
+			logAuditScope(config.Audit.Scope, entitiesSources)
+
+			if config.Audit.ComplianceReport.Enabled {
+				logger.Infow(
+					"initializing audit compliance reports ...",
+					"retention", config.Audit.ComplianceReport.Retention,
+					"top-resources", config.Audit.ComplianceReport.TopResources,
+				)
+				auditController.RegisterReportWriter(auditor.NewComplianceReportWriter(
+					mgr.GetAPIReader(),
+					mgr.GetClient(),
+					config.Audit.ComplianceReport.Retention,
+					config.Audit.ComplianceReport.TopResources,
+				))
+			}
+			for i, sink := range createdAuditSinks {
+				// the deduplicated results are not written again, they would be pruned as if they were not validated
+				if pruner, ok := sink.(auditor.ResultsPruner); ok && !config.Audit.Sinks[i].Dedup.Enabled {
+					auditController.RegisterResultsPruner(pruner)
+				}
+			}
+			if config.Audit.Checkpoint.Enabled {
+				auditController.RegisterCheckpointStore(getAuditCheckpointStore(mgr, kubeClient, config.Audit.Checkpoint))
 			}
-			auditController := auditor.NewAuditController(validator, auditControllerInterval, entitiesSources...)
 			mgr.Add(auditController)
-			auditController.Audit(auditor.AuditEventTypeInitial, nil)
+
+			discovery := k8s.NewSourcesDiscovery(
+				kubeClient,
+				namespaceFilter,
+				entitiesScope,
+				config.Audit.Discovery.Interval,
+				config.Audit.Discovery.WatchCRDs,
+				entitiesSources,
+			)
+			discovery.OnChange(auditController.UpdateEntitiesSources)
+			mgr.Add(discovery)
+
+			versionTracker, err := crd.NewVersionTracker(contextCli.Context, mgr.GetCache())
+			if err != nil {
+				return fmt.Errorf("failed to initialize policies version tracker: %w", err)
+			}
+
+			if config.Audit.Continuous {
+				logger.Info("initializing continuous audit ...")
+				// the informers initial list validates all entities, no initial audit is needed
+				entitiesWatcher := k8s.NewEntitiesWatcher(kubeClient.DynamicClient, auditController.AuditEntity, entitiesSources...)
+				discovery.OnChange(entitiesWatcher.UpdateSources)
+				versionTracker.OnChange(func(oldObj, newObj client.Object) {
+					if _, ok := auditor.ChangeScope(mgr.GetCache(), oldObj, newObj); ok {
+						entitiesWatcher.Resync()
+					}
+				})
+				mgr.Add(entitiesWatcher)
+			} else {
+				versionTracker.OnChange(func(oldObj, newObj client.Object) {
+					scope, ok := auditor.ChangeScope(mgr.GetCache(), oldObj, newObj)
+					if !ok {
+						return
+					}
+					auditController.Audit(auditor.AuditEventTypePolicyChange, scope)
+				})
+				// the entities of the added resources are audited without waiting for the next audit
+				discovery.OnChange(func(added, _ []domain.EntitiesSource) {
+					if len(added) == 0 {
+						return
+					}
+					var kinds []string
+					for i := range added {
+						kinds = append(kinds, added[i].Kind())
+					}
+					auditController.Audit(auditor.AuditEventTypeDiscovery, auditor.AuditScope{Kinds: kinds})
+				})
+				auditController.Audit(auditor.AuditEventTypeInitial, nil)
+			}
 		}
 
 		if config.Admission.Enabled {
@@ -314,16 +367,49 @@ func main() {
 
 			validator := validation.NewOPAValidator(
 				policiesSource,
-				false,
+				configuration.DedupEnabled(config.Admission.Sinks),
 				admission.TypeAdmission,
 				config.AccountID,
 				config.ClusterID,
 				false,
 				admissionSinks...,
 			)
+			validator.RegisterEvaluationObserver(metrics.EvaluationObserver{})
+			responseFormatter, err := admission.NewResponseFormatter(
+				config.Admission.Response.Template,
+				config.Admission.Response.DocsURL,
+				config.Admission.Response.MaxMessageSize,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to initialize admission response formatter: %w", err)
+			}
+			var validationCache *admission.ValidationCache
+			if config.Admission.Cache.Enabled {
+				versionTracker, err := crd.NewVersionTracker(contextCli.Context, mgr.GetCache())
+				if err != nil {
+					return fmt.Errorf("failed to initialize policies version tracker: %w", err)
+				}
+				logger.Infow("initializing admission cache ...", "size", config.Admission.Cache.Size, "dedupe", config.Admission.Cache.Dedupe)
+				validationCache = admission.NewValidationCache(
+					config.Admission.Cache.Size,
+					config.Admission.Cache.Dedupe,
+					configuration.DedupEnabled(config.Admission.Sinks),
+					versionTracker,
+					mgr.GetCache(),
+					admissionSinks...,
+				)
+			}
 			admissionServer := admission.NewAdmissionHandler(
 				config.LogLevel,
 				validator,
+				responseFormatter,
+				namespaceFilter,
+				validationCache,
+				admission.LatencyBudget{
+					Timeout:       config.Admission.Budget.Timeout,
+					Margin:        config.Admission.Budget.Margin,
+					FailurePolicy: config.Admission.Budget.FailurePolicy,
+				},
 			)
 			logger.Info("starting admission server...")
 			err = admissionServer.Run(mgr)
@@ -332,6 +418,7 @@ func main() {
 			}
 
 			if config.Admission.Mutate {
+				// the mutating validator writes the violations fixed by the mutation
 				validator := validation.NewOPAValidator(
 					policiesSource,
 					false,
@@ -339,8 +426,9 @@ func main() {
 					config.AccountID,
 					config.ClusterID,
 					true,
+					admissionSinks...,
 				)
-				mutationServer := mutation.NewMutationHandler(validator)
+				mutationServer := mutation.NewMutationHandler(validator, namespaceFilter)
 				logger.Info("starting mutation server...")
 				err = mutationServer.Run(mgr)
 				if err != nil {
@@ -358,13 +446,14 @@ func main() {
 
 			validator := validation.NewOPAValidator(
 				policiesSource,
-				false,
+				configuration.DedupEnabled(config.TFAdmission.Sinks),
 				terraform.TypeTFAdmission,
 				config.AccountID,
 				config.ClusterID,
 				false,
 				terraformSinks...,
 			)
+			validator.RegisterEvaluationObserver(metrics.EvaluationObserver{})
 
 			terraformHandler := terraform.NewTerraformHandler(
 				config.LogLevel,
@@ -398,66 +487,194 @@ func main() {
 	}
 }
 
-func initFileSystemSink(mgr manager.Manager, filename string) (*filesystem.FileSystemSink, error) {
-	filePath := filepath.Join("/logs", filename)
-	logger.Infow("initializing filesystem sink ...", "file", filePath)
-
-	sink, err := filesystem.NewFileSystemSink(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize filesystem sink: %w", err)
-	}
-
-	logger.Info("starting file system sink ...")
-	mgr.Add(sink)
+// initSinks creates the sinks of a mode using the factories of the registered sink types, the results written to each
+// sink are filtered, buffered and deduplicated when enabled. It returns the wrapped sinks and the created sinks
+func initSinks(
+	ctx context.Context,
+	deps registry.Dependencies,
+	mode string,
+	sinksConfig []configuration.SinkConfig,
+	bufferConfig configuration.SinksBuffer,
+	writeCompliance bool,
+) ([]domain.PolicyValidationSink, []domain.PolicyValidationSink, error) {
+	// the validator writes the compliances resolving the deduplicated violations
+	dedupCompliance := configuration.DedupEnabled(sinksConfig) && !writeCompliance
+	var sinks, createdSinks []domain.PolicyValidationSink
+	names := make(map[string]bool)
+	for _, sinkConfig := range sinksConfig {
+		name := sinkConfig.Name
+		if name == "" {
+			name = sinkConfig.Type
+		}
+		if names[name] {
+			return nil, nil, fmt.Errorf("duplicate %s sink name %s, the sinks of the same type should have different names", mode, name)
+		}
+		names[name] = true
 
-	return sink, nil
-}
+		logger.Infow("initializing sink ...", "mode", mode, "type", sinkConfig.Type, "sink", name)
+		deps.Name = name
+		sink, err := registry.New(ctx, deps, sinkConfig.Type, registry.Config(sinkConfig.Config))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to initialize %s sink %s: %w", mode, name, err)
+		}
+		// the buffer delivers the results to the deliverers and closes them when it stops,
+		// their own write workers are not started
+		_, deliverer := sink.(buffered.Deliverer)
+		if runnable, ok := sink.(manager.Runnable); ok && !deliverer {
+			logger.Infow("starting sink ...", "mode", mode, "sink", name)
+			deps.Manager.Add(runnable)
+		}
+		createdSinks = append(createdSinks, sink)
 
-func initFluxNotificationSink(mgr manager.Manager, config configuration.Config, fluxNotificationAddr string) (*flux_notification.FluxNotificationSink, error) {
-	recorder, err := events.NewRecorder(mgr, mgr.GetLogger(), fluxNotificationAddr, eventReportingController)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize event recorder: %w", err)
+		sink, err = initBufferedSink(deps.Manager, mode, name, sink, bufferConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the results are filtered before being buffered
+		sink, err = initSinkFilter(sink, sinkConfig.Filter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to initialize %s sink %s filter: %w", mode, name, err)
+		}
+		switch {
+		case sinkConfig.Dedup.Enabled:
+			sink = initDedupSink(mode, name, sink, sinkConfig.Dedup, writeCompliance)
+		case dedupCompliance:
+			// the compliances are only written to the deduplicated sinks
+			sink, err = filter.NewFilteredSink(sink, filter.Config{Statuses: []string{domain.PolicyValidationStatusViolating}})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		sinks = append(sinks, sink)
 	}
+	return sinks, createdSinks, nil
+}
 
-	sink, err := flux_notification.NewFluxNotificationSink(recorder, fluxNotificationAddr, config.AccountID, config.ClusterID)
+// initBufferedSink wraps a sink of a mode with a buffer so that the validations are not blocked by a slow sink
+func initBufferedSink(
+	mgr manager.Manager,
+	mode string,
+	name string,
+	sink domain.PolicyValidationSink,
+	bufferConfig configuration.SinksBuffer,
+) (domain.PolicyValidationSink, error) {
+	bufferedSink, err := buffered.NewBufferedSink(mode, name, sink, buffered.Config{
+		Size:     bufferConfig.Size,
+		Overflow: bufferConfig.Overflow,
+		DeadLetter: buffered.DeadLetterConfig{
+			Directory:      bufferConfig.DeadLetter.Directory,
+			MaxSize:        bufferConfig.DeadLetter.MaxSizeMB * 1024 * 1024,
+			ReplayInterval: bufferConfig.DeadLetter.ReplayInterval,
+		},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize flux notification sink: %w", err)
+		return nil, fmt.Errorf("failed to initialize %s %s sink buffer: %w", name, mode, err)
 	}
+	logger.Infow("starting sink buffer ...", "mode", mode, "sink", name, "overflow", bufferConfig.Overflow)
+	mgr.Add(bufferedSink)
+	return bufferedSink, nil
+}
 
-	logger.Info("starting flux notification sink ...")
-	mgr.Add(sink)
-
-	return sink, nil
+// initDedupSink returns the sink deduplicating the results written to a sink of a mode
+func initDedupSink(
+	mode string,
+	name string,
+	sink domain.PolicyValidationSink,
+	dedupConfig configuration.SinkDedup,
+	writeCompliance bool,
+) domain.PolicyValidationSink {
+	logger.Infow("starting sink deduplication ...", "mode", mode, "sink", name, "reminderInterval", dedupConfig.ReminderInterval)
+	return dedup.NewDedupSink(mode, name, dedup.Config{
+		ReminderInterval: dedupConfig.ReminderInterval,
+		WriteCompliance:  writeCompliance,
+		TTL:              dedupConfig.TTL,
+	}, sink)
 }
 
-func initK8sEventSink(mgr manager.Manager, config configuration.Config) (*k8s_event.K8sEventSink, error) {
-	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize kubernetes clientset: %w", err)
+// initSinkFilter returns the sink writing only the results matching the filter, the sink is returned if there is no filter
+func initSinkFilter(sink domain.PolicyValidationSink, filterConfig *configuration.SinkFilter) (domain.PolicyValidationSink, error) {
+	if filterConfig == nil {
+		return sink, nil
 	}
+	return filter.NewFilteredSink(sink, filter.Config{
+		Statuses:    filterConfig.Statuses,
+		MinSeverity: filterConfig.MinSeverity,
+		Categories:  filterConfig.Categories,
+		Tags:        filterConfig.Tags,
+		PolicyIDs:   filterConfig.PolicyIDs,
+		Namespaces: filter.Namespaces{
+			Include: filterConfig.Namespaces.Include,
+			Exclude: filterConfig.Namespaces.Exclude,
+		},
+	})
+}
 
-	sink, err := k8s_event.NewK8sEventSink(clientset, config.AccountID, config.ClusterID, eventReportingController)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize kubernetes event sink: %w", err)
+// stopSinks stops the workers of the sinks
+func stopSinks(sinks []domain.PolicyValidationSink) {
+	for _, sink := range sinks {
+		if s, ok := sink.(interface{ Stop() }); ok {
+			s.Stop()
+		}
 	}
+}
 
-	logger.Info("starting kubernetes event sink ...")
-	mgr.Add(sink)
+func logAuditScope(scope configuration.AuditScope, entitiesSources []domain.EntitiesSource) {
+	var kinds []string
+	for i := range entitiesSources {
+		kinds = append(kinds, entitiesSources[i].Kind())
+	}
+	logger.Infow(
+		"audit scope",
+		"allowed-groups", scope.AllowedGroups,
+		"allowed-kinds", scope.AllowedKinds,
+		"denied-groups", scope.DeniedGroups,
+		"denied-kinds", scope.DeniedKinds,
+		"label-selector", scope.LabelSelector,
+		"owned-kinds", scope.OwnedKinds,
+		"audited-kinds", kinds,
+	)
+}
 
-	return sink, nil
+func getAuditCheckpointStore(mgr manager.Manager, kubeClient *kube.KubeClient, checkpointConfig configuration.AuditCheckpoint) auditor.CheckpointStore {
+	if checkpointConfig.File != "" {
+		logger.Infow("initializing audit checkpoint ...", "file", checkpointConfig.File)
+		return auditor.NewFileCheckpointStore(checkpointConfig.File)
+	}
+	namespace := checkpointConfig.ConfigMap.Namespace
+	if namespace == "" {
+		namespace = kubeClient.GetAgentNamespace()
+	}
+	logger.Infow("initializing audit checkpoint ...", "configmap", namespace+"/"+checkpointConfig.ConfigMap.Name)
+	return auditor.NewConfigMapCheckpointStore(
+		mgr.GetAPIReader(),
+		mgr.GetClient(),
+		namespace,
+		checkpointConfig.ConfigMap.Name,
+	)
 }
 
-func initElasticSearchSink(mgr manager.Manager, elasticsearchSinkConfig configuration.ElasticSink) (*elastic.ElasticSearchSink, error) {
-	if elasticsearchSinkConfig.InsertionMode != "insert" && elasticsearchSinkConfig.InsertionMode != "upsert" {
-		return nil, errors.New("failed to initialize elasticsearch sink, insertion mode should be one of two options: insert or upsert")
+func getAuditSchedules(auditConfig configuration.AuditConfig) ([]auditor.AuditSchedule, error) {
+	spec := auditConfig.Schedule
+	if spec == "" {
+		spec = fmt.Sprintf("%dh", auditConfig.Interval)
 	}
-	sink, err := elastic.NewElasticSearchSink(elasticsearchSinkConfig.Address, elasticsearchSinkConfig.Username, elasticsearchSinkConfig.Password, elasticsearchSinkConfig.IndexName, elasticsearchSinkConfig.InsertionMode)
+	schedule, err := auditor.ParseSchedule(spec)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize elasticsearch sink: %w", err)
+		return nil, err
 	}
+	logger.Infow("audit schedule", "schedule", spec)
+	schedules := []auditor.AuditSchedule{{Schedule: schedule}}
 
-	logger.Info("starting elasticsearch sink ...")
-	mgr.Add(sink)
-
-	return sink, nil
+	for _, kindSchedule := range auditConfig.Schedules {
+		if len(kindSchedule.Kinds) == 0 {
+			return nil, fmt.Errorf("audit schedule %s has no kinds", kindSchedule.Schedule)
+		}
+		schedule, err := auditor.ParseSchedule(kindSchedule.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infow("audit schedule", "schedule", kindSchedule.Schedule, "kinds", kindSchedule.Kinds)
+		schedules = append(schedules, auditor.AuditSchedule{Kinds: kindSchedule.Kinds, Schedule: schedule})
+	}
+	return schedules, nil
 }
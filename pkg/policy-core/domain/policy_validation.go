@@ -14,13 +14,20 @@ import (
 const (
 	PolicyValidationStatusViolating = "Violation"
 	PolicyValidationStatusCompliant = "Compliance"
-	EventActionAllowed              = "Allowed"
-	EventActionRejected             = "Rejected"
-	EventReasonPolicyViolation      = "PolicyViolation"
-	EventReasonPolicyCompliance     = "PolicyCompliance"
-	PolicyValidationTypeLabel       = "pac.weave.works/type"
-	PolicyValidationIDLabel         = "pac.weave.works/id"
-	PolicyValidationTriggerLabel    = "pac.weave.works/trigger"
+	// PolicyValidationStatusMutated is the status of a violation fixed by a mutation, its occurrences are the
+	// fixed occurrences
+	PolicyValidationStatusMutated = "Mutation"
+	// PolicyValidationStatusResolved is the status of a compliance resolving a previous violation
+	PolicyValidationStatusResolved = "Resolved"
+	EventActionAllowed             = "Allowed"
+	EventActionRejected            = "Rejected"
+	EventReasonPolicyViolation     = "PolicyViolation"
+	EventReasonPolicyCompliance    = "PolicyCompliance"
+	EventReasonPolicyResolved      = "PolicyResolved"
+	EventReasonPolicyMutation      = "PolicyMutation"
+	PolicyValidationTypeLabel      = "pac.weave.works/type"
+	PolicyValidationIDLabel        = "pac.weave.works/id"
+	PolicyValidationTriggerLabel   = "pac.weave.works/trigger"
 )
 
 // IaCMetadata defines the values of type iac for validation
@@ -68,7 +75,12 @@ type PolicyValidation struct {
 type PolicyValidationSummary struct {
 	Violations  []PolicyValidation
 	Compliances []PolicyValidation
-	Mutation    *MutationResult
+	// Mutations are the violations fixed by the mutation, only set when mutating
+	Mutations []PolicyValidation
+	Mutation  *MutationResult
+	// SkippedPolicies are the matching policies that were not evaluated because the
+	// validation context was done before their evaluation completed
+	SkippedPolicies []Policy
 }
 
 // GetViolationMessages get all violation messages from review results
@@ -95,11 +107,20 @@ func (v *PolicyValidationSummary) GetViolationOccurrencesMessages() []string {
 func NewK8sEventFromPolicyValidation(result PolicyValidation) (*v1.Event, error) {
 	var reason, action, etype string
 
-	if result.Status == PolicyValidationStatusViolating {
+	switch result.Status {
+	case PolicyValidationStatusViolating:
 		etype = v1.EventTypeWarning
 		reason = EventReasonPolicyViolation
 		action = EventActionRejected
-	} else {
+	case PolicyValidationStatusResolved:
+		etype = v1.EventTypeNormal
+		reason = EventReasonPolicyResolved
+		action = EventActionAllowed
+	case PolicyValidationStatusMutated:
+		etype = v1.EventTypeNormal
+		reason = EventReasonPolicyMutation
+		action = EventActionAllowed
+	default:
 		etype = v1.EventTypeNormal
 		reason = EventReasonPolicyCompliance
 		action = EventActionAllowed
@@ -183,9 +204,14 @@ func NewPolicyValidationFRomK8sEvent(event *v1.Event) (PolicyValidation, error)
 	annotations := event.ObjectMeta.Annotations
 
 	var status string
-	if event.Reason == EventReasonPolicyViolation {
+	switch event.Reason {
+	case EventReasonPolicyViolation:
 		status = PolicyValidationStatusViolating
-	} else {
+	case EventReasonPolicyResolved:
+		status = PolicyValidationStatusResolved
+	case EventReasonPolicyMutation:
+		status = PolicyValidationStatusMutated
+	default:
 		status = PolicyValidationStatusCompliant
 	}
 
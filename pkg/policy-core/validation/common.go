@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func matchEntity(entity domain.Entity, policy domain.Policy) bool {
@@ -95,10 +97,22 @@ func writeToSinks(
 	writeCompliance bool) {
 	for _, resutsSink := range resultsSinks {
 		if len(PolicyValidationSummary.Violations) > 0 {
-			resutsSink.Write(ctx, PolicyValidationSummary.Violations)
+			writeToSink(ctx, resutsSink, PolicyValidationSummary.Violations)
 		}
 		if writeCompliance && len(PolicyValidationSummary.Compliances) > 0 {
-			resutsSink.Write(ctx, PolicyValidationSummary.Compliances)
+			writeToSink(ctx, resutsSink, PolicyValidationSummary.Compliances)
+		}
+		if len(PolicyValidationSummary.Mutations) > 0 {
+			writeToSink(ctx, resutsSink, PolicyValidationSummary.Mutations)
 		}
 	}
 }
+
+// writeToSink writes the results to a sink, the span includes the time the sink blocks the write
+func writeToSink(ctx context.Context, resultsSink domain.PolicyValidationSink, results []domain.PolicyValidation) {
+	ctx, span := tracer.Start(ctx, "PolicyValidationSink.Write", trace.WithAttributes(
+		attribute.String("sink", fmt.Sprintf("%T", resultsSink)),
+		attribute.Int("count", len(results)),
+	))
+	endSpan(span, resultsSink.Write(ctx, results))
+}
@@ -2,6 +2,7 @@ package validation
 
 import (
 	"context"
+	"time"
 
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 )
@@ -11,3 +12,10 @@ type Validator interface {
 	// Validate returns validation results for the specified entity
 	Validate(ctx context.Context, entity domain.Entity, trigger string) (*domain.PolicyValidationSummary, error)
 }
+
+// EvaluationObserver is notified of the policies evaluations, e.g. to record metrics
+type EvaluationObserver interface {
+	// ObserveEvaluation is called after a policy is evaluated against an entity with the evaluation
+	// result and duration, the result is nil when the evaluation failed
+	ObserveEvaluation(validationType string, policy domain.Policy, entity domain.Entity, result *domain.PolicyValidation, err error, duration time.Duration)
+}
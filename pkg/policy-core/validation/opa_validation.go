@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +14,8 @@ import (
 	opa "github.com/weaveworks/policy-agent/pkg/opa-core"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/uuid-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -28,6 +31,7 @@ type OpaValidator struct {
 	accountID       string
 	clusterID       string
 	mutate          bool
+	observers       []EvaluationObserver
 }
 
 // NewOPAValidator returns an opa validator to validate entities
@@ -51,174 +55,124 @@ func NewOPAValidator(
 	}
 }
 
+// RegisterEvaluationObserver adds an observer notified of each policy evaluation
+func (v *OpaValidator) RegisterEvaluationObserver(observer EvaluationObserver) {
+	v.observers = append(v.observers, observer)
+}
+
 // Validate validate policies using opa library, implements validation.Validator
 func (v *OpaValidator) Validate(ctx context.Context, entity domain.Entity, trigger string) (*domain.PolicyValidationSummary, error) {
+	ctx, span := tracer.Start(ctx, "OpaValidator.Validate", trace.WithAttributes(entityAttributes(entity)...))
+	span.SetAttributes(
+		attribute.String("validation.type", v.validationType),
+		attribute.String("validation.trigger", trigger),
+	)
+	summary, err := v.validate(ctx, entity, trigger)
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int("validation.violations", len(summary.Violations)),
+			attribute.Int("validation.compliances", len(summary.Compliances)),
+			attribute.Int("validation.skipped_policies", len(summary.SkippedPolicies)),
+		)
+	}
+	endSpan(span, err)
+	return summary, err
+}
+
+func (v *OpaValidator) validate(ctx context.Context, entity domain.Entity, trigger string) (*domain.PolicyValidationSummary, error) {
 	policies, err := v.policiesSource.GetAll(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get policies from source: %w", err)
 	}
 
-	config, err := v.policiesSource.GetPolicyConfig(ctx, entity)
+	configCtx, configSpan := tracer.Start(ctx, "PoliciesSource.GetPolicyConfig")
+	config, err := v.policiesSource.GetPolicyConfig(configCtx, entity)
+	endSpan(configSpan, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get policy config from source: %w", err)
 	}
 
-	var enqueueGroup sync.WaitGroup
-	var dequeueGroup sync.WaitGroup
-	violationsChan := make(chan domain.PolicyValidation, len(policies))
-	compliancesChan := make(chan domain.PolicyValidation, len(policies))
+	// enforced policies are evaluated first so that when the context is done before all
+	// policies are evaluated, the completed evaluations are the ones that can block the entity
+	sort.SliceStable(policies, func(i, j int) bool {
+		return policies[i].Enforce && !policies[j].Enforce
+	})
+
+	var lock sync.Mutex
+	var group sync.WaitGroup
+	var errs error
+	violations := make([]domain.PolicyValidation, 0)
+	compliances := make([]domain.PolicyValidation, 0)
+	evaluated := make([]bool, len(policies))
+	stopped := false
 
-	errsChan := make(chan error, len(policies))
 	bound := make(chan struct{}, maxWorkers)
 
+schedule:
 	for i := range policies {
-		bound <- struct{}{}
-		enqueueGroup.Add(1)
+		select {
+		case bound <- struct{}{}:
+		case <-ctx.Done():
+			break schedule
+		}
+		group.Add(1)
 		go (func(index int) {
 			defer func() {
 				<-bound
-				enqueueGroup.Done()
+				group.Done()
 			}()
 
-			policy := policies[index]
-			if !matchEntity(entity, policy) {
+			if ctx.Err() != nil {
 				return
 			}
-			if isExcluded(entity, policy) {
+
+			start := time.Now()
+			result, err := v.evaluate(ctx, entity, policies[index], config, trigger)
+			v.observe(policies[index], entity, result, err, time.Since(start))
+
+			lock.Lock()
+			defer lock.Unlock()
+			// results completed after the context is done are discarded
+			if stopped {
 				return
 			}
-
-			opaPolicy, err := opa.Parse(policy.Code, PolicyQuery)
+			evaluated[index] = true
 			if err != nil {
-				errsChan <- fmt.Errorf("failed to parse policy %s: %w", policy.ID, err)
+				errs = multierror.Append(errs, err)
 				return
 			}
-
-			parameters := map[string]interface{}{}
-			if config == nil {
-				parameters = policy.GetParametersMap()
-			} else {
-				policyConfig, policyConfigExists := config.Config[policy.ID]
-				for i, policyParam := range policy.Parameters {
-					parameters[policyParam.Name] = policyParam.Value
-					if policyConfigExists {
-						if configParam, ok := policyConfig.Parameters[policyParam.Name]; ok {
-							logger.Infow(
-								"overriding parameter",
-								"policy", policy.ID,
-								"parameter", policyParam.Name,
-								"oldValue", policyParam.Value,
-								"newValue", configParam.Value,
-								"configRef", configParam.ConfigRef,
-							)
-							parameters[policyParam.Name] = configParam.Value
-							policy.Parameters[i].Value = configParam.Value
-							policy.Parameters[i].ConfigRef = configParam.ConfigRef
-						}
-					}
-				}
+			if result == nil {
+				return
 			}
-
-			var opaErr opa.OPAError
-			err = opaPolicy.EvalGateKeeperCompliant(entity.Manifest, parameters, PolicyQuery)
-			if err != nil {
-				if errors.As(err, &opaErr) {
-					dmsg := fmt.Sprintf(
-						"%s in %s %s",
-						policy.Name,
-						strings.ToLower(entity.Kind),
-						entity.Name,
-					)
-
-					details := opaErr.GetDetails()
-					var occurrences []domain.Occurrence
-					if arr, ok := details.([]interface{}); ok {
-						for _, item := range arr {
-							occurrences = append(occurrences, parseOccurrence(dmsg, item))
-						}
-					} else {
-						occurrences = append(occurrences, parseOccurrence(dmsg, details))
-					}
-
-					message := fmt.Sprintf(
-						"%s in %s %s (%d occurrences)",
-						policy.Name,
-						strings.ToLower(entity.Kind),
-						entity.Name,
-						len(occurrences),
-					)
-					result := domain.PolicyValidation{
-						ID:          uuid.NewV4().String(),
-						AccountID:   v.accountID,
-						ClusterID:   v.clusterID,
-						Policy:      policy,
-						Entity:      entity,
-						Type:        v.validationType,
-						Trigger:     trigger,
-						CreatedAt:   time.Now(),
-						Message:     message,
-						Status:      domain.PolicyValidationStatusViolating,
-						Occurrences: occurrences,
-						Enforced:    policy.Enforce,
-					}
-					violationsChan <- result
-
-				} else {
-					errsChan <- fmt.Errorf(
-						"unable to evaluate resource against policy. policy id: %s. %w",
-						policy.ID,
-						err)
-				}
-
+			if result.Status == domain.PolicyValidationStatusViolating {
+				violations = append(violations, *result)
 			} else {
-				result := domain.PolicyValidation{
-					ID:        uuid.NewV4().String(),
-					AccountID: v.accountID,
-					ClusterID: v.clusterID,
-					Policy:    policy,
-					Entity:    entity,
-					Type:      v.validationType,
-					Trigger:   trigger,
-					CreatedAt: time.Now(),
-					Status:    domain.PolicyValidationStatusCompliant,
-					Enforced:  policy.Enforce,
-				}
-				compliancesChan <- result
+				compliances = append(compliances, *result)
 			}
 		})(i)
 	}
-	violations := make([]domain.PolicyValidation, 0)
-	dequeueGroup.Add(1)
-	go func() {
-		defer dequeueGroup.Done()
-		for violation := range violationsChan {
-			violations = append(violations, violation)
-		}
-	}()
 
-	compliances := make([]domain.PolicyValidation, 0)
-	dequeueGroup.Add(1)
+	done := make(chan struct{})
 	go func() {
-		defer dequeueGroup.Done()
-		for compliance := range compliancesChan {
-			compliances = append(compliances, compliance)
-		}
+		group.Wait()
+		close(done)
 	}()
 
-	var errs error
-	dequeueGroup.Add(1)
-	go func() {
-		defer dequeueGroup.Done()
-		for chanErr := range errsChan {
-			errs = multierror.Append(errs, chanErr)
-		}
-	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
 
-	enqueueGroup.Wait()
-	close(violationsChan)
-	close(compliancesChan)
-	close(errsChan)
-	dequeueGroup.Wait()
+	lock.Lock()
+	stopped = true
+	var skipped []domain.Policy
+	for i, policy := range policies {
+		if evaluated[i] || !matchEntity(entity, policy) || isExcluded(entity, policy) {
+			continue
+		}
+		skipped = append(skipped, policy)
+	}
+	lock.Unlock()
 
 	if errs != nil {
 		return nil, fmt.Errorf(
@@ -229,14 +183,14 @@ func (v *OpaValidator) Validate(ctx context.Context, entity domain.Entity, trigg
 	}
 
 	var mutationResult *domain.MutationResult
-	var unmutatedViolations []domain.PolicyValidation
+	var unmutatedViolations, mutations []domain.PolicyValidation
 
 	if v.mutate {
 		mutationResult, err = domain.NewMutationResult(entity)
 		if err != nil {
 			return nil, err
 		}
-		for i, violation := range violations {
+		for _, violation := range violations {
 			if !violation.Policy.Mutate {
 				continue
 			}
@@ -244,16 +198,21 @@ func (v *OpaValidator) Validate(ctx context.Context, entity domain.Entity, trigg
 			if err != nil {
 				return nil, err
 			}
-			var unmutatedOccurrences []domain.Occurrence
+			var unmutatedOccurrences, mutatedOccurrences []domain.Occurrence
 			for _, occurrence := range occurrences {
-				if !occurrence.Mutated {
+				if occurrence.Mutated {
+					mutatedOccurrences = append(mutatedOccurrences, occurrence)
+				} else {
 					unmutatedOccurrences = append(unmutatedOccurrences, occurrence)
 				}
 			}
+			if len(mutatedOccurrences) > 0 {
+				mutations = append(mutations, newMutation(violation, mutatedOccurrences))
+			}
 			if len(unmutatedOccurrences) == 0 {
 				continue
 			}
-			violations[i].Occurrences = unmutatedOccurrences
+			violation.Occurrences = unmutatedOccurrences
 			unmutatedViolations = append(unmutatedViolations, violation)
 		}
 	} else {
@@ -261,16 +220,167 @@ func (v *OpaValidator) Validate(ctx context.Context, entity domain.Entity, trigg
 	}
 
 	PolicyValidationSummary := domain.PolicyValidationSummary{
-		Violations:  unmutatedViolations,
-		Compliances: compliances,
-		Mutation:    mutationResult,
+		Violations:      unmutatedViolations,
+		Compliances:     compliances,
+		Mutations:       mutations,
+		Mutation:        mutationResult,
+		SkippedPolicies: skipped,
 	}
 
-	writeToSinks(ctx, v.resultsSinks, PolicyValidationSummary, v.writeCompliance)
+	if v.mutate {
+		// the violations left after the mutation are written by the validation of the mutated entity
+		writeToSinks(ctx, v.resultsSinks, domain.PolicyValidationSummary{Mutations: mutations}, false)
+	} else {
+		writeToSinks(ctx, v.resultsSinks, PolicyValidationSummary, v.writeCompliance)
+	}
 
 	return &PolicyValidationSummary, nil
 }
 
+// newMutation returns the mutation result of a violation with the occurrences fixed by the mutation
+func newMutation(violation domain.PolicyValidation, occurrences []domain.Occurrence) domain.PolicyValidation {
+	mutation := violation
+	mutation.ID = uuid.NewV4().String()
+	mutation.Status = domain.PolicyValidationStatusMutated
+	mutation.Message = fmt.Sprintf(
+		"%s in %s %s (%d occurrences mutated)",
+		violation.Policy.Name,
+		strings.ToLower(violation.Entity.Kind),
+		violation.Entity.Name,
+		len(occurrences),
+	)
+	mutation.Occurrences = occurrences
+	return mutation
+}
+
+// observe notifies the observers of a policy evaluation, policies not matching the entity are not observed
+func (v *OpaValidator) observe(policy domain.Policy, entity domain.Entity, result *domain.PolicyValidation, err error, duration time.Duration) {
+	if result == nil && err == nil {
+		return
+	}
+	for _, observer := range v.observers {
+		observer.ObserveEvaluation(v.validationType, policy, entity, result, err, duration)
+	}
+}
+
+// evaluate evaluates a policy against the entity, returns nil if the policy doesn't match the entity
+func (v *OpaValidator) evaluate(ctx context.Context, entity domain.Entity, policy domain.Policy, config *domain.PolicyConfig, trigger string) (*domain.PolicyValidation, error) {
+	if !matchEntity(entity, policy) {
+		return nil, nil
+	}
+	if isExcluded(entity, policy) {
+		return nil, nil
+	}
+
+	_, span := tracer.Start(ctx, "OpaValidator.evaluate", trace.WithAttributes(
+		attribute.String("policy.id", policy.ID),
+		attribute.String("policy.severity", policy.Severity),
+	))
+	result, err := v.evaluatePolicy(entity, policy, config, trigger)
+	if result != nil {
+		span.SetAttributes(attribute.String("validation.status", result.Status))
+	}
+	endSpan(span, err)
+	return result, err
+}
+
+// evaluatePolicy evaluates a policy matching the entity
+func (v *OpaValidator) evaluatePolicy(entity domain.Entity, policy domain.Policy, config *domain.PolicyConfig, trigger string) (*domain.PolicyValidation, error) {
+
+	opaPolicy, err := opa.Parse(policy.Code, PolicyQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", policy.ID, err)
+	}
+
+	parameters := map[string]interface{}{}
+	if config == nil {
+		parameters = policy.GetParametersMap()
+	} else {
+		policyConfig, policyConfigExists := config.Config[policy.ID]
+		for i, policyParam := range policy.Parameters {
+			parameters[policyParam.Name] = policyParam.Value
+			if policyConfigExists {
+				if configParam, ok := policyConfig.Parameters[policyParam.Name]; ok {
+					logger.Infow(
+						"overriding parameter",
+						"policy", policy.ID,
+						"parameter", policyParam.Name,
+						"oldValue", policyParam.Value,
+						"newValue", configParam.Value,
+						"configRef", configParam.ConfigRef,
+					)
+					parameters[policyParam.Name] = configParam.Value
+					policy.Parameters[i].Value = configParam.Value
+					policy.Parameters[i].ConfigRef = configParam.ConfigRef
+				}
+			}
+		}
+	}
+
+	var opaErr opa.OPAError
+	err = opaPolicy.EvalGateKeeperCompliant(entity.Manifest, parameters, PolicyQuery)
+	if err != nil {
+		if !errors.As(err, &opaErr) {
+			return nil, fmt.Errorf(
+				"unable to evaluate resource against policy. policy id: %s. %w",
+				policy.ID,
+				err)
+		}
+
+		dmsg := fmt.Sprintf(
+			"%s in %s %s",
+			policy.Name,
+			strings.ToLower(entity.Kind),
+			entity.Name,
+		)
+
+		details := opaErr.GetDetails()
+		var occurrences []domain.Occurrence
+		if arr, ok := details.([]interface{}); ok {
+			for _, item := range arr {
+				occurrences = append(occurrences, parseOccurrence(dmsg, item))
+			}
+		} else {
+			occurrences = append(occurrences, parseOccurrence(dmsg, details))
+		}
+
+		message := fmt.Sprintf(
+			"%s in %s %s (%d occurrences)",
+			policy.Name,
+			strings.ToLower(entity.Kind),
+			entity.Name,
+			len(occurrences),
+		)
+		return &domain.PolicyValidation{
+			ID:          uuid.NewV4().String(),
+			AccountID:   v.accountID,
+			ClusterID:   v.clusterID,
+			Policy:      policy,
+			Entity:      entity,
+			Type:        v.validationType,
+			Trigger:     trigger,
+			CreatedAt:   time.Now(),
+			Message:     message,
+			Status:      domain.PolicyValidationStatusViolating,
+			Occurrences: occurrences,
+			Enforced:    policy.Enforce,
+		}, nil
+	}
+
+	return &domain.PolicyValidation{
+		ID:        uuid.NewV4().String(),
+		AccountID: v.accountID,
+		ClusterID: v.clusterID,
+		Policy:    policy,
+		Entity:    entity,
+		Type:      v.validationType,
+		Trigger:   trigger,
+		CreatedAt: time.Now(),
+		Status:    domain.PolicyValidationStatusCompliant,
+		Enforced:  policy.Enforce,
+	}, nil
+}
+
 func parseOccurrence(msg string, in interface{}) domain.Occurrence {
 	occurrence := domain.Occurrence{Message: msg}
 	if v, ok := in.(map[string]interface{}); ok {
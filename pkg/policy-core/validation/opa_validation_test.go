@@ -5,13 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain/mock"
 	"github.com/weaveworks/policy-agent/pkg/policy-core/validation/testdata"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestNewOPAValidator(t *testing.T) {
@@ -116,6 +122,7 @@ func TestOpaValidator_Mutate(t *testing.T) {
 		entity      domain.Entity
 		violations  int
 		occurrences int
+		mutations   int
 	}{
 		{
 			name: "mutate all violations",
@@ -128,13 +135,21 @@ func TestOpaValidator_Mutate(t *testing.T) {
 					}, nil)
 					policiesSource.EXPECT().GetPolicyConfig(gomock.Any(), gomock.Any()).
 						Times(1).Return(nil, nil)
+					// only the mutations are written, the violations are written by the validation of the mutated entity
 					sink.EXPECT().Write(gomock.Any(), gomock.Any()).
-						Times(0).Return(nil)
+						Times(1).DoAndReturn(func(_ context.Context, results []domain.PolicyValidation) error {
+						assert.Len(results, 1)
+						assert.Equal(domain.PolicyValidationStatusMutated, results[0].Status)
+						assert.Len(results[0].Occurrences, 1)
+						assert.True(results[0].Occurrences[0].Mutated)
+						return nil
+					})
 				},
 			},
 			entity:      entity,
 			occurrences: 0,
 			violations:  0,
+			mutations:   1,
 		},
 	}
 
@@ -160,6 +175,7 @@ func TestOpaValidator_Mutate(t *testing.T) {
 
 			assert.NotNil(result.Mutation)
 			assert.Equal(tt.violations, len(result.Violations))
+			assert.Equal(tt.mutations, len(result.Mutations))
 			if tt.occurrences > 0 {
 				assert.Equal(tt.occurrences, len(result.Violations[0].Occurrences))
 			}
@@ -807,3 +823,141 @@ func TestOpaValidator_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestOpaValidator_ValidateContextDone(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	entity, err := getEntityFromStringSpec(testdata.Entity)
+	assert.Nil(err)
+
+	policiesSource := mock.NewMockPoliciesSource(ctrl)
+	policiesSource.EXPECT().GetAll(gomock.Any()).
+		Times(1).Return([]domain.Policy{
+		testdata.Policies["imageTag"],
+		testdata.Policies["missingOwner"],
+	}, nil)
+	policiesSource.EXPECT().GetPolicyConfig(gomock.Any(), gomock.Any()).
+		Times(1).Return(nil, nil)
+	sink := mock.NewMockPolicyValidationSink(ctrl)
+	sink.EXPECT().Write(gomock.Any(), gomock.Any()).Times(0)
+
+	v := NewOPAValidator(policiesSource, false, "unit-test", "", "", false, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got, err := v.Validate(ctx, entity, "unit-test")
+	assert.Nil(err)
+	assert.Empty(got.Violations)
+	assert.Empty(got.Compliances)
+	assert.Len(got.SkippedPolicies, 2)
+}
+
+type evaluation struct {
+	validationType string
+	policy         string
+	status         string
+}
+
+type evaluationRecorder struct {
+	lock        sync.Mutex
+	evaluations []evaluation
+}
+
+func (r *evaluationRecorder) ObserveEvaluation(validationType string, policy domain.Policy, _ domain.Entity, result *domain.PolicyValidation, err error, _ time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	status := "error"
+	if err == nil {
+		status = result.Status
+	}
+	r.evaluations = append(r.evaluations, evaluation{validationType: validationType, policy: policy.ID, status: status})
+}
+
+func TestOpaValidator_RegisterEvaluationObserver(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	entity, err := getEntityFromStringSpec(testdata.Entity)
+	assert.Nil(err)
+
+	notMatching := testdata.Policies["missingOwner"]
+	notMatching.ID = "not-matching"
+	notMatching.Targets.Kinds = []string{"CronJob"}
+
+	policiesSource := mock.NewMockPoliciesSource(ctrl)
+	policiesSource.EXPECT().GetAll(gomock.Any()).
+		Times(1).Return([]domain.Policy{
+		testdata.Policies["imageTag"],
+		testdata.Policies["missingOwner"],
+		notMatching,
+	}, nil)
+	policiesSource.EXPECT().GetPolicyConfig(gomock.Any(), gomock.Any()).
+		Times(1).Return(nil, nil)
+
+	v := NewOPAValidator(policiesSource, false, "unit-test", "", "", false)
+	recorder := &evaluationRecorder{}
+	v.RegisterEvaluationObserver(recorder)
+
+	_, err = v.Validate(context.Background(), entity, "unit-test")
+	assert.Nil(err)
+
+	// policies not matching the entity are not observed
+	assert.ElementsMatch([]evaluation{
+		{validationType: "unit-test", policy: testdata.Policies["imageTag"].ID, status: domain.PolicyValidationStatusViolating},
+		{validationType: "unit-test", policy: testdata.Policies["missingOwner"].ID, status: domain.PolicyValidationStatusViolating},
+	}, recorder.evaluations)
+}
+
+func TestOpaValidator_ValidateTracing(t *testing.T) {
+	assert := require.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	entity, err := getEntityFromStringSpec(testdata.Entity)
+	assert.Nil(err)
+
+	policiesSource := mock.NewMockPoliciesSource(ctrl)
+	policiesSource.EXPECT().GetAll(gomock.Any()).
+		Times(1).Return([]domain.Policy{
+		testdata.Policies["imageTag"],
+		testdata.Policies["missingOwner"],
+	}, nil)
+	policiesSource.EXPECT().GetPolicyConfig(gomock.Any(), gomock.Any()).
+		Times(1).Return(nil, nil)
+	sink := mock.NewMockPolicyValidationSink(ctrl)
+	sink.EXPECT().Write(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+	v := NewOPAValidator(policiesSource, false, "unit-test", "", "", false, sink)
+	_, err = v.Validate(context.Background(), entity, "unit-test")
+	assert.Nil(err)
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	assert.Len(spans["OpaValidator.Validate"], 1)
+	assert.Len(spans["PoliciesSource.GetPolicyConfig"], 1)
+	assert.Len(spans["OpaValidator.evaluate"], 2)
+	assert.Len(spans["PolicyValidationSink.Write"], 1)
+
+	// the spans are children of the validation span
+	validate := spans["OpaValidator.Validate"][0]
+	assert.Contains(validate.Attributes(), attribute.Int("validation.violations", 2))
+	for name, children := range spans {
+		if name == "OpaValidator.Validate" {
+			continue
+		}
+		for _, span := range children {
+			assert.Equal(validate.SpanContext().SpanID(), span.Parent().SpanID(), name)
+		}
+	}
+	assert.Contains(spans["OpaValidator.evaluate"][0].Attributes(), attribute.String("validation.status", domain.PolicyValidationStatusViolating))
+	assert.Contains(spans["PolicyValidationSink.Write"][0].Attributes(), attribute.Int("count", 2))
+}
@@ -0,0 +1,30 @@
+package validation
+
+import (
+	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer uses the global tracer provider, spans are not recorded unless the application sets a provider
+var tracer = otel.Tracer("github.com/weaveworks/policy-agent/pkg/policy-core/validation")
+
+func entityAttributes(entity domain.Entity) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("entity.api_version", entity.APIVersion),
+		attribute.String("entity.kind", entity.Kind),
+		attribute.String("entity.namespace", entity.Namespace),
+		attribute.String("entity.name", entity.Name),
+	}
+}
+
+// endSpan records the error of the traced operation if any and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/weaveworks/policy-agent/pkg/policy-core/domain"
 
 	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/yaml"
@@ -47,6 +48,34 @@ func TestIntegration(t *testing.T) {
 
 	ctx := context.Background()
 
+	t.Run("check agent permissions", func(t *testing.T) {
+		namespace := os.Getenv("NAMESPACE")
+		permissions := []struct {
+			attributes authorizationv1.ResourceAttributes
+			allowed    bool
+		}{
+			// the policy reports sink lists the reports of all the namespaces to prune them
+			{authorizationv1.ResourceAttributes{Group: "wgpolicyk8s.io", Resource: "policyreports", Verb: "list"}, true},
+			{authorizationv1.ResourceAttributes{Group: "wgpolicyk8s.io", Resource: "policyreports", Verb: "update", Namespace: "default"}, true},
+			{authorizationv1.ResourceAttributes{Group: "wgpolicyk8s.io", Resource: "clusterpolicyreports", Verb: "update"}, true},
+			// only the audit checkpoint configmap of the agent namespace is accessed
+			{authorizationv1.ResourceAttributes{Resource: "configmaps", Verb: "create", Namespace: namespace}, true},
+			{authorizationv1.ResourceAttributes{Resource: "configmaps", Verb: "update", Namespace: namespace, Name: "policy-agent-audit-checkpoint"}, true},
+			{authorizationv1.ResourceAttributes{Resource: "configmaps", Verb: "delete", Namespace: namespace, Name: "policy-agent-audit-checkpoint"}, true},
+			{authorizationv1.ResourceAttributes{Resource: "configmaps", Verb: "get", Namespace: namespace, Name: "policy-agent-config"}, false},
+			{authorizationv1.ResourceAttributes{Resource: "configmaps", Verb: "delete", Namespace: "default", Name: "policy-agent-audit-checkpoint"}, false},
+			{authorizationv1.ResourceAttributes{Resource: "configmaps", Verb: "list"}, false},
+		}
+		for _, permission := range permissions {
+			allowed, err := agentAllowed(ctx, cl, permission.attributes)
+			assert.Nil(t, err)
+			assert.Equal(
+				t, permission.allowed, allowed, "agent permission to %s %s %q in namespace %q",
+				permission.attributes.Verb, permission.attributes.Resource, permission.attributes.Name, permission.attributes.Namespace,
+			)
+		}
+	})
+
 	t.Run("check audit results", func(t *testing.T) {
 		opts := []client.ListOption{
 			client.MatchingFields{
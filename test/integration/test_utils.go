@@ -3,8 +3,10 @@ package integration
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 
+	authorizationv1 "k8s.io/api/authorization/v1"
 	v1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -23,3 +25,17 @@ func listViolationEvents(ctx context.Context, c client.Client, opts []client.Lis
 	}
 	return &events, nil
 }
+
+// agentAllowed returns whether the agent service account deployed by the chart is allowed to perform the action
+func agentAllowed(ctx context.Context, c client.Client, attributes authorizationv1.ResourceAttributes) (bool, error) {
+	review := authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               fmt.Sprintf("system:serviceaccount:%s:policy-agent", os.Getenv("NAMESPACE")),
+			ResourceAttributes: &attributes,
+		},
+	}
+	if err := c.Create(ctx, &review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}